@@ -0,0 +1,420 @@
+package handler
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+
+	"you2api/inband"
+	"you2api/keys"
+	"you2api/pool"
+	"you2api/ratelimit"
+	"you2api/youclient"
+)
+
+// accountPool 是服务端配置的 DS token 账号池（见 pool.ConfigFromEnv）。
+// 未配置时为 nil，客户端需要在 Authorization 中直接提供 DS token。
+var accountPool *pool.Pool
+
+// poolAccessKey 是未配置本地 API key 时访问账号池所需的共享密钥，来自环境变量 POOL_ACCESS_KEY。
+// 账号池不对匿名请求开放：既没有本地 API key 也没有共享密钥时不启用账号池。
+var poolAccessKey = os.Getenv("POOL_ACCESS_KEY")
+
+// accountsAdminKey 是读取账号池状态所需的密钥，来自环境变量 ADMIN_KEY，为空时不提供状态接口。
+var accountsAdminKey = os.Getenv("ADMIN_KEY")
+
+// initAccounts 从环境变量加载账号池与本地 API key。模型白名单的检查依赖 Agent 模型 ID，
+// 因此在 initAgentModelIDs 之后调用。
+func initAccounts() {
+	initAccountPool()
+	initKeyStore()
+	if accountPool != nil && keyStore == nil && poolAccessKey == "" {
+		fmt.Println("账号池需要配置 API_KEYS_FILE 或 POOL_ACCESS_KEY，未启用账号池")
+		accountPool = nil
+	}
+	if ollamaDSToken != "" && (accountPool != nil || keyStore != nil) {
+		fmt.Println("已配置账号池或 API key，忽略 OLLAMA_DS_TOKEN")
+		ollamaDSToken = ""
+	}
+}
+
+func initAccountPool() {
+	cfg, ok, err := pool.ConfigFromEnv()
+	if err != nil {
+		fmt.Printf("加载账号池配置失败: %v\n", err)
+		return
+	}
+	if !ok {
+		return
+	}
+	p, err := pool.New(cfg)
+	if err != nil {
+		fmt.Printf("创建账号池失败: %v\n", err)
+		return
+	}
+	accountPool = p
+	fmt.Printf("已启用账号池: %d 个账号，策略=%s\n", len(cfg.Accounts), p.Strategy())
+}
+
+// keyStore 是本地签发的 API key（见 keys.ConfigFromEnv）。配置后客户端必须使用其中的 key，
+// 不再接受原始 DS token，请求只会使用 key 对应的账号池账号。
+var keyStore *keys.Store
+
+func initKeyStore() {
+	cfg, ok, err := keys.ConfigFromEnv()
+	if err != nil {
+		fmt.Printf("加载 API key 配置失败: %v\n", err)
+		return
+	}
+	if !ok {
+		return
+	}
+	store, err := keys.New(cfg)
+	if err != nil {
+		fmt.Printf("创建 API key 失败: %v\n", err)
+		return
+	}
+	keyStore = store
+	fmt.Printf("已启用本地 API key: %d 个\n", len(cfg.Keys))
+	checkKeys(store)
+}
+
+// checkKeys 检查 key 引用的账号、分组与模型是否存在，只打印警告。
+func checkKeys(store *keys.Store) {
+	if accountPool == nil {
+		fmt.Println("警告: 本地 API key 需要配置账号池，当前所有请求都将失败")
+		return
+	}
+	accounts := make(map[string]bool)
+	groups := make(map[string]bool)
+	for _, status := range accountPool.Status() {
+		accounts[status.Name] = true
+		groups[status.Group] = true
+	}
+	models := make(map[string]bool)
+	for openAIModel, youModel := range modelMap {
+		models[openAIModel] = true
+		models[youModel] = true
+	}
+	for _, agentID := range agentModelIDs {
+		models[agentID] = true
+	}
+
+	for _, key := range store.Keys() {
+		if key.Account != "" && !accounts[key.Account] {
+			fmt.Printf("警告: API key %s 引用了不存在的账号 %s\n", key.Label, key.Account)
+		}
+		if key.Group != "" && !groups[key.Group] {
+			fmt.Printf("警告: API key %s 引用了不存在的分组 %s\n", key.Label, key.Group)
+		}
+		for _, model := range key.Models {
+			if !models[model] {
+				fmt.Printf("警告: API key %s 允许的模型 %s 不存在\n", key.Label, model)
+			}
+		}
+	}
+}
+
+// errMissingAuth 表示客户端没有提供凭据（DS token 或本地 API key）。
+var errMissingAuth = errors.New("Missing or invalid authorization header")
+
+// modelNotFoundError 表示 API key 不允许使用请求的模型。
+type modelNotFoundError struct {
+	model string
+}
+
+func (e *modelNotFoundError) Error() string {
+	return fmt.Sprintf("The model `%s` does not exist or you do not have access to it.", e.model)
+}
+
+// authorizeCredential 校验客户端凭据，凭据不能为空。配置了本地 API key 时返回对应的 key；
+// 否则有账号池时凭据必须是 poolAccessKey，没有账号池时凭据本身就是 DS token。
+func authorizeCredential(credential string) (*keys.Key, error) {
+	if credential == "" {
+		return nil, errMissingAuth
+	}
+	if keyStore != nil {
+		return keyStore.Lookup(credential)
+	}
+	if accountPool != nil && subtle.ConstantTimeCompare([]byte(credential), []byte(poolAccessKey)) != 1 {
+		return nil, keys.ErrInvalidKey
+	}
+	return nil, nil
+}
+
+// requestKey 返回请求使用的本地 API key，未使用本地 API key 或 key 无效时返回 nil。
+func requestKey(r *http.Request) *keys.Key {
+	credential, ok := extractDSToken(r)
+	if !ok {
+		return nil
+	}
+	key, err := authorizeCredential(credential)
+	if err != nil {
+		return nil
+	}
+	return key
+}
+
+// modelNames 返回用于匹配模型白名单的名称：请求的模型名称及其对应的 You.com 模型 ID。
+// 未知模型不返回默认模型，避免白名单中的默认模型放行任意名称。
+func modelNames(model string) []string {
+	if youModel, ok := modelMap[model]; ok {
+		return []string{model, youModel}
+	}
+	return []string{model}
+}
+
+// upstreamAccount 是一次请求使用的 You.com 账号：账号池中的账号，或客户端直接提供的 DS token。
+type upstreamAccount struct {
+	token string      // 发送给 You.com 的 DS token
+	owner string      // 客户端凭据，用于区分不同客户端保存的数据（如 Responses API 的响应）
+	key   *keys.Key   // 使用本地 API key 时不为 nil
+	lease *pool.Lease // 来自账号池时不为 nil
+
+	keyPermit     *ratelimit.Permit // API key 设置了限额时不为 nil
+	accountPermit *ratelimit.Permit // 账号设置了限额时不为 nil
+
+	selector pool.Selector // 从账号池选择账号的条件，重试时排除已失败的账号
+	stream   bool
+}
+
+// accountRequest 描述一次请求对上游账号的需求。
+type accountRequest struct {
+	credential string // 客户端凭据：DS token、本地 API key 或 POOL_ACCESS_KEY
+	model      string // 请求的模型，不为空时检查 API key 的模型白名单
+	preferred  string // 优先使用的账号池账号
+	stream     bool   // 流式请求会占用一个并发流额度
+}
+
+// acquireAccount 校验凭据、检查限额并为请求选择账号。配置了账号池时从池中选择（限定为 API key
+// 对应的账号或分组，优先 preferred，跳过已超出限额的账号），credential 只用作 owner；
+// 否则 credential 就是 DS token。成功时在 w 中写入 x-ratelimit-* 头部。使用完毕后必须调用 release。
+func acquireAccount(w http.ResponseWriter, req accountRequest) (*upstreamAccount, error) {
+	key, err := authorizeCredential(req.credential)
+	if err != nil {
+		return nil, err
+	}
+	var sel pool.Selector
+	if key != nil {
+		if req.model != "" && !key.AllowsModel(modelNames(req.model)...) {
+			return nil, &modelNotFoundError{model: req.model}
+		}
+		sel = pool.Selector{Account: key.Account, Group: key.Group}
+	}
+
+	if accountPool == nil {
+		if key != nil {
+			return nil, pool.ErrNoAccounts
+		}
+		return &upstreamAccount{token: req.credential, owner: req.credential}, nil
+	}
+
+	sel.Preferred = req.preferred
+	account := &upstreamAccount{owner: req.credential, key: key, selector: sel, stream: req.stream}
+	if key != nil && key.Limits.Enabled() {
+		if account.keyPermit, err = keyLimiter.Allow(key.Key, key.Limits, req.stream); err != nil {
+			return nil, err
+		}
+	}
+	if err := account.acquireLease(); err != nil {
+		account.release()
+		return nil, err
+	}
+	account.writeRateLimitHeaders(w)
+	return account, nil
+}
+
+// acquireLease 从账号池中选择满足 selector 的账号。账号超出限额时换用其他账号，
+// 所有可选账号都超出时返回最后一个限额错误。
+func (a *upstreamAccount) acquireLease() error {
+	sel := a.selector
+	var limitErr error
+	for {
+		lease, err := accountPool.AcquireSelected(sel)
+		if err != nil {
+			if limitErr != nil {
+				return limitErr
+			}
+			return err
+		}
+		if limits := lease.Limits(); limits.Enabled() {
+			permit, err := accountLimiter.Allow(lease.Name(), limits, a.stream)
+			if err != nil {
+				lease.Release()
+				sel.Exclude = append(sel.Exclude, lease.Name())
+				limitErr = err
+				continue
+			}
+			a.accountPermit = permit
+		}
+		a.token = lease.Token()
+		a.lease = lease
+		return nil
+	}
+}
+
+// switchAccount 为重试换用账号池中的另一个账号，没有其他可用账号时再次尝试当前账号。
+// 客户端直接提供的 DS token 没有可换用的账号，保持不变。
+func (a *upstreamAccount) switchAccount() error {
+	if a.lease == nil {
+		return nil
+	}
+	failed := a.lease.Name()
+	a.releaseLease()
+	a.selector.Preferred = ""
+	a.selector.Exclude = append(a.selector.Exclude, failed)
+	if err := a.acquireLease(); err == nil {
+		return nil
+	}
+	a.selector.Exclude = nil
+	return a.acquireLease()
+}
+
+// name 返回账号名称；客户端直接提供的 DS token 返回其摘要。
+func (a *upstreamAccount) name() string {
+	if a.lease != nil {
+		return a.lease.Name()
+	}
+	return keyLabel(a.token)
+}
+
+// report 根据上游错误（包括回答中识别出的额度、鉴权提示）冷却或移除账号池中的账号，
+// 其他错误与非账号池账号忽略。
+func (a *upstreamAccount) report(err error) {
+	if a.lease == nil {
+		return
+	}
+	var inBandErr *inBandError
+	if errors.As(err, &inBandErr) {
+		switch inBandErr.kind {
+		case inband.KindQuota:
+			a.lease.Fail(pool.ReasonQuota)
+		case inband.KindAuth:
+			a.lease.Fail(pool.ReasonAuth)
+		}
+		return
+	}
+	var statusErr *youclient.StatusError
+	if !errors.As(err, &statusErr) {
+		return
+	}
+	if reason, ok := pool.Classify(statusErr.StatusCode, statusErr.Body); ok {
+		a.lease.Fail(reason)
+	}
+}
+
+// release 归还账号池中的账号并释放占用的并发流额度，可重复调用。
+func (a *upstreamAccount) release() {
+	a.releaseLease()
+	if a.keyPermit != nil {
+		a.keyPermit.Release()
+	}
+}
+
+// releaseLease 归还账号池中的账号及其限额占用。
+func (a *upstreamAccount) releaseLease() {
+	if a.lease != nil {
+		a.lease.Release()
+	}
+	if a.accountPermit != nil {
+		a.accountPermit.Release()
+		a.accountPermit = nil
+	}
+}
+
+// label 返回 API key 的标签，未使用本地 API key 时为空。
+func (a *upstreamAccount) label() string {
+	if a.key != nil {
+		return a.key.Label
+	}
+	return ""
+}
+
+// accountErrorStatus 返回 acquireAccount 错误对应的状态码：凭据缺失或无效为 401，
+// 模型不在白名单中为 404，超出限额为 429（同时设置 Retry-After 与 x-ratelimit-* 头部），
+// 账号池暂无可用账号为 503，全部账号冷却中时同时设置 Retry-After。
+func accountErrorStatus(w http.ResponseWriter, err error) int {
+	if errors.Is(err, errMissingAuth) || errors.Is(err, keys.ErrInvalidKey) || errors.Is(err, keys.ErrExpiredKey) {
+		return http.StatusUnauthorized
+	}
+	var notFound *modelNotFoundError
+	if errors.As(err, &notFound) {
+		return http.StatusNotFound
+	}
+	var limitErr *ratelimit.LimitError
+	if errors.As(err, &limitErr) {
+		writeRateLimitHeaders(w.Header(), limitErr.Status)
+		w.Header().Set("Retry-After", retryAfterSeconds(limitErr.RetryAfter))
+		return http.StatusTooManyRequests
+	}
+	var coolingDown *pool.CoolingDownError
+	if errors.As(err, &coolingDown) {
+		w.Header().Set("Retry-After", retryAfterSeconds(coolingDown.RetryAfter))
+	}
+	return http.StatusServiceUnavailable
+}
+
+// writeAccountError 以 OpenAI 错误格式返回 acquireAccount 的错误。
+func writeAccountError(w http.ResponseWriter, err error) {
+	status := accountErrorStatus(w, err)
+	switch status {
+	case http.StatusUnauthorized:
+		message := "Incorrect API key provided."
+		switch {
+		case errors.Is(err, errMissingAuth):
+			message = err.Error()
+		case errors.Is(err, keys.ErrExpiredKey):
+			message = "The API key provided has expired."
+		}
+		writeOpenAIError(w, status, "invalid_request_error", "invalid_api_key", message)
+	case http.StatusNotFound:
+		writeOpenAIError(w, status, "invalid_request_error", "model_not_found", err.Error())
+	case http.StatusTooManyRequests:
+		var limitErr *ratelimit.LimitError
+		errors.As(err, &limitErr)
+		errType := "requests"
+		if limitErr.Kind == ratelimit.KindTokens {
+			errType = "tokens"
+		}
+		writeOpenAIError(w, status, errType, "rate_limit_exceeded", err.Error())
+	default:
+		writeOpenAIError(w, status, "api_error", "", err.Error())
+	}
+}
+
+// AccountsStatus 定义了 /v1/accounts/status 的响应结构。
+type AccountsStatus struct {
+	Enabled  bool                 `json:"enabled"`
+	Strategy pool.Strategy        `json:"strategy,omitempty"`
+	Accounts []pool.AccountStatus `json:"accounts"`
+}
+
+// handleAccountsStatus 返回账号池中各账号的状态（不包含 token），需要以
+// Authorization: Bearer <ADMIN_KEY> 访问。未设置 ADMIN_KEY 时返回 404。
+func handleAccountsStatus(w http.ResponseWriter, r *http.Request) {
+	if accountsAdminKey == "" {
+		writeOpenAIError(w, http.StatusNotFound, "invalid_request_error", "", "Not found")
+		return
+	}
+	if r.Method != http.MethodGet {
+		writeOpenAIError(w, http.StatusMethodNotAllowed, "invalid_request_error", "", "Method not allowed")
+		return
+	}
+	credential, _ := extractDSToken(r)
+	if subtle.ConstantTimeCompare([]byte(credential), []byte(accountsAdminKey)) != 1 {
+		writeOpenAIError(w, http.StatusUnauthorized, "invalid_request_error", "invalid_api_key", "Invalid admin key")
+		return
+	}
+
+	status := AccountsStatus{Accounts: []pool.AccountStatus{}}
+	if accountPool != nil {
+		status.Enabled = true
+		status.Strategy = accountPool.Strategy()
+		status.Accounts = accountPool.Status()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
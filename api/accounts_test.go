@@ -0,0 +1,349 @@
+package handler
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"you2api/keys"
+	"you2api/metrics"
+	"you2api/pool"
+	"you2api/ratelimit"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// useAccountPool 让 accountPool 指向由 accounts 创建的账号池，并以 chatRequest 使用的
+// test-token 作为 poolAccessKey，测试结束后恢复。
+func useAccountPool(t *testing.T, accounts ...pool.AccountConfig) *pool.Pool {
+	t.Helper()
+	p, err := pool.New(pool.Config{Accounts: accounts})
+	if err != nil {
+		t.Fatalf("pool.New() error = %v", err)
+	}
+	previousPool, previousKey := accountPool, poolAccessKey
+	accountPool, poolAccessKey = p, "test-token"
+	t.Cleanup(func() { accountPool, poolAccessKey = previousPool, previousKey })
+	return p
+}
+
+func TestHandlerAccountPool(t *testing.T) {
+	useAccountPool(t, pool.AccountConfig{Name: "bad", Token: "bad-token"}, pool.AccountConfig{Name: "good", Token: "good-token"})
+	newFakeYou(t, func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.Header.Get("Cookie"), "DS=good-token") {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		io.WriteString(w, youTokens("ok"))
+	})
+
+	body := `{"model": "gpt-4o", "messages": [{"role": "user", "content": "hi"}]}`
+
+	// 账号池不对匿名请求或错误的共享密钥开放
+	for _, credential := range []string{"", "wrong-key"} {
+		req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body))
+		if credential != "" {
+			req.Header.Set("Authorization", "Bearer "+credential)
+		}
+		rec := httptest.NewRecorder()
+		Handler(rec, req)
+		if rec.Code != http.StatusUnauthorized {
+			t.Fatalf("credential %q: status = %d, want 401", credential, rec.Code)
+		}
+	}
+
+	var codes []int
+	for i := 0; i < 3; i++ {
+		// 使用 POOL_ACCESS_KEY 访问账号池，不需要提供 DS token
+		rec := httptest.NewRecorder()
+		Handler(rec, chatRequest(body))
+		codes = append(codes, rec.Code)
+	}
+	// 第一次轮换到 bad 账号失败并使其冷却，之后只会使用 good 账号
+	if codes[0] != http.StatusUnauthorized || codes[1] != http.StatusOK || codes[2] != http.StatusOK {
+		t.Errorf("status codes = %v, want [401 200 200]", codes)
+	}
+
+	// 未设置 ADMIN_KEY 时不提供状态接口，设置后需要提供 ADMIN_KEY
+	rec := httptest.NewRecorder()
+	Handler(rec, httptest.NewRequest(http.MethodGet, "/v1/accounts/status", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("status without ADMIN_KEY = %d, want 404", rec.Code)
+	}
+	previous := accountsAdminKey
+	accountsAdminKey = "admin"
+	t.Cleanup(func() { accountsAdminKey = previous })
+	rec = httptest.NewRecorder()
+	Handler(rec, httptest.NewRequest(http.MethodGet, "/v1/accounts/status", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("status without credential = %d, want 401", rec.Code)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/accounts/status", nil)
+	req.Header.Set("Authorization", "Bearer admin")
+	rec = httptest.NewRecorder()
+	Handler(rec, req)
+	var status AccountsStatus
+	if err := json.Unmarshal(rec.Body.Bytes(), &status); err != nil {
+		t.Fatalf("decode status: %v (%s)", err, rec.Body.String())
+	}
+	if strings.Contains(rec.Body.String(), "good-token") {
+		t.Errorf("status leaks token: %s", rec.Body.String())
+	}
+	states := map[string]string{}
+	for _, account := range status.Accounts {
+		states[account.Name] = account.State
+	}
+	if !status.Enabled || states["bad"] != "cooling_down" || states["good"] != "active" {
+		t.Errorf("status = %+v", status)
+	}
+}
+
+func TestHandlerAccountPoolCoolingDown(t *testing.T) {
+	p := useAccountPool(t, pool.AccountConfig{Name: "only", Token: "token"})
+	lease, _ := p.Acquire()
+	lease.Fail(pool.ReasonRateLimit)
+	lease.Release()
+
+	rec := httptest.NewRecorder()
+	Handler(rec, chatRequest(`{"model": "gpt-4o", "messages": [{"role": "user", "content": "hi"}]}`))
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") == "" {
+		t.Errorf("status = %d, Retry-After = %q, want 503 with Retry-After", rec.Code, rec.Header().Get("Retry-After"))
+	}
+}
+
+// useKeyStore 让 keyStore 指向由 cfg 创建的 API key 集合，测试结束后恢复。
+func useKeyStore(t *testing.T, cfg keys.Config) {
+	t.Helper()
+	store, err := keys.New(cfg)
+	if err != nil {
+		t.Fatalf("keys.New() error = %v", err)
+	}
+	previous := keyStore
+	keyStore = store
+	t.Cleanup(func() { keyStore = previous })
+}
+
+func TestHandlerAPIKeys(t *testing.T) {
+	useAccountPool(t, pool.AccountConfig{Name: "a", Token: "ta", Group: "team"}, pool.AccountConfig{Name: "b", Token: "tb"})
+	expired := time.Now().Add(-time.Hour)
+	useKeyStore(t, keys.Config{Keys: []keys.Key{
+		{Key: "sk-team", Label: "team", Group: "team", Models: []string{"gpt-4o", "openai_o1"}},
+		{Key: "sk-old", ExpiresAt: &expired},
+	}})
+	newFakeYou(t, func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.Header.Get("Cookie"), "DS=ta") {
+			t.Errorf("Cookie = %q, want the team account", r.Header.Get("Cookie"))
+		}
+		io.WriteString(w, youTokens("ok"))
+	})
+
+	tests := []struct {
+		name       string
+		key        string
+		model      string
+		wantStatus int
+		wantCode   string
+	}{
+		{"allowed model", "sk-team", "gpt-4o", http.StatusOK, ""},
+		{"allowed by You.com model ID", "sk-team", "o1", http.StatusOK, ""},
+		{"model not allowed", "sk-team", "claude-3-opus", http.StatusNotFound, "model_not_found"},
+		{"missing key", "", "gpt-4o", http.StatusUnauthorized, "invalid_api_key"},
+		{"raw DS token", "tb", "gpt-4o", http.StatusUnauthorized, "invalid_api_key"},
+		{"expired key", "sk-old", "gpt-4o", http.StatusUnauthorized, "invalid_api_key"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := `{"model": "` + tt.model + `", "messages": [{"role": "user", "content": "hi"}]}`
+			req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body))
+			if tt.key != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.key)
+			}
+			rec := httptest.NewRecorder()
+			Handler(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if tt.wantCode != "" {
+				var resp struct{ Error OpenAIError }
+				json.Unmarshal(rec.Body.Bytes(), &resp)
+				if resp.Error.Code == nil || *resp.Error.Code != tt.wantCode {
+					t.Errorf("error = %s, want code %s", rec.Body.String(), tt.wantCode)
+				}
+			}
+		})
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/models", nil)
+	req.Header.Set("Authorization", "Bearer sk-team")
+	rec := httptest.NewRecorder()
+	Handler(rec, req)
+	var models ModelResponse
+	json.Unmarshal(rec.Body.Bytes(), &models)
+	if len(models.Data) != 2 {
+		t.Errorf("/v1/models returned %d models, want the 2 allowed ones", len(models.Data))
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/tags", nil)
+	req.Header.Set("Authorization", "Bearer sk-team")
+	rec = httptest.NewRecorder()
+	Handler(rec, req)
+	var tags struct{ Models []OllamaModel }
+	json.Unmarshal(rec.Body.Bytes(), &tags)
+	if len(tags.Models) != 2 || tags.Models[0].Name != "gpt-4o" || tags.Models[1].Name != "o1" {
+		t.Errorf("/api/tags returned %+v, want the 2 allowed models", tags.Models)
+	}
+}
+
+func TestHandlerRateLimits(t *testing.T) {
+	keyLimiter, accountLimiter = ratelimit.New("key"), ratelimit.New("account")
+	t.Cleanup(func() { keyLimiter, accountLimiter = ratelimit.New("key"), ratelimit.New("account") })
+
+	useAccountPool(t,
+		pool.AccountConfig{Name: "a", Token: "ta", Limits: ratelimit.Limits{RequestsPerMinute: 1}},
+		pool.AccountConfig{Name: "b", Token: "tb", Limits: ratelimit.Limits{RequestsPerMinute: 1}})
+	useKeyStore(t, keys.Config{Keys: []keys.Key{
+		{Key: "sk-limited", Limits: ratelimit.Limits{RequestsPerMinute: 1, TokensPerDay: 1000}},
+		{Key: "sk-free"},
+	}})
+	newFakeYou(t, func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, youTokens("ok"))
+	})
+
+	send := func(key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"model": "gpt-4o", "messages": [{"role": "user", "content": "hi"}]}`))
+		req.Header.Set("Authorization", "Bearer "+key)
+		rec := httptest.NewRecorder()
+		Handler(rec, req)
+		return rec
+	}
+
+	// API key 的限额
+	rec := send("sk-limited")
+	if rec.Code != http.StatusOK || rec.Header().Get("x-ratelimit-limit-requests") != "1" || rec.Header().Get("x-ratelimit-remaining-requests") != "0" {
+		t.Fatalf("first request: status %d, headers %v", rec.Code, rec.Header())
+	}
+	if rec.Header().Get("x-ratelimit-limit-tokens") != "1000" || rec.Header().Get("x-ratelimit-reset-tokens") == "" {
+		t.Errorf("token headers = %v", rec.Header())
+	}
+	rec = send("sk-limited")
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") == "" || rec.Header().Get("x-ratelimit-reset-requests") == "" {
+		t.Fatalf("second request: status %d, headers %v", rec.Code, rec.Header())
+	}
+	if !strings.Contains(rec.Body.String(), `"code":"rate_limit_exceeded"`) {
+		t.Errorf("body = %s, want rate_limit_exceeded", rec.Body.String())
+	}
+
+	// 账号 a 已用完限额，换用 b；两个账号都用完后返回 429
+	if rec := send("sk-free"); rec.Code != http.StatusOK {
+		t.Fatalf("request on account b: status %d", rec.Code)
+	}
+	if rec := send("sk-free"); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("request with all accounts limited: status %d, want 429", rec.Code)
+	}
+}
+
+// TestOllamaDSTokenScope OLLAMA_DS_TOKEN 只在直连模式下用于未提供凭据的 Ollama 请求，
+// 不会开放其他接口，配置了账号池时也不会绕过账号池的凭据检查。
+func TestOllamaDSTokenScope(t *testing.T) {
+	previous := ollamaDSToken
+	ollamaDSToken = "ollama-token"
+	t.Cleanup(func() { ollamaDSToken = previous })
+	searches := 0
+	newFakeYou(t, func(w http.ResponseWriter, r *http.Request) {
+		searches++
+		if !strings.Contains(r.Header.Get("Cookie"), "DS=ollama-token;") {
+			t.Errorf("Cookie = %q, want OLLAMA_DS_TOKEN", r.Header.Get("Cookie"))
+		}
+		io.WriteString(w, youTokens("ok"))
+	})
+	send := func(path, body string) int {
+		rec := httptest.NewRecorder()
+		Handler(rec, httptest.NewRequest(http.MethodPost, path, strings.NewReader(body)))
+		return rec.Code
+	}
+	ollamaBody := `{"model": "gpt-4o", "stream": false, "messages": [{"role": "user", "content": "hi"}]}`
+
+	if code := send("/api/chat", ollamaBody); code != http.StatusOK {
+		t.Errorf("/api/chat status = %d, want 200", code)
+	}
+	if code := send("/v1/chat/completions", `{"model": "gpt-4o", "messages": [{"role": "user", "content": "hi"}]}`); code != http.StatusUnauthorized {
+		t.Errorf("/v1/chat/completions status = %d, want 401", code)
+	}
+
+	useAccountPool(t, pool.AccountConfig{Name: "a", Token: "ta"})
+	if code := send("/api/chat", ollamaBody); code != http.StatusUnauthorized {
+		t.Errorf("/api/chat with an account pool: status = %d, want 401", code)
+	}
+	if searches != 1 {
+		t.Errorf("upstream received %d requests, want only the direct-mode request", searches)
+	}
+}
+
+// TestRecordUsageByKey 共用同一账号的不同 API key 按各自的标签计入 token 指标。
+func TestRecordUsageByKey(t *testing.T) {
+	useAccountPool(t, pool.AccountConfig{Name: "shared", Token: "ts"})
+	useKeyStore(t, keys.Config{Keys: []keys.Key{
+		{Key: "sk-alice", Label: "alice"},
+		{Key: "sk-bob", Label: "bob"},
+	}})
+	newFakeYou(t, func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, youTokens("ok"))
+	})
+
+	tokens := func(key string) float64 {
+		var m dto.Metric
+		metrics.TokenCounter.WithLabelValues(key, "shared", "gpt-4o", "prompt").Write(&m)
+		return m.GetCounter().GetValue()
+	}
+	before := map[string]float64{"alice": tokens("alice"), "bob": tokens("bob")}
+	for _, key := range []string{"sk-alice", "sk-alice", "sk-bob"} {
+		req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"model": "gpt-4o", "messages": [{"role": "user", "content": "hi"}]}`))
+		req.Header.Set("Authorization", "Bearer "+key)
+		rec := httptest.NewRecorder()
+		Handler(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+		}
+	}
+	alice, bob := tokens("alice")-before["alice"], tokens("bob")-before["bob"]
+	if alice <= 0 || bob <= 0 || alice != 2*bob {
+		t.Errorf("prompt tokens alice = %v, bob = %v, want separate series with alice = 2 * bob", alice, bob)
+	}
+}
+
+// TestRecordUsageDirectToken 直接使用 DS token 的请求统一计入 direct 标签，不按 token 区分。
+func TestRecordUsageDirectToken(t *testing.T) {
+	newFakeYou(t, func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, youTokens("ok"))
+	})
+
+	tokens := func() float64 {
+		var m dto.Metric
+		metrics.TokenCounter.WithLabelValues("direct", "direct", "gpt-4o", "prompt").Write(&m)
+		return m.GetCounter().GetValue()
+	}
+	before := tokens()
+	for _, token := range []string{"ds-token-1", "ds-token-2"} {
+		req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"model": "gpt-4o", "messages": [{"role": "user", "content": "hi"}]}`))
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		Handler(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+		}
+		var m dto.Metric
+		metrics.TokenCounter.WithLabelValues(keyLabel(token), keyLabel(token), "gpt-4o", "prompt").Write(&m)
+		if m.GetCounter().GetValue() != 0 {
+			t.Errorf("token %s got its own metric series", token)
+		}
+	}
+	if tokens() <= before {
+		t.Errorf("prompt tokens under direct = %v, want more than %v", tokens(), before)
+	}
+}
@@ -0,0 +1,344 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// AnthropicRequest 定义了 Anthropic Messages API 请求体的结构。
+type AnthropicRequest struct {
+	Model         string             `json:"model"`
+	System        json.RawMessage    `json:"system,omitempty"` // 字符串或内容块数组
+	Messages      []AnthropicMessage `json:"messages"`
+	MaxTokens     int                `json:"max_tokens"`
+	StopSequences []string           `json:"stop_sequences,omitempty"`
+	Stream        bool               `json:"stream"`
+	You           *YouOptions        `json:"you,omitempty"` // 按请求覆盖的 You.com 选项
+}
+
+// AnthropicMessage 定义了 Anthropic 消息的结构，content 可以是字符串或内容块数组。
+type AnthropicMessage struct {
+	Role    string          `json:"role"`
+	Content json.RawMessage `json:"content"`
+}
+
+// AnthropicContentBlock 定义了 Anthropic 内容块的结构。响应中只输出 text 块，
+// text 字段即使为空也必须存在，因此不能使用 omitempty。
+type AnthropicContentBlock struct {
+	Type    string          `json:"type"`
+	Text    string          `json:"text"`
+	Content json.RawMessage `json:"content,omitempty"` // tool_result 块的内容
+}
+
+// AnthropicUsage 定义了 Anthropic 响应中的 token 用量。
+type AnthropicUsage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+}
+
+// AnthropicResponse 定义了 Anthropic Messages API 非流式响应的结构。
+type AnthropicResponse struct {
+	ID           string                  `json:"id"`
+	Type         string                  `json:"type"`
+	Role         string                  `json:"role"`
+	Model        string                  `json:"model"`
+	Content      []AnthropicContentBlock `json:"content"`
+	StopReason   *string                 `json:"stop_reason"`
+	StopSequence *string                 `json:"stop_sequence"`
+	Usage        AnthropicUsage          `json:"usage"`
+}
+
+// handleAnthropicMessages 处理 /v1/messages 请求，将 Anthropic 协议转换为 You.com 请求。
+func handleAnthropicMessages(w http.ResponseWriter, r *http.Request) {
+	// 设置 CORS 头部
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "*")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	// Anthropic 客户端使用 x-api-key 头部，同时兼容 Bearer
+	credential, _ := extractDSToken(r)
+	if _, err := authorizeCredential(credential); err != nil {
+		writeAnthropicAccountError(w, err)
+		return
+	}
+
+	var anthropicReq AnthropicRequest
+	if err := json.NewDecoder(r.Body).Decode(&anthropicReq); err != nil {
+		writeAnthropicError(w, http.StatusBadRequest, "invalid_request_error", "Invalid request body")
+		return
+	}
+
+	account, err := acquireAccount(w, accountRequest{credential: credential, model: anthropicReq.Model, stream: anthropicReq.Stream})
+	if err != nil {
+		writeAnthropicAccountError(w, err)
+		return
+	}
+	defer account.release()
+	dsToken := account.token
+
+	messages, err := anthropicToMessages(anthropicReq)
+	if err != nil {
+		writeAnthropicError(w, http.StatusBadRequest, "invalid_request_error", err.Error())
+		return
+	}
+	var stops []string
+	for _, stop := range anthropicReq.StopSequences {
+		if stop != "" {
+			stops = append(stops, stop)
+		}
+	}
+	limiter := newOutputLimiter(outputLimits{Stops: stops, MaxTokens: anthropicReq.MaxTokens})
+
+	youOptions, err := resolveYouOptions(r, anthropicReq.You)
+	if err != nil {
+		writeAnthropicError(w, http.StatusBadRequest, "invalid_request_error", err.Error())
+		return
+	}
+
+	youReq, err := buildYouRequest(r.Context(), dsToken, anthropicReq.Model, messages, youOptions)
+	if err != nil {
+		if clientCanceled(r.Context(), "anthropic", stagePrepare) {
+			return
+		}
+		writeAnthropicError(w, http.StatusInternalServerError, "api_error", err.Error())
+		return
+	}
+
+	events, err := openYouStream(account, newUpstreamClient(upstreamTimeoutFor(anthropicReq.Stream)), youReq)
+	if err != nil {
+		if clientCanceled(r.Context(), "anthropic", stageRequest) {
+			return
+		}
+		writeAnthropicError(w, upstreamStatus(err, http.StatusBadGateway), "api_error", err.Error())
+		return
+	}
+	defer events.Close()
+
+	model := reverseMapModelName(mapModelName(anthropicReq.Model))
+	messageID := "msg_" + strings.ReplaceAll(uuid.New().String(), "-", "")
+	inputTokens, _ := countTokens(messages)
+
+	if !anthropicReq.Stream {
+		var fullResponse strings.Builder
+		err := readYouChatTokens(events, func(token string) bool {
+			text, done := limiter.push(token)
+			fullResponse.WriteString(text)
+			return !done
+		})
+		if err != nil {
+			if clientCanceled(r.Context(), "anthropic", stageStream) {
+				return
+			}
+			writeAnthropicError(w, http.StatusBadGateway, "api_error", "Error reading response")
+			return
+		}
+		fullResponse.WriteString(limiter.flush())
+
+		outputTokens, _ := countTokens([]Message{{Role: "assistant", Content: fullResponse.String()}})
+		recordUsage(account, anthropicReq.Model, inputTokens, outputTokens)
+		stopReason, stopSequence := anthropicStopReason(limiter)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(AnthropicResponse{
+			ID:           messageID,
+			Type:         "message",
+			Role:         "assistant",
+			Model:        model,
+			Content:      []AnthropicContentBlock{{Type: "text", Text: fullResponse.String()}},
+			StopReason:   &stopReason,
+			StopSequence: stopSequence,
+			Usage: AnthropicUsage{
+				InputTokens:  inputTokens,
+				OutputTokens: outputTokens,
+			},
+		})
+		return
+	}
+
+	// 设置流式响应的头部
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	writeAnthropicEvent(w, "message_start", map[string]interface{}{
+		"type": "message_start",
+		"message": AnthropicResponse{
+			ID:      messageID,
+			Type:    "message",
+			Role:    "assistant",
+			Model:   model,
+			Content: []AnthropicContentBlock{},
+			Usage:   AnthropicUsage{InputTokens: inputTokens},
+		},
+	})
+	writeAnthropicEvent(w, "content_block_start", map[string]interface{}{
+		"type":          "content_block_start",
+		"index":         0,
+		"content_block": AnthropicContentBlock{Type: "text", Text: ""},
+	})
+	writeAnthropicEvent(w, "ping", map[string]string{"type": "ping"})
+
+	var fullResponse strings.Builder
+	writeDelta := func(text string) {
+		if text == "" {
+			return
+		}
+		fullResponse.WriteString(text)
+		writeAnthropicEvent(w, "content_block_delta", map[string]interface{}{
+			"type":  "content_block_delta",
+			"index": 0,
+			"delta": map[string]string{"type": "text_delta", "text": text},
+		})
+	}
+	err = readYouChatTokens(events, func(token string) bool {
+		text, done := limiter.push(token)
+		writeDelta(text)
+		return !done
+	})
+	if err != nil {
+		if clientCanceled(r.Context(), "anthropic", stageStream) {
+			return
+		}
+		// 响应头已发送，只能通过 error 事件通知客户端
+		fmt.Printf("读取流式响应失败: %v\n", err)
+		writeAnthropicEvent(w, "error", map[string]interface{}{
+			"type":  "error",
+			"error": map[string]string{"type": "api_error", "message": "Error reading response"},
+		})
+		return
+	}
+
+	writeDelta(limiter.flush())
+
+	outputTokens, _ := countTokens([]Message{{Role: "assistant", Content: fullResponse.String()}})
+	recordUsage(account, anthropicReq.Model, inputTokens, outputTokens)
+	stopReason, stopSequence := anthropicStopReason(limiter)
+	writeAnthropicEvent(w, "content_block_stop", map[string]interface{}{
+		"type":  "content_block_stop",
+		"index": 0,
+	})
+	writeAnthropicEvent(w, "message_delta", map[string]interface{}{
+		"type":  "message_delta",
+		"delta": map[string]interface{}{"stop_reason": stopReason, "stop_sequence": stopSequence},
+		"usage": map[string]int{"output_tokens": outputTokens},
+	})
+	writeAnthropicEvent(w, "message_stop", map[string]string{"type": "message_stop"})
+}
+
+// anthropicStopReason 将限制器的停止原因转换为 Anthropic 的 stop_reason 与 stop_sequence。
+func anthropicStopReason(limiter *outputLimiter) (string, *string) {
+	if limiter.FinishReason() == "length" {
+		return "max_tokens", nil
+	}
+	if stopSequence := limiter.StopSequence(); stopSequence != "" {
+		return "stop_sequence", &stopSequence
+	}
+	return "end_turn", nil
+}
+
+// anthropicToMessages 将 Anthropic 请求转换为内部使用的 Message 列表，
+// 顶层 system 转为 system 消息，由 convertSystemToUser 统一处理。
+func anthropicToMessages(req AnthropicRequest) ([]Message, error) {
+	var messages []Message
+
+	if len(req.System) > 0 {
+		system, err := anthropicContentText(req.System)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid system: %v", err)
+		}
+		if system != "" {
+			messages = append(messages, Message{Role: "system", Content: system})
+		}
+	}
+
+	for i, msg := range req.Messages {
+		content, err := anthropicContentText(msg.Content)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid content in messages.%d: %v", i, err)
+		}
+		messages = append(messages, Message{Role: msg.Role, Content: content})
+	}
+
+	if len(messages) == 0 {
+		return nil, errNoMessages
+	}
+	return messages, nil
+}
+
+// anthropicContentText 提取字符串或内容块数组中的文本，多个块之间用换行连接。
+func anthropicContentText(raw json.RawMessage) (string, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return "", nil
+	}
+
+	var text string
+	if err := json.Unmarshal(raw, &text); err == nil {
+		return text, nil
+	}
+
+	var blocks []AnthropicContentBlock
+	if err := json.Unmarshal(raw, &blocks); err != nil {
+		return "", err
+	}
+
+	var parts []string
+	for _, block := range blocks {
+		switch block.Type {
+		case "text":
+			parts = append(parts, block.Text)
+		case "tool_result":
+			// tool_result 的内容同样可以是字符串或内容块数组
+			inner, err := anthropicContentText(block.Content)
+			if err != nil {
+				return "", err
+			}
+			parts = append(parts, inner)
+		}
+	}
+	return strings.Join(parts, "\n"), nil
+}
+
+// writeAnthropicEvent 写入一个 Anthropic 风格的 SSE 事件并立即刷新。
+func writeAnthropicEvent(w http.ResponseWriter, event string, data interface{}) {
+	dataBytes, _ := json.Marshal(data)
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, string(dataBytes))
+	if flusher, ok := w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// writeAnthropicError 以 Anthropic 错误格式返回错误。
+func writeAnthropicError(w http.ResponseWriter, status int, errType, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"type": "error",
+		"error": map[string]string{
+			"type":    errType,
+			"message": message,
+		},
+	})
+}
+
+// writeAnthropicAccountError 以 Anthropic 错误格式返回 acquireAccount 的错误。
+func writeAnthropicAccountError(w http.ResponseWriter, err error) {
+	status := accountErrorStatus(w, err)
+	errType := "overloaded_error"
+	switch status {
+	case http.StatusUnauthorized:
+		errType = "authentication_error"
+	case http.StatusNotFound:
+		errType = "not_found_error"
+	case http.StatusTooManyRequests:
+		errType = "rate_limit_error"
+	}
+	writeAnthropicError(w, status, errType, err.Error())
+}
@@ -0,0 +1,220 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAnthropicToMessages(t *testing.T) {
+	tests := []struct {
+		name    string
+		json    string
+		want    []Message
+		wantErr string
+	}{
+		{
+			name: "string content",
+			json: `{"messages": [{"role": "user", "content": "hi"}]}`,
+			want: []Message{{Role: "user", Content: "hi"}},
+		},
+		{
+			name: "system string",
+			json: `{"system": "be brief", "messages": [{"role": "user", "content": "hi"}]}`,
+			want: []Message{{Role: "system", Content: "be brief"}, {Role: "user", Content: "hi"}},
+		},
+		{
+			name: "system blocks",
+			json: `{"system": [{"type": "text", "text": "be"}, {"type": "text", "text": "brief"}], "messages": [{"role": "user", "content": "hi"}]}`,
+			want: []Message{{Role: "system", Content: "be\nbrief"}, {Role: "user", Content: "hi"}},
+		},
+		{
+			name: "empty system dropped",
+			json: `{"system": "", "messages": [{"role": "user", "content": "hi"}]}`,
+			want: []Message{{Role: "user", Content: "hi"}},
+		},
+		{
+			name: "content blocks",
+			json: `{"messages": [{"role": "user", "content": [{"type": "text", "text": "look"}, {"type": "image", "source": {}}, {"type": "text", "text": "here"}]}]}`,
+			want: []Message{{Role: "user", Content: "look\nhere"}},
+		},
+		{
+			name: "tool_result blocks",
+			json: `{"messages": [{"role": "assistant", "content": "calling"}, {"role": "user", "content": [{"type": "tool_result", "content": "sunny"}, {"type": "tool_result", "content": [{"type": "text", "text": "warm"}]}]}]}`,
+			want: []Message{{Role: "assistant", Content: "calling"}, {Role: "user", Content: "sunny\nwarm"}},
+		},
+		{
+			name:    "invalid content",
+			json:    `{"messages": [{"role": "user", "content": 42}]}`,
+			wantErr: "Invalid content in messages.0",
+		},
+		{
+			name:    "invalid system",
+			json:    `{"system": {"text": "x"}, "messages": [{"role": "user", "content": "hi"}]}`,
+			wantErr: "Invalid system",
+		},
+		{
+			name:    "no messages",
+			json:    `{"messages": []}`,
+			wantErr: errNoMessages.Error(),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var req AnthropicRequest
+			if err := json.Unmarshal([]byte(tt.json), &req); err != nil {
+				t.Fatalf("invalid test request: %v", err)
+			}
+			messages, err := anthropicToMessages(req)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("anthropicToMessages() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("anthropicToMessages() error = %v", err)
+			}
+			if len(messages) != len(tt.want) {
+				t.Fatalf("anthropicToMessages() = %+v, want %+v", messages, tt.want)
+			}
+			for i, want := range tt.want {
+				if messages[i].Role != want.Role || messages[i].Content != want.Content {
+					t.Errorf("messages[%d] = %+v, want %+v", i, messages[i], want)
+				}
+			}
+		})
+	}
+}
+
+// anthropicRequest 以 x-api-key 发送 /v1/messages 请求。
+func anthropicRequest(body string) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(body))
+	req.Header.Set("x-api-key", "test-token")
+	return req
+}
+
+func TestAnthropicMessages(t *testing.T) {
+	var query string
+	newFakeYou(t, func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query().Get("q")
+		io.WriteString(w, youTokens("Hello", " world"))
+	})
+
+	rec := httptest.NewRecorder()
+	Handler(rec, anthropicRequest(`{"model": "claude-3-5-sonnet-20241022", "max_tokens": 100, "messages": [{"role": "user", "content": [{"type": "text", "text": "hi"}]}]}`))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+	if query != "hi" {
+		t.Errorf("upstream query = %q, want hi", query)
+	}
+
+	var response AnthropicResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatalf("invalid response: %v", err)
+	}
+	if response.Type != "message" || response.Role != "assistant" || !strings.HasPrefix(response.ID, "msg_") {
+		t.Errorf("response = %+v", response)
+	}
+	if len(response.Content) != 1 || response.Content[0].Type != "text" || response.Content[0].Text != "Hello world" {
+		t.Errorf("content = %+v, want one text block Hello world", response.Content)
+	}
+	if response.StopReason == nil || *response.StopReason != "end_turn" || response.StopSequence != nil {
+		t.Errorf("stop_reason = %v, stop_sequence = %v, want end_turn", response.StopReason, response.StopSequence)
+	}
+	if response.Usage.InputTokens == 0 || response.Usage.OutputTokens == 0 {
+		t.Errorf("usage = %+v, want non-zero token counts", response.Usage)
+	}
+}
+
+func TestAnthropicMessagesStream(t *testing.T) {
+	newFakeYou(t, func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, youTokens("Hello", " world", " STOP", " ignored"))
+	})
+
+	rec := httptest.NewRecorder()
+	Handler(rec, anthropicRequest(`{"model": "claude-3-5-sonnet-20241022", "stream": true, "stop_sequences": ["STOP"], "messages": [{"role": "user", "content": "hi"}]}`))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+	if contentType := rec.Header().Get("Content-Type"); contentType != "text/event-stream" {
+		t.Errorf("Content-Type = %q", contentType)
+	}
+
+	var events []string
+	var text, stopReason, stopSequence string
+	for _, event := range strings.Split(strings.TrimSpace(rec.Body.String()), "\n\n") {
+		name, data, ok := strings.Cut(event, "\n")
+		if !ok || !strings.HasPrefix(name, "event: ") || !strings.HasPrefix(data, "data: ") {
+			t.Fatalf("malformed event %q", event)
+		}
+		var payload struct {
+			Type  string `json:"type"`
+			Delta struct {
+				Text         string  `json:"text"`
+				StopReason   string  `json:"stop_reason"`
+				StopSequence *string `json:"stop_sequence"`
+			} `json:"delta"`
+		}
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(data, "data: ")), &payload); err != nil {
+			t.Fatalf("invalid payload %q: %v", data, err)
+		}
+		if payload.Type != strings.TrimPrefix(name, "event: ") {
+			t.Errorf("event %s has type %q", name, payload.Type)
+		}
+		// 停止序列检测可能拆分文本，连续的 content_block_delta 只记录一次
+		if len(events) == 0 || events[len(events)-1] != payload.Type {
+			events = append(events, payload.Type)
+		}
+		text += payload.Delta.Text
+		if payload.Type == "message_delta" {
+			stopReason = payload.Delta.StopReason
+			if payload.Delta.StopSequence != nil {
+				stopSequence = *payload.Delta.StopSequence
+			}
+		}
+	}
+
+	want := []string{
+		"message_start",
+		"content_block_start",
+		"ping",
+		"content_block_delta",
+		"content_block_stop",
+		"message_delta",
+		"message_stop",
+	}
+	if strings.Join(events, ",") != strings.Join(want, ",") {
+		t.Errorf("events = %v, want %v", events, want)
+	}
+	if text != "Hello world " {
+		t.Errorf("text = %q, want the output before the stop sequence", text)
+	}
+	if stopReason != "stop_sequence" || stopSequence != "STOP" {
+		t.Errorf("stop_reason = %q, stop_sequence = %q", stopReason, stopSequence)
+	}
+}
+
+func TestAnthropicEmptyTextBlocks(t *testing.T) {
+	newFakeYou(t, func(w http.ResponseWriter, r *http.Request) {
+		// 超过内嵌错误检测的长度限制，之后被开头的停止序列截断为空回答
+		io.WriteString(w, youTokens(strings.Repeat("word ", 100)))
+	})
+
+	for _, stream := range []bool{false, true} {
+		body := fmt.Sprintf(`{"model": "claude-3-5-sonnet-20241022", "stream": %t, "stop_sequences": ["word"], "messages": [{"role": "user", "content": "hi"}]}`, stream)
+		rec := httptest.NewRecorder()
+		Handler(rec, anthropicRequest(body))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("stream=%t: status = %d: %s", stream, rec.Code, rec.Body.String())
+		}
+		if !strings.Contains(rec.Body.String(), `{"type":"text","text":""}`) {
+			t.Errorf("stream=%t: body = %s, want an empty text block with the text field", stream, rec.Body.String())
+		}
+	}
+}
@@ -0,0 +1,326 @@
+package handler
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"you2api/youclient"
+)
+
+// 附件下载与大小、数量限制
+const (
+	MaxAttachmentBytes     = 20 * 1024 * 1024 // 单个附件最大 20MB
+	MaxAttachments         = 10               // 每个请求最多 10 个附件
+	attachmentFetchTimeout = 30 * time.Second
+	maxAttachmentRedirects = 5
+	defaultAttachmentMIME  = "application/octet-stream"
+)
+
+// allowRemoteAttachments 为 true 时才接受并下载 http(s) 附件，来自环境变量 ALLOW_REMOTE_ATTACHMENTS，
+// 默认只接受 data URL。开启后也只会连接公网地址，见 attachmentDialControl。
+var allowRemoteAttachments, _ = strconv.ParseBool(os.Getenv("ALLOW_REMOTE_ATTACHMENTS"))
+
+// Attachment 定义了消息中需要上传到 You.com 的图片或文件。
+// Data 为空时从 URL 下载内容。
+type Attachment struct {
+	Filename string
+	MIMEType string
+	Data     []byte
+	URL      string
+}
+
+// ContentPart 定义了 OpenAI 内容部分数组中的单个元素。
+type ContentPart struct {
+	Type     string        `json:"type"`
+	Text     string        `json:"text,omitempty"`
+	ImageURL *ImageURLPart `json:"image_url,omitempty"`
+	File     *FilePart     `json:"file,omitempty"`
+}
+
+// ImageURLPart 定义了 image_url 内容部分，URL 可以是 base64 data URL，开启 ALLOW_REMOTE_ATTACHMENTS
+// 时也可以是 http(s) 地址。
+type ImageURLPart struct {
+	URL    string `json:"url"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// FilePart 定义了 file 内容部分，FileData 为 base64 data URL。
+type FilePart struct {
+	FileData string `json:"file_data,omitempty"`
+	Filename string `json:"filename,omitempty"`
+	FileID   string `json:"file_id,omitempty"`
+}
+
+// UnmarshalJSON 解析字符串或内容部分数组形式的 content。
+func (m *Message) UnmarshalJSON(data []byte) error {
+	type messageAlias Message
+	aux := struct {
+		*messageAlias
+		Content json.RawMessage `json:"content"`
+	}{messageAlias: (*messageAlias)(m)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+
+	m.Content = ""
+	m.Attachments = nil
+	if len(aux.Content) == 0 || string(aux.Content) == "null" {
+		return nil
+	}
+
+	var text string
+	if err := json.Unmarshal(aux.Content, &text); err == nil {
+		m.Content = text
+		return nil
+	}
+
+	var parts []ContentPart
+	if err := json.Unmarshal(aux.Content, &parts); err != nil {
+		return errors.New("content must be a string or an array of content parts")
+	}
+
+	var texts []string
+	for _, part := range parts {
+		switch part.Type {
+		case "text", "input_text":
+			texts = append(texts, part.Text)
+		case "image_url":
+			if part.ImageURL == nil || part.ImageURL.URL == "" {
+				return errors.New("image_url part requires a url")
+			}
+			attachment, err := parseAttachmentURL(part.ImageURL.URL, "")
+			if err != nil {
+				return err
+			}
+			m.Attachments = append(m.Attachments, attachment)
+		case "file":
+			if part.File == nil || part.File.FileData == "" {
+				return errors.New("file part requires file_data")
+			}
+			attachment, err := parseAttachmentURL(part.File.FileData, part.File.Filename)
+			if err != nil {
+				return err
+			}
+			m.Attachments = append(m.Attachments, attachment)
+		}
+	}
+	m.Content = strings.Join(texts, "\n")
+	return nil
+}
+
+// parseAttachmentURL 解析 data URL（立即解码）或 http(s) URL（上传前下载，需开启 ALLOW_REMOTE_ATTACHMENTS）。
+func parseAttachmentURL(rawURL, filename string) (Attachment, error) {
+	if strings.HasPrefix(rawURL, "http://") || strings.HasPrefix(rawURL, "https://") {
+		if !allowRemoteAttachments {
+			return Attachment{}, errors.New("remote attachment URLs are disabled, use a data URL")
+		}
+		return Attachment{URL: rawURL, Filename: filename}, nil
+	}
+
+	meta, payload, found := strings.Cut(strings.TrimPrefix(rawURL, "data:"), ",")
+	if !strings.HasPrefix(rawURL, "data:") || !found {
+		return Attachment{}, errors.New("attachment must be an http(s) URL or a data URL")
+	}
+	if !strings.HasSuffix(meta, ";base64") {
+		return Attachment{}, errors.New("data URL attachments must be base64 encoded")
+	}
+
+	data, err := base64.StdEncoding.DecodeString(payload)
+	if err != nil {
+		return Attachment{}, fmt.Errorf("invalid base64 attachment: %v", err)
+	}
+	if len(data) > MaxAttachmentBytes {
+		return Attachment{}, fmt.Errorf("attachment exceeds %d bytes", MaxAttachmentBytes)
+	}
+
+	mimeType := strings.TrimSuffix(meta, ";base64")
+	if mimeType == "" {
+		mimeType = defaultAttachmentMIME
+	}
+	return Attachment{Filename: filename, MIMEType: mimeType, Data: data}, nil
+}
+
+// uploadAttachments 上传所有消息中的附件，返回对应的 sources 条目。附件超过 MaxAttachments 个时
+// 不下载、不上传，直接返回 errTooManyAttachments。
+func uploadAttachments(ctx context.Context, dsToken string, messages []Message) ([]youclient.Source, error) {
+	count := 0
+	for _, msg := range messages {
+		count += len(msg.Attachments)
+	}
+	if count > MaxAttachments {
+		return nil, errTooManyAttachments
+	}
+
+	var sources []youclient.Source
+	for _, msg := range messages {
+		for _, attachment := range msg.Attachments {
+			if attachment.Data == nil {
+				if err := fetchAttachment(ctx, &attachment); err != nil {
+					fmt.Printf("下载附件失败: %v\n", err)
+					return nil, errUpload
+				}
+			}
+
+			filename := attachmentFilename(attachment)
+
+			// 获取nonce
+			if _, err := getNonce(ctx, dsToken); err != nil {
+				fmt.Printf("获取nonce失败: %v\n", err)
+				return nil, errNonce
+			}
+
+			uploadResp, err := uploadFileData(ctx, dsToken, filename, attachment.MIMEType, attachment.Data)
+			if err != nil {
+				fmt.Printf("上传附件失败: %v\n", err)
+				return nil, errUpload
+			}
+
+			sources = append(sources, uploadResp.Source(len(attachment.Data)))
+		}
+	}
+	return sources, nil
+}
+
+// attachmentClient 是下载附件使用的客户端：不使用代理，每次建立连接（包括重定向后）都由
+// attachmentDialControl 检查实际连接的 IP，且只跟随 http(s) 重定向。
+var attachmentClient = &http.Client{
+	Timeout: attachmentFetchTimeout,
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout: 10 * time.Second,
+			Control: attachmentDialControl,
+		}).DialContext,
+		TLSHandshakeTimeout: 10 * time.Second,
+	},
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if len(via) >= maxAttachmentRedirects {
+			return errors.New("too many redirects")
+		}
+		if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+			return fmt.Errorf("unsupported redirect to %s", req.URL.Scheme)
+		}
+		return nil
+	},
+}
+
+// nonPublicPrefixes 是 netip.Addr 的方法没有覆盖、但同样不应访问的地址段。
+var nonPublicPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),     // 本网络
+	netip.MustParsePrefix("100.64.0.0/10"), // 运营商级 NAT
+	netip.MustParsePrefix("192.0.0.0/24"),  // IETF 协议分配
+	netip.MustParsePrefix("198.18.0.0/15"), // 基准测试
+	netip.MustParsePrefix("64:ff9b::/96"),  // NAT64，可映射到内网 IPv4
+}
+
+// isPublicAddr 报告 addr 是否为公网单播地址，回环、私有、链路本地、组播等地址返回 false。
+func isPublicAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if !addr.IsGlobalUnicast() || addr.IsPrivate() {
+		return false
+	}
+	for _, prefix := range nonPublicPrefixes {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// attachmentDialControl 在连接建立前检查已解析的目标 IP，拒绝非公网地址，防止通过附件 URL
+// 访问服务器所在的内网或云厂商元数据服务（如 169.254.169.254）。
+func attachmentDialControl(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return err
+	}
+	if !isPublicAddr(addr) {
+		return fmt.Errorf("attachment host %s is not a public address", addr)
+	}
+	return nil
+}
+
+// fetchAttachment 下载 http(s) 附件并填充内容与 MIME 类型。
+func fetchAttachment(ctx context.Context, attachment *Attachment) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, attachment.URL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := attachmentClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("下载附件返回状态码 %d", resp.StatusCode)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, MaxAttachmentBytes+1))
+	if err != nil {
+		return err
+	}
+	if len(data) > MaxAttachmentBytes {
+		return fmt.Errorf("attachment exceeds %d bytes", MaxAttachmentBytes)
+	}
+
+	attachment.Data = data
+	if mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type")); err == nil {
+		attachment.MIMEType = mediaType
+	} else {
+		attachment.MIMEType = http.DetectContentType(data)
+	}
+	if attachment.Filename == "" {
+		if base := path.Base(resp.Request.URL.Path); path.Ext(base) != "" {
+			attachment.Filename = base
+		}
+	}
+	return nil
+}
+
+// attachmentFilename 返回附件的文件名，未提供时根据 MIME 类型生成短文件名。
+func attachmentFilename(attachment Attachment) string {
+	if attachment.Filename != "" {
+		return path.Base(attachment.Filename)
+	}
+	return generateShortFileName() + extensionForMIME(attachment.MIMEType)
+}
+
+// extensionForMIME 返回 MIME 类型对应的常用扩展名。
+func extensionForMIME(mimeType string) string {
+	switch mimeType {
+	case "image/jpeg":
+		return ".jpg"
+	case "image/png":
+		return ".png"
+	case "image/gif":
+		return ".gif"
+	case "image/webp":
+		return ".webp"
+	case "application/pdf":
+		return ".pdf"
+	case "text/plain":
+		return ".txt"
+	}
+	if extensions, err := mime.ExtensionsByType(mimeType); err == nil && len(extensions) > 0 {
+		return extensions[0]
+	}
+	return ".bin"
+}
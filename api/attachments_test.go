@@ -0,0 +1,217 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+func TestMessageUnmarshalJSON(t *testing.T) {
+	useRemoteAttachments(t, false)
+	png := base64.StdEncoding.EncodeToString([]byte("\x89PNG"))
+	tests := []struct {
+		name            string
+		json            string
+		wantContent     string
+		wantAttachments []Attachment
+		wantErr         string
+	}{
+		{
+			name:        "string content",
+			json:        `{"role": "user", "content": "hello"}`,
+			wantContent: "hello",
+		},
+		{
+			name: "null content",
+			json: `{"role": "assistant", "content": null}`,
+		},
+		{
+			name:        "mixed parts",
+			json:        `{"role": "user", "content": [{"type": "text", "text": "look"}, {"type": "image_url", "image_url": {"url": "data:image/png;base64,` + png + `"}}, {"type": "input_text", "text": "at this"}]}`,
+			wantContent: "look\nat this",
+			wantAttachments: []Attachment{
+				{MIMEType: "image/png", Data: []byte("\x89PNG")},
+			},
+		},
+		{
+			name:        "file data URL",
+			json:        `{"role": "user", "content": [{"type": "file", "file": {"file_data": "data:application/pdf;base64,` + base64.StdEncoding.EncodeToString([]byte("%PDF")) + `", "filename": "a.pdf"}}]}`,
+			wantContent: "",
+			wantAttachments: []Attachment{
+				{Filename: "a.pdf", MIMEType: "application/pdf", Data: []byte("%PDF")},
+			},
+		},
+		{
+			name:    "bad base64",
+			json:    `{"role": "user", "content": [{"type": "image_url", "image_url": {"url": "data:image/png;base64,not base64!"}}]}`,
+			wantErr: "invalid base64 attachment",
+		},
+		{
+			name:    "not base64 encoded",
+			json:    `{"role": "user", "content": [{"type": "image_url", "image_url": {"url": "data:text/plain,hello"}}]}`,
+			wantErr: "must be base64 encoded",
+		},
+		{
+			name:    "missing image url",
+			json:    `{"role": "user", "content": [{"type": "image_url", "image_url": {}}]}`,
+			wantErr: "image_url part requires a url",
+		},
+		{
+			name:    "missing file data",
+			json:    `{"role": "user", "content": [{"type": "file", "file": {"filename": "a.pdf"}}]}`,
+			wantErr: "file part requires file_data",
+		},
+		{
+			name:    "remote URL disabled",
+			json:    `{"role": "user", "content": [{"type": "image_url", "image_url": {"url": "http://10.0.0.1/a.png"}}]}`,
+			wantErr: "remote attachment URLs are disabled",
+		},
+		{
+			name:    "invalid content",
+			json:    `{"role": "user", "content": 42}`,
+			wantErr: "content must be a string or an array of content parts",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var msg Message
+			err := json.Unmarshal([]byte(tt.json), &msg)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Unmarshal() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unmarshal() error = %v", err)
+			}
+			if msg.Content != tt.wantContent {
+				t.Errorf("Content = %q, want %q", msg.Content, tt.wantContent)
+			}
+			if len(msg.Attachments) != len(tt.wantAttachments) {
+				t.Fatalf("Attachments = %+v, want %+v", msg.Attachments, tt.wantAttachments)
+			}
+			for i, want := range tt.wantAttachments {
+				got := msg.Attachments[i]
+				if got.Filename != want.Filename || got.MIMEType != want.MIMEType || !bytes.Equal(got.Data, want.Data) {
+					t.Errorf("Attachments[%d] = %+v, want %+v", i, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestParseAttachmentURL(t *testing.T) {
+	oversize := base64.StdEncoding.EncodeToString(make([]byte, MaxAttachmentBytes+1))
+	tests := []struct {
+		name     string
+		url      string
+		wantMIME string
+		wantErr  string
+	}{
+		{"data URL", "data:image/jpeg;base64," + base64.StdEncoding.EncodeToString([]byte("jpg")), "image/jpeg", ""},
+		{"default MIME", "data:;base64," + base64.StdEncoding.EncodeToString([]byte("bin")), defaultAttachmentMIME, ""},
+		{"oversize payload", "data:image/png;base64," + oversize, "", "attachment exceeds"},
+		{"missing comma", "data:image/png;base64", "", "must be an http(s) URL or a data URL"},
+		{"unsupported scheme", "ftp://example.com/a.png", "", "must be an http(s) URL or a data URL"},
+		{"empty", "", "", "must be an http(s) URL or a data URL"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attachment, err := parseAttachmentURL(tt.url, "")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("parseAttachmentURL() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || attachment.MIMEType != tt.wantMIME || attachment.Data == nil {
+				t.Errorf("parseAttachmentURL() = %+v, %v, want MIME %s", attachment, err, tt.wantMIME)
+			}
+		})
+	}
+}
+
+// useRemoteAttachments 设置 allowRemoteAttachments，测试结束后恢复。
+func useRemoteAttachments(t *testing.T, allow bool) {
+	t.Helper()
+	previous := allowRemoteAttachments
+	allowRemoteAttachments = allow
+	t.Cleanup(func() { allowRemoteAttachments = previous })
+}
+
+func TestIsPublicAddr(t *testing.T) {
+	tests := []struct {
+		addr string
+		want bool
+	}{
+		{"8.8.8.8", true},
+		{"2606:4700::1111", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false},
+		{"fe80::1", false},
+		{"fd00::1", false},
+		{"0.0.0.0", false},
+		{"100.64.0.1", false},
+		{"::ffff:127.0.0.1", false},
+		{"64:ff9b::a9fe:a9fe", false},
+		{"224.0.0.1", false},
+	}
+	for _, tt := range tests {
+		if got := isPublicAddr(netip.MustParseAddr(tt.addr)); got != tt.want {
+			t.Errorf("isPublicAddr(%s) = %v, want %v", tt.addr, got, tt.want)
+		}
+	}
+}
+
+func TestFetchAttachmentRejectsPrivateHosts(t *testing.T) {
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		io.WriteString(w, "secret")
+	}))
+	defer server.Close()
+
+	attachment := Attachment{URL: server.URL + "/latest/meta-data"}
+	if err := fetchAttachment(context.Background(), &attachment); err == nil {
+		t.Fatalf("fetchAttachment(%s) succeeded, want the loopback address rejected", attachment.URL)
+	}
+	if requests != 0 || attachment.Data != nil {
+		t.Errorf("loopback server received %d requests, data = %q", requests, attachment.Data)
+	}
+}
+
+func TestRemoteAttachmentsDisabled(t *testing.T) {
+	useRemoteAttachments(t, false)
+	if _, err := parseAttachmentURL("http://169.254.169.254/latest/meta-data", ""); err == nil {
+		t.Error("parseAttachmentURL() accepted an http URL with remote attachments disabled")
+	}
+
+	useRemoteAttachments(t, true)
+	attachment, err := parseAttachmentURL("https://example.com/cat.png", "")
+	if err != nil || attachment.URL != "https://example.com/cat.png" {
+		t.Errorf("parseAttachmentURL() = %+v, %v, want the URL kept for download", attachment, err)
+	}
+}
+
+func TestUploadAttachmentsLimit(t *testing.T) {
+	messages := []Message{{Role: "user", Content: "hi"}}
+	for i := 0; i <= MaxAttachments; i++ {
+		messages[0].Attachments = append(messages[0].Attachments, Attachment{Data: []byte("x")})
+	}
+	if _, err := uploadAttachments(context.Background(), "token", messages); !errors.Is(err, errTooManyAttachments) {
+		t.Errorf("uploadAttachments() error = %v, want errTooManyAttachments", err)
+	}
+}
@@ -0,0 +1,72 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// modelOverrideKey 是请求上下文中保存路由确定的模型名称的键。
+type modelOverrideKey struct{}
+
+// withModelOverride 返回携带模型名称的请求，处理函数会用它覆盖请求体中的 model。
+func withModelOverride(r *http.Request, model string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), modelOverrideKey{}, model))
+}
+
+// modelOverride 返回路由确定的模型名称（如 Azure 部署名），不存在时返回空字符串。
+func modelOverride(r *http.Request) string {
+	model, _ := r.Context().Value(modelOverrideKey{}).(string)
+	return model
+}
+
+// parseAzureDeploymentPath 解析 /openai/deployments/{deployment}/{operation} 形式的路径。
+func parseAzureDeploymentPath(path string) (deployment, operation string, ok bool) {
+	rest, found := strings.CutPrefix(path, "/openai/deployments/")
+	if !found {
+		return "", "", false
+	}
+	deployment, operation, found = strings.Cut(rest, "/")
+	if !found || deployment == "" {
+		return "", "", false
+	}
+	return deployment, operation, true
+}
+
+// resolveAzureDeployment 将部署名解析为模型名称：依次匹配 modelMap、agent 模型
+// 以及 You.com 模型名称本身。
+func resolveAzureDeployment(deployment string) (string, bool) {
+	if _, exists := modelMap[deployment]; exists {
+		return deployment, true
+	}
+	if isAgentModel(deployment) {
+		return deployment, true
+	}
+	if model, exists := getReverseModelMap()[deployment]; exists {
+		return model, true
+	}
+	return "", false
+}
+
+// handleAzureDeployment 处理 Azure OpenAI 风格的部署路由。chat/completions 返回
+// 带模型覆盖的请求交由 Handler 继续处理，其余请求在此处理完毕并返回 nil。
+func handleAzureDeployment(w http.ResponseWriter, r *http.Request, deployment, operation string) *http.Request {
+	model, found := resolveAzureDeployment(deployment)
+	if !found && r.Method != "OPTIONS" {
+		writeOpenAIError(w, http.StatusNotFound, "invalid_request_error", "DeploymentNotFound",
+			fmt.Sprintf("The API deployment '%s' does not exist.", deployment))
+		return nil
+	}
+	r = withModelOverride(r, model)
+
+	switch operation {
+	case "chat/completions":
+		return r
+	case "completions":
+		handleCompletions(w, r)
+	default:
+		writeOpenAIError(w, http.StatusNotFound, "invalid_request_error", "", "Resource not found")
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+package handler
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseAzureDeploymentPath(t *testing.T) {
+	tests := []struct {
+		path           string
+		wantDeployment string
+		wantOperation  string
+		wantOK         bool
+	}{
+		{"/openai/deployments/gpt-4o/chat/completions", "gpt-4o", "chat/completions", true},
+		{"/openai/deployments/gpt-4o/completions", "gpt-4o", "completions", true},
+		{"/openai/deployments/gpt-4o/embeddings", "gpt-4o", "embeddings", true},
+		{"/openai/deployments/gpt-4o/", "gpt-4o", "", true},
+		{"/openai/deployments/gpt-4o", "", "", false},
+		{"/openai/deployments//chat/completions", "", "", false},
+		{"/openai/deployments/", "", "", false},
+		{"/v1/chat/completions", "", "", false},
+	}
+	for _, tt := range tests {
+		deployment, operation, ok := parseAzureDeploymentPath(tt.path)
+		if deployment != tt.wantDeployment || operation != tt.wantOperation || ok != tt.wantOK {
+			t.Errorf("parseAzureDeploymentPath(%q) = %q, %q, %v, want %q, %q, %v",
+				tt.path, deployment, operation, ok, tt.wantDeployment, tt.wantOperation, tt.wantOK)
+		}
+	}
+}
+
+func TestResolveAzureDeployment(t *testing.T) {
+	previous := agentModelIDs
+	agentModelIDs = []string{"my-agent"}
+	t.Cleanup(func() { agentModelIDs = previous })
+
+	tests := []struct {
+		name       string
+		deployment string
+		want       string
+		wantOK     bool
+	}{
+		{"modelMap key", "gpt-4o", "gpt-4o", true},
+		{"agent model", "my-agent", "my-agent", true},
+		{"You.com model name", mapModelName("gpt-4o"), reverseMapModelName(mapModelName("gpt-4o")), true},
+		{"unknown", "no-such-deployment", "", false},
+		{"empty", "", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			model, ok := resolveAzureDeployment(tt.deployment)
+			if model != tt.want || ok != tt.wantOK {
+				t.Errorf("resolveAzureDeployment(%q) = %q, %v, want %q, %v", tt.deployment, model, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+// azureRequest 以 api-key 头部发送 Azure 部署路由请求。
+func azureRequest(path, body string) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, path+"?api-version=2024-06-01", strings.NewReader(body))
+	req.Header.Set("api-key", "test-token")
+	return req
+}
+
+func TestAzureDeployment(t *testing.T) {
+	var model string
+	newFakeYou(t, func(w http.ResponseWriter, r *http.Request) {
+		model = r.URL.Query().Get("selectedAiModel")
+		io.WriteString(w, youTokens("Hello", " world"))
+	})
+
+	// 部署名覆盖请求体中的 model
+	tests := []struct {
+		name     string
+		path     string
+		body     string
+		wantText string
+	}{
+		{"chat", "/openai/deployments/gpt-4o/chat/completions", `{"model": "ignored", "messages": [{"role": "user", "content": "hi"}]}`, `"content":"Hello world"`},
+		{"chat stream", "/openai/deployments/gpt-4o/chat/completions", `{"stream": true, "messages": [{"role": "user", "content": "hi"}]}`, "data: [DONE]"},
+		{"completions", "/openai/deployments/gpt-4o/completions", `{"model": "ignored", "prompt": "hi"}`, `"text":"Hello world"`},
+		{"completions stream", "/openai/deployments/gpt-4o/completions", `{"stream": true, "prompt": "hi"}`, "data: [DONE]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			model = ""
+			rec := httptest.NewRecorder()
+			Handler(rec, azureRequest(tt.path, tt.body))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+			}
+			if model != mapModelName("gpt-4o") {
+				t.Errorf("upstream model = %q, want the deployment's model %q", model, mapModelName("gpt-4o"))
+			}
+			if !strings.Contains(rec.Body.String(), tt.wantText) {
+				t.Errorf("body = %s, want it to contain %s", rec.Body.String(), tt.wantText)
+			}
+			if strings.HasSuffix(tt.name, "stream") && !strings.Contains(rec.Body.String(), "Hello") {
+				t.Errorf("stream body = %s, want the streamed tokens", rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestAzureDeploymentErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		path     string
+		wantCode string
+	}{
+		{"unknown deployment", "/openai/deployments/no-such-deployment/chat/completions", "DeploymentNotFound"},
+		{"unknown operation", "/openai/deployments/gpt-4o/embeddings", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			Handler(rec, azureRequest(tt.path, `{"messages": [{"role": "user", "content": "hi"}]}`))
+			if rec.Code != http.StatusNotFound {
+				t.Fatalf("status = %d, want 404: %s", rec.Code, rec.Body.String())
+			}
+			var body struct {
+				Error struct {
+					Code *string `json:"code"`
+				} `json:"error"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatalf("invalid error body %q: %v", rec.Body.String(), err)
+			}
+			if code := body.Error.Code; tt.wantCode != "" && (code == nil || *code != tt.wantCode) {
+				t.Errorf("error code = %v, want %s", code, tt.wantCode)
+			}
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package handler
+
+import (
+	"regexp"
+	"strconv"
+	"unicode/utf8"
+
+	"you2api/youclient"
+)
+
+// Annotation 定义了 OpenAI 消息中的 annotations 元素。
+type Annotation struct {
+	Type        string      `json:"type"` // 目前只有 url_citation
+	URLCitation URLCitation `json:"url_citation"`
+}
+
+// URLCitation 定义了引用的网页及其在 content 中的位置（按字符计）。
+type URLCitation struct {
+	StartIndex int    `json:"start_index"`
+	EndIndex   int    `json:"end_index"`
+	URL        string `json:"url"`
+	Title      string `json:"title"`
+}
+
+// citationPattern 匹配回答中的引用标记：[1]、[[1]] 以及带链接的 [[1]](https://...)。
+var citationPattern = regexp.MustCompile(`\[\[?(\d+)\]?\](?:\((https?://[^)\s]+)\))?`)
+
+// citationAnnotations 根据回答中的引用标记生成 url_citation 注释。
+// 标记中的序号从 1 开始，对应搜索结果的顺序；带链接的标记直接使用其中的 URL。
+func citationAnnotations(content string, results []youclient.SearchResult) []Annotation {
+	var annotations []Annotation
+	for _, match := range citationPattern.FindAllStringSubmatchIndex(content, -1) {
+		n, _ := strconv.Atoi(content[match[2]:match[3]])
+		var citation URLCitation
+		switch {
+		case match[4] >= 0:
+			citation.URL = content[match[4]:match[5]]
+			citation.Title = searchResultTitle(results, citation.URL, n)
+		case n >= 1 && n <= len(results):
+			citation.URL = results[n-1].URL
+			citation.Title = results[n-1].Name
+		default:
+			continue // 没有对应搜索结果的方括号不是引用
+		}
+		citation.StartIndex = utf8.RuneCountInString(content[:match[0]])
+		citation.EndIndex = citation.StartIndex + utf8.RuneCountInString(content[match[0]:match[1]])
+		annotations = append(annotations, Annotation{Type: "url_citation", URLCitation: citation})
+	}
+	return annotations
+}
+
+// searchResultTitle 返回 URL 对应搜索结果的标题，找不到时按序号查找。
+func searchResultTitle(results []youclient.SearchResult, url string, n int) string {
+	for _, result := range results {
+		if result.URL == url {
+			return result.Name
+		}
+	}
+	if n >= 1 && n <= len(results) {
+		return results[n-1].Name
+	}
+	return ""
+}
+
+// citationURLs 返回按顺序排列的搜索结果 URL，用作 citations 扩展字段。
+func citationURLs(results []youclient.SearchResult) []string {
+	var urls []string
+	for _, result := range results {
+		urls = append(urls, result.URL)
+	}
+	return urls
+}
@@ -0,0 +1,33 @@
+package handler
+
+import (
+	"testing"
+
+	"you2api/youclient"
+)
+
+func TestCitationAnnotations(t *testing.T) {
+	results := youclient.Event{Name: youclient.EventSearchResults, Data: `{"search": {"third_party_search_results": [
+		{"url": "https://a.example", "name": "A", "snippet": "a"},
+		{"url": "https://b.example", "name": "B", "snippet": "b"}
+	]}}`}.SearchResults()
+	if len(results) != 2 {
+		t.Fatalf("SearchResults() = %d results, want 2", len(results))
+	}
+
+	content := "你好[1]，见 [[2]](https://b.example) 与 [3]"
+	annotations := citationAnnotations(content, results)
+	if len(annotations) != 2 {
+		t.Fatalf("citationAnnotations() = %+v, want 2 annotations", annotations)
+	}
+
+	want := []URLCitation{
+		{StartIndex: 2, EndIndex: 5, URL: "https://a.example", Title: "A"},
+		{StartIndex: 8, EndIndex: 32, URL: "https://b.example", Title: "B"},
+	}
+	for i, annotation := range annotations {
+		if annotation.Type != "url_citation" || annotation.URLCitation != want[i] {
+			t.Errorf("annotation %d = %+v, want %+v", i, annotation.URLCitation, want[i])
+		}
+	}
+}
@@ -0,0 +1,235 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// CompletionRequest 定义了旧版 /v1/completions 请求体的结构。
+type CompletionRequest struct {
+	Model     string          `json:"model"`
+	Prompt    json.RawMessage `json:"prompt"` // 字符串或字符串数组
+	Stream    bool            `json:"stream"`
+	Echo      bool            `json:"echo"`
+	Stop      json.RawMessage `json:"stop,omitempty"` // 字符串或字符串数组
+	MaxTokens int             `json:"max_tokens,omitempty"`
+	You       *YouOptions     `json:"you,omitempty"` // 按请求覆盖的 You.com 选项
+}
+
+// CompletionResponse 定义了 text_completion 响应（及流式块）的结构。
+type CompletionResponse struct {
+	ID      string             `json:"id"`
+	Object  string             `json:"object"`
+	Created int64              `json:"created"`
+	Model   string             `json:"model"`
+	Choices []CompletionChoice `json:"choices"`
+	Usage   *TokenCount        `json:"usage,omitempty"` // 仅在非流式响应与最后一个流式块中输出
+}
+
+// CompletionChoice 定义了 text_completion 响应中 choices 数组的单个元素。
+type CompletionChoice struct {
+	Text         string      `json:"text"`
+	Index        int         `json:"index"`
+	Logprobs     interface{} `json:"logprobs"`
+	FinishReason *string     `json:"finish_reason"`
+}
+
+// handleCompletions 处理 /v1/completions 请求，将 prompt 作为单条 user 消息发送给 You.com。
+func handleCompletions(w http.ResponseWriter, r *http.Request) {
+	// 设置 CORS 头部
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "*")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	credential, _ := extractDSToken(r)
+	if _, err := authorizeCredential(credential); err != nil {
+		writeAccountError(w, err)
+		return
+	}
+
+	var completionReq CompletionRequest
+	if err := json.NewDecoder(r.Body).Decode(&completionReq); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if model := modelOverride(r); model != "" {
+		completionReq.Model = model
+	}
+
+	account, err := acquireAccount(w, accountRequest{credential: credential, model: completionReq.Model, stream: completionReq.Stream})
+	if err != nil {
+		writeAccountError(w, err)
+		return
+	}
+	defer account.release()
+	dsToken := account.token
+
+	prompt, err := parsePrompt(completionReq.Prompt)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	stops, err := parseStopSequences(completionReq.Stop)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	limiter := newOutputLimiter(outputLimits{Stops: stops, MaxTokens: completionReq.MaxTokens})
+
+	youOptions, err := resolveYouOptions(r, completionReq.You)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	youReq, err := buildYouRequest(r.Context(), dsToken, completionReq.Model, []Message{{Role: "user", Content: prompt}}, youOptions)
+	if err != nil {
+		if clientCanceled(r.Context(), "completions", stagePrepare) {
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	events, err := openYouStream(account, newUpstreamClient(upstreamTimeoutFor(completionReq.Stream)), youReq)
+	if err != nil {
+		if clientCanceled(r.Context(), "completions", stageRequest) {
+			return
+		}
+		var inBandErr *inBandError
+		if errors.As(err, &inBandErr) {
+			inBandErr.write(w)
+			return
+		}
+		http.Error(w, err.Error(), upstreamStatus(err, http.StatusInternalServerError))
+		return
+	}
+	defer events.Close()
+
+	id := "cmpl-" + fmt.Sprintf("%d", time.Now().UnixNano())
+	created := time.Now().Unix()
+	model := reverseMapModelName(mapModelName(completionReq.Model))
+	promptTokens := estimateTextTokens(prompt)
+	// usage 根据生成的文本计算用量并计入指标
+	usage := func(completion string) *TokenCount {
+		count := newTokenCount(promptTokens, estimateTextTokens(completion))
+		recordUsage(account, completionReq.Model, count.PromptTokens, count.CompletionTokens)
+		return &count
+	}
+
+	if !completionReq.Stream {
+		var fullResponse strings.Builder
+		err := readYouChatTokens(events, func(token string) bool {
+			text, done := limiter.push(token)
+			fullResponse.WriteString(text)
+			return !done
+		})
+		if err != nil {
+			if clientCanceled(r.Context(), "completions", stageStream) {
+				return
+			}
+			http.Error(w, "Error reading response", http.StatusInternalServerError)
+			return
+		}
+		fullResponse.WriteString(limiter.flush())
+		finishReason := limiter.FinishReason()
+
+		text := fullResponse.String()
+		if completionReq.Echo {
+			text = prompt + text
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(CompletionResponse{
+			ID:      id,
+			Object:  "text_completion",
+			Created: created,
+			Model:   model,
+			Choices: []CompletionChoice{{Text: text, FinishReason: &finishReason}},
+			Usage:   usage(fullResponse.String()),
+		})
+		return
+	}
+
+	// 设置流式响应的头部
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	writeChunk := func(text string, finishReason *string, usage *TokenCount) {
+		chunk := CompletionResponse{
+			ID:      id,
+			Object:  "text_completion",
+			Created: created,
+			Model:   model,
+			Choices: []CompletionChoice{{Text: text, FinishReason: finishReason}},
+			Usage:   usage,
+		}
+		chunkBytes, _ := json.Marshal(chunk)
+		fmt.Fprintf(w, "data: %s\n\n", string(chunkBytes))
+		if flusher, ok := w.(http.Flusher); ok {
+			flusher.Flush()
+		}
+	}
+
+	if completionReq.Echo {
+		writeChunk(prompt, nil, nil)
+	}
+	var completion strings.Builder
+	writeText := func(text string) {
+		if text != "" {
+			completion.WriteString(text)
+			writeChunk(text, nil, nil)
+		}
+	}
+	err = readYouChatTokens(events, func(token string) bool {
+		text, done := limiter.push(token)
+		writeText(text)
+		return !done
+	})
+	if err != nil {
+		if clientCanceled(r.Context(), "completions", stageStream) {
+			return
+		}
+		// 响应头已发送，只能通过错误块通知客户端
+		fmt.Printf("读取流式响应失败: %v\n", err)
+		writeStreamError(w, "Error reading response")
+		return
+	}
+	writeText(limiter.flush())
+	finishReason := limiter.FinishReason()
+	writeChunk("", &finishReason, usage(completion.String()))
+	fmt.Fprint(w, "data: [DONE]\n\n")
+	if flusher, ok := w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// parsePrompt 解析字符串或字符串数组形式的 prompt，数组元素以换行拼接为一条消息。
+func parsePrompt(raw json.RawMessage) (string, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return "", errors.New("Missing prompt")
+	}
+
+	var prompt string
+	if err := json.Unmarshal(raw, &prompt); err == nil {
+		return prompt, nil
+	}
+
+	var prompts []string
+	if err := json.Unmarshal(raw, &prompts); err != nil {
+		return "", errors.New("prompt must be a string or an array of strings")
+	}
+	if len(prompts) == 0 {
+		return "", errors.New("Missing prompt")
+	}
+	return strings.Join(prompts, "\n"), nil
+}
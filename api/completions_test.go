@@ -0,0 +1,129 @@
+package handler
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParsePrompt(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     string
+		want    string
+		wantErr string
+	}{
+		{"string", `"Say hi"`, "Say hi", ""},
+		{"array", `["line one", "line two"]`, "line one\nline two", ""},
+		{"single element array", `["only"]`, "only", ""},
+		{"empty string", `""`, "", ""},
+		{"missing", ``, "", "Missing prompt"},
+		{"null", `null`, "", "Missing prompt"},
+		{"empty array", `[]`, "", "Missing prompt"},
+		{"token array", `[1, 2, 3]`, "", "prompt must be a string or an array of strings"},
+		{"object", `{"text": "hi"}`, "", "prompt must be a string or an array of strings"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prompt, err := parsePrompt(json.RawMessage(tt.raw))
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("parsePrompt(%s) error = %v, want %q", tt.raw, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || prompt != tt.want {
+				t.Errorf("parsePrompt(%s) = %q, %v, want %q", tt.raw, prompt, err, tt.want)
+			}
+		})
+	}
+}
+
+// completionRequest 发送 /v1/completions 请求。
+func completionRequest(body string) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "/v1/completions", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer test-token")
+	return req
+}
+
+func TestCompletions(t *testing.T) {
+	var query string
+	newFakeYou(t, func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query().Get("q")
+		io.WriteString(w, youTokens("Hello", " world"))
+	})
+
+	rec := httptest.NewRecorder()
+	Handler(rec, completionRequest(`{"model": "gpt-4o", "prompt": ["Say", "hi"], "echo": true}`))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+	if query != "Say\nhi" {
+		t.Errorf("upstream query = %q, want the joined prompt", query)
+	}
+
+	var response CompletionResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatalf("invalid response: %v", err)
+	}
+	if response.Object != "text_completion" || !strings.HasPrefix(response.ID, "cmpl-") || response.Model != "gpt-4o" {
+		t.Errorf("response = %+v", response)
+	}
+	if len(response.Choices) != 1 || response.Choices[0].Text != "Say\nhiHello world" {
+		t.Fatalf("choices = %+v, want the echoed prompt followed by the answer", response.Choices)
+	}
+	if response.Choices[0].FinishReason == nil || *response.Choices[0].FinishReason != "stop" {
+		t.Errorf("finish_reason = %v, want stop", response.Choices[0].FinishReason)
+	}
+	if response.Usage == nil || response.Usage.PromptTokens == 0 || response.Usage.TotalTokens != response.Usage.PromptTokens+response.Usage.CompletionTokens {
+		t.Errorf("usage = %+v", response.Usage)
+	}
+}
+
+func TestCompletionsStream(t *testing.T) {
+	newFakeYou(t, func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, youTokens("你好", "世界", "！"))
+	})
+
+	rec := httptest.NewRecorder()
+	Handler(rec, completionRequest(`{"model": "gpt-4o", "prompt": "count", "stream": true, "max_tokens": 3}`))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+
+	events := strings.Split(strings.TrimSpace(rec.Body.String()), "\n\n")
+	if events[len(events)-1] != "data: [DONE]" {
+		t.Fatalf("last event = %q, want data: [DONE]", events[len(events)-1])
+	}
+	var text string
+	var last CompletionResponse
+	for i, event := range events[:len(events)-1] {
+		var chunk CompletionResponse
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(event, "data: ")), &chunk); err != nil {
+			t.Fatalf("invalid chunk %q: %v", event, err)
+		}
+		if chunk.Object != "text_completion" || len(chunk.Choices) != 1 {
+			t.Fatalf("chunk = %+v", chunk)
+		}
+		// 只有最后一个块带有 finish_reason 与 usage
+		final := i == len(events)-2
+		if (chunk.Choices[0].FinishReason != nil) != final || (chunk.Usage != nil) != final {
+			t.Errorf("chunk %d: finish_reason = %v, usage = %v", i, chunk.Choices[0].FinishReason, chunk.Usage)
+		}
+		text += chunk.Choices[0].Text
+		last = chunk
+	}
+	// 中文字符每个按一个 token 估算
+	if text != "你好世" {
+		t.Errorf("text = %q, want the output truncated at max_tokens", text)
+	}
+	if reason := last.Choices[0].FinishReason; reason == nil || *reason != "length" {
+		t.Errorf("finish_reason = %v, want length", reason)
+	}
+	if last.Usage == nil || last.Usage.CompletionTokens != 3 {
+		t.Errorf("usage = %+v, want 3 completion tokens", last.Usage)
+	}
+}
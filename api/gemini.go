@@ -0,0 +1,290 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// GeminiRequest 定义了 Gemini generateContent 请求体的结构。
+type GeminiRequest struct {
+	Contents          []GeminiContent `json:"contents"`
+	SystemInstruction *GeminiContent  `json:"systemInstruction,omitempty"`
+}
+
+// GeminiContent 定义了 Gemini 的单条内容（role 为 user 或 model）。
+type GeminiContent struct {
+	Role  string       `json:"role,omitempty"`
+	Parts []GeminiPart `json:"parts"`
+}
+
+// GeminiPart 定义了 Gemini 内容中的单个部分，目前只处理文本。
+type GeminiPart struct {
+	Text string `json:"text,omitempty"`
+}
+
+// GeminiResponse 定义了 generateContent 响应（及流式块）的结构。
+type GeminiResponse struct {
+	Candidates    []GeminiCandidate    `json:"candidates"`
+	UsageMetadata *GeminiUsageMetadata `json:"usageMetadata,omitempty"`
+	ModelVersion  string               `json:"modelVersion"`
+}
+
+// GeminiCandidate 定义了 Gemini 响应中的单个候选结果。
+type GeminiCandidate struct {
+	Content      GeminiContent `json:"content"`
+	FinishReason string        `json:"finishReason,omitempty"`
+	Index        int           `json:"index"`
+}
+
+// GeminiUsageMetadata 定义了 Gemini 响应中的 token 用量。
+type GeminiUsageMetadata struct {
+	PromptTokenCount     int `json:"promptTokenCount"`
+	CandidatesTokenCount int `json:"candidatesTokenCount"`
+	TotalTokenCount      int `json:"totalTokenCount"`
+}
+
+// handleGemini 处理 /v1beta/models/{model}:generateContent 与 :streamGenerateContent 请求。
+func handleGemini(w http.ResponseWriter, r *http.Request) {
+	// 设置 CORS 头部
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "*")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	// 解析路径中的模型名称与方法
+	modelAndMethod := strings.TrimPrefix(r.URL.Path, "/v1beta/models/")
+	model, method, found := strings.Cut(modelAndMethod, ":")
+	if !found || (method != "generateContent" && method != "streamGenerateContent") {
+		writeGeminiError(w, http.StatusNotFound, "NOT_FOUND", fmt.Sprintf("Method not found: %s", modelAndMethod))
+		return
+	}
+
+	// Gemini 客户端通过 key 查询参数或 x-goog-api-key 头部传递密钥，同时兼容 Bearer
+	credential := r.URL.Query().Get("key")
+	if credential == "" {
+		credential = r.Header.Get("x-goog-api-key")
+	}
+	if credential == "" {
+		credential, _ = extractDSToken(r)
+	}
+	account, err := acquireAccount(w, accountRequest{credential: credential, model: model, stream: method == "streamGenerateContent"})
+	if err != nil {
+		status := accountErrorStatus(w, err)
+		statusText := "UNAVAILABLE"
+		switch status {
+		case http.StatusUnauthorized:
+			statusText = "UNAUTHENTICATED"
+		case http.StatusNotFound:
+			statusText = "NOT_FOUND"
+		case http.StatusTooManyRequests:
+			statusText = "RESOURCE_EXHAUSTED"
+		}
+		writeGeminiError(w, status, statusText, err.Error())
+		return
+	}
+	defer account.release()
+	dsToken := account.token
+
+	var geminiReq GeminiRequest
+	if err := json.NewDecoder(r.Body).Decode(&geminiReq); err != nil {
+		writeGeminiError(w, http.StatusBadRequest, "INVALID_ARGUMENT", "Invalid request body")
+		return
+	}
+
+	messages := geminiToMessages(geminiReq)
+	if len(messages) == 0 {
+		writeGeminiError(w, http.StatusBadRequest, "INVALID_ARGUMENT", "contents is not specified")
+		return
+	}
+
+	// Gemini 请求体没有扩展字段，只支持 X-You-* 头部
+	youOptions, err := resolveYouOptions(r, nil)
+	if err != nil {
+		writeGeminiError(w, http.StatusBadRequest, "INVALID_ARGUMENT", err.Error())
+		return
+	}
+
+	youReq, err := buildYouRequest(r.Context(), dsToken, model, messages, youOptions)
+	if err != nil {
+		if clientCanceled(r.Context(), "gemini", stagePrepare) {
+			return
+		}
+		writeGeminiError(w, http.StatusInternalServerError, "INTERNAL", err.Error())
+		return
+	}
+
+	events, err := openYouStream(account, newUpstreamClient(upstreamTimeoutFor(method == "streamGenerateContent")), youReq)
+	if err != nil {
+		if clientCanceled(r.Context(), "gemini", stageRequest) {
+			return
+		}
+		writeGeminiError(w, upstreamStatus(err, http.StatusBadGateway), "UNAVAILABLE", err.Error())
+		return
+	}
+	defer events.Close()
+
+	modelVersion := reverseMapModelName(mapModelName(model))
+	promptTokens, _ := countTokens(messages)
+	var fullResponse strings.Builder
+
+	// newChunk 构建只包含一段文本的候选结果
+	newChunk := func(text string) GeminiResponse {
+		return GeminiResponse{
+			Candidates: []GeminiCandidate{{
+				Content: GeminiContent{Role: "model", Parts: []GeminiPart{{Text: text}}},
+			}},
+			ModelVersion: modelVersion,
+		}
+	}
+	// usage 根据已生成的完整文本计算用量并计入指标，在最后一个块中调用一次
+	usage := func() *GeminiUsageMetadata {
+		completionTokens, _ := countTokens([]Message{{Role: "assistant", Content: fullResponse.String()}})
+		recordUsage(account, model, promptTokens, completionTokens)
+		return &GeminiUsageMetadata{
+			PromptTokenCount:     promptTokens,
+			CandidatesTokenCount: completionTokens,
+			TotalTokenCount:      promptTokens + completionTokens,
+		}
+	}
+
+	if method == "generateContent" {
+		err := readYouChatTokens(events, func(token string) bool {
+			fullResponse.WriteString(token)
+			return true
+		})
+		if err != nil {
+			if clientCanceled(r.Context(), "gemini", stageStream) {
+				return
+			}
+			writeGeminiError(w, http.StatusBadGateway, "UNAVAILABLE", "Error reading response")
+			return
+		}
+
+		geminiResp := newChunk(fullResponse.String())
+		geminiResp.Candidates[0].FinishReason = "STOP"
+		geminiResp.UsageMetadata = usage()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(geminiResp)
+		return
+	}
+
+	// alt=sse 时使用 SSE，否则与 Gemini REST 接口一致，流式输出一个 JSON 数组
+	sse := r.URL.Query().Get("alt") == "sse"
+	if sse {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, "[")
+	}
+
+	first := true
+	writeChunk := func(chunk GeminiResponse) {
+		chunkBytes, _ := json.Marshal(chunk)
+		if sse {
+			fmt.Fprintf(w, "data: %s\n\n", string(chunkBytes))
+		} else {
+			if !first {
+				fmt.Fprint(w, ",\n")
+			}
+			w.Write(chunkBytes)
+		}
+		first = false
+		if flusher, ok := w.(http.Flusher); ok {
+			flusher.Flush()
+		}
+	}
+
+	err = readYouChatTokens(events, func(token string) bool {
+		fullResponse.WriteString(token)
+		writeChunk(newChunk(token))
+		return true
+	})
+	if err != nil {
+		if clientCanceled(r.Context(), "gemini", stageStream) {
+			return
+		}
+		// 响应头已发送，以错误对象代替带 STOP 的末块；JSON 数组仍正常闭合
+		fmt.Printf("读取流式响应失败: %v\n", err)
+		errorBytes, _ := json.Marshal(map[string]interface{}{
+			"error": map[string]interface{}{
+				"code":    http.StatusBadGateway,
+				"message": "Error reading response",
+				"status":  "UNAVAILABLE",
+			},
+		})
+		if sse {
+			fmt.Fprintf(w, "data: %s\n\n", string(errorBytes))
+		} else {
+			if !first {
+				fmt.Fprint(w, ",\n")
+			}
+			w.Write(errorBytes)
+			fmt.Fprint(w, "]")
+		}
+		if flusher, ok := w.(http.Flusher); ok {
+			flusher.Flush()
+		}
+		return
+	}
+
+	final := newChunk("")
+	final.Candidates[0].FinishReason = "STOP"
+	final.UsageMetadata = usage()
+	writeChunk(final)
+	if !sse {
+		fmt.Fprint(w, "]")
+	}
+}
+
+// geminiToMessages 将 Gemini 的 contents 与 systemInstruction 转换为 Message 列表，
+// model 角色映射为 assistant，system 消息交由 convertSystemToUser 处理。
+func geminiToMessages(req GeminiRequest) []Message {
+	var messages []Message
+
+	if req.SystemInstruction != nil {
+		if system := geminiPartsText(req.SystemInstruction.Parts); system != "" {
+			messages = append(messages, Message{Role: "system", Content: system})
+		}
+	}
+
+	for _, content := range req.Contents {
+		role := "user"
+		if content.Role == "model" {
+			role = "assistant"
+		}
+		messages = append(messages, Message{Role: role, Content: geminiPartsText(content.Parts)})
+	}
+	return messages
+}
+
+// geminiPartsText 以换行拼接所有文本部分。
+func geminiPartsText(parts []GeminiPart) string {
+	var texts []string
+	for _, part := range parts {
+		if part.Text != "" {
+			texts = append(texts, part.Text)
+		}
+	}
+	return strings.Join(texts, "\n")
+}
+
+// writeGeminiError 以 Google API 错误格式返回错误。
+func writeGeminiError(w http.ResponseWriter, code int, status, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": map[string]interface{}{
+			"code":    code,
+			"message": message,
+			"status":  status,
+		},
+	})
+}
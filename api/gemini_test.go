@@ -0,0 +1,165 @@
+package handler
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestGeminiToMessages(t *testing.T) {
+	tests := []struct {
+		name string
+		json string
+		want []Message
+	}{
+		{
+			name: "single user turn",
+			json: `{"contents": [{"role": "user", "parts": [{"text": "hi"}]}]}`,
+			want: []Message{{Role: "user", Content: "hi"}},
+		},
+		{
+			name: "missing role defaults to user",
+			json: `{"contents": [{"parts": [{"text": "hi"}]}]}`,
+			want: []Message{{Role: "user", Content: "hi"}},
+		},
+		{
+			name: "model role and multiple parts",
+			json: `{"contents": [{"role": "user", "parts": [{"text": "a"}, {}, {"text": "b"}]}, {"role": "model", "parts": [{"text": "c"}]}, {"role": "user", "parts": [{"text": "d"}]}]}`,
+			want: []Message{{Role: "user", Content: "a\nb"}, {Role: "assistant", Content: "c"}, {Role: "user", Content: "d"}},
+		},
+		{
+			name: "system instruction",
+			json: `{"systemInstruction": {"parts": [{"text": "be brief"}]}, "contents": [{"role": "user", "parts": [{"text": "hi"}]}]}`,
+			want: []Message{{Role: "system", Content: "be brief"}, {Role: "user", Content: "hi"}},
+		},
+		{
+			name: "empty system instruction dropped",
+			json: `{"systemInstruction": {"parts": []}, "contents": [{"role": "user", "parts": [{"text": "hi"}]}]}`,
+			want: []Message{{Role: "user", Content: "hi"}},
+		},
+		{
+			name: "no contents",
+			json: `{"contents": []}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var req GeminiRequest
+			if err := json.Unmarshal([]byte(tt.json), &req); err != nil {
+				t.Fatalf("invalid test request: %v", err)
+			}
+			messages := geminiToMessages(req)
+			if len(messages) != len(tt.want) {
+				t.Fatalf("geminiToMessages() = %+v, want %+v", messages, tt.want)
+			}
+			for i, want := range tt.want {
+				if messages[i].Role != want.Role || messages[i].Content != want.Content {
+					t.Errorf("messages[%d] = %+v, want %+v", i, messages[i], want)
+				}
+			}
+		})
+	}
+}
+
+// geminiRequest 以 key 查询参数发送 Gemini 请求。
+func geminiRequest(path, body string) *http.Request {
+	separator := "?"
+	if strings.Contains(path, "?") {
+		separator = "&"
+	}
+	return httptest.NewRequest(http.MethodPost, path+separator+"key=test-token", strings.NewReader(body))
+}
+
+const geminiBody = `{"contents": [{"role": "user", "parts": [{"text": "hi"}]}]}`
+
+func TestGeminiGenerateContent(t *testing.T) {
+	var query string
+	newFakeYou(t, func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query().Get("q")
+		io.WriteString(w, youTokens("Hello", " world"))
+	})
+
+	rec := httptest.NewRecorder()
+	Handler(rec, geminiRequest("/v1beta/models/gpt-4o:generateContent", geminiBody))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+	if query != "hi" {
+		t.Errorf("upstream query = %q, want hi", query)
+	}
+
+	var response GeminiResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatalf("invalid response: %v", err)
+	}
+	if response.ModelVersion != "gpt-4o" || len(response.Candidates) != 1 {
+		t.Fatalf("response = %+v", response)
+	}
+	candidate := response.Candidates[0]
+	if candidate.Content.Role != "model" || len(candidate.Content.Parts) != 1 || candidate.Content.Parts[0].Text != "Hello world" || candidate.FinishReason != "STOP" {
+		t.Errorf("candidate = %+v, want model text Hello world with STOP", candidate)
+	}
+	usage := response.UsageMetadata
+	if usage == nil || usage.PromptTokenCount == 0 || usage.TotalTokenCount != usage.PromptTokenCount+usage.CandidatesTokenCount {
+		t.Errorf("usageMetadata = %+v", usage)
+	}
+}
+
+func TestGeminiStreamGenerateContent(t *testing.T) {
+	newFakeYou(t, func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, youTokens("Hello", " world"))
+	})
+
+	tests := []struct {
+		name            string
+		path            string
+		wantContentType string
+	}{
+		{"json array", "/v1beta/models/gpt-4o:streamGenerateContent", "application/json"},
+		{"sse", "/v1beta/models/gpt-4o:streamGenerateContent?alt=sse", "text/event-stream"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			Handler(rec, geminiRequest(tt.path, geminiBody))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+			}
+			if contentType := rec.Header().Get("Content-Type"); contentType != tt.wantContentType {
+				t.Errorf("Content-Type = %q, want %q", contentType, tt.wantContentType)
+			}
+
+			var chunks []GeminiResponse
+			if tt.wantContentType == "text/event-stream" {
+				for _, event := range strings.Split(strings.TrimSpace(rec.Body.String()), "\n\n") {
+					var chunk GeminiResponse
+					if err := json.Unmarshal([]byte(strings.TrimPrefix(event, "data: ")), &chunk); err != nil {
+						t.Fatalf("invalid event %q: %v", event, err)
+					}
+					chunks = append(chunks, chunk)
+				}
+			} else if err := json.Unmarshal(rec.Body.Bytes(), &chunks); err != nil {
+				t.Fatalf("invalid JSON array %q: %v", rec.Body.String(), err)
+			}
+
+			if len(chunks) != 3 {
+				t.Fatalf("got %d chunks, want two tokens and a final chunk", len(chunks))
+			}
+			var text string
+			for i, chunk := range chunks {
+				candidate := chunk.Candidates[0]
+				final := i == len(chunks)-1
+				if (candidate.FinishReason == "STOP") != final || (chunk.UsageMetadata != nil) != final {
+					t.Errorf("chunk %d: finishReason = %q, usageMetadata = %v", i, candidate.FinishReason, chunk.UsageMetadata)
+				}
+				text += candidate.Content.Parts[0].Text
+			}
+			if text != "Hello world" {
+				t.Errorf("text = %q, want Hello world", text)
+			}
+		})
+	}
+}
@@ -0,0 +1,131 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"you2api/inband"
+	"you2api/youclient"
+)
+
+// inbandDetector 识别以 200 返回的额度、鉴权提示与空回答（见 inband.FromEnv），为 nil 时不检查。
+var inbandDetector = inband.NewDefault()
+
+func init() {
+	detector, err := inband.FromEnv()
+	if err != nil {
+		fmt.Printf("加载内嵌错误识别规则失败，使用默认规则: %v\n", err)
+		return
+	}
+	inbandDetector = detector
+}
+
+// inBandError 表示上游返回了 200，但回答实际上是额度用尽、鉴权失败的提示或空回答。
+type inBandError struct {
+	kind inband.Kind
+	text string // 上游的原始回答
+}
+
+func (e *inBandError) Error() string {
+	switch e.kind {
+	case inband.KindQuota:
+		return "Upstream account quota exceeded: " + e.text
+	case inband.KindAuth:
+		return "Upstream account authentication failed: " + e.text
+	}
+	return "Upstream returned an empty response"
+}
+
+// StatusCode 返回等效的 HTTP 状态码，供重试策略判断是否可以重试。
+func (e *inBandError) StatusCode() int {
+	switch e.kind {
+	case inband.KindQuota:
+		return http.StatusTooManyRequests
+	case inband.KindAuth:
+		return http.StatusUnauthorized
+	}
+	return http.StatusBadGateway
+}
+
+// write 以 OpenAI 错误格式返回错误。
+func (e *inBandError) write(w http.ResponseWriter) {
+	switch e.kind {
+	case inband.KindQuota:
+		writeOpenAIError(w, e.StatusCode(), "insufficient_quota", "insufficient_quota", e.Error())
+	case inband.KindAuth:
+		writeOpenAIError(w, e.StatusCode(), "authentication_error", "upstream_authentication_failed", e.Error())
+	default:
+		writeOpenAIError(w, e.StatusCode(), "api_error", "empty_response", e.Error())
+	}
+}
+
+// detectInBand 预读响应的开头：回答在 inbandDetector.MaxChars 个字符内结束时检查完整回答，
+// 是额度、鉴权提示或空回答时返回 *inBandError。预读的事件随后放回 stream，调用方仍可完整读取。
+// 流式请求因此最多缓冲 MaxChars 个字符后才开始输出。
+func detectInBand(stream *youclient.Stream) error {
+	if inbandDetector == nil {
+		return nil
+	}
+
+	var events []youclient.Event
+	var text strings.Builder
+	chars := 0
+	for stream.Next() {
+		event := stream.Event()
+		events = append(events, event)
+
+		var content string
+		if token, ok := event.Token(); ok {
+			content = token
+		} else if isReasoningEvent(event.Name) {
+			content = reasoningEventText(event.Name, event.Data)
+		}
+		text.WriteString(content)
+		if chars += utf8.RuneCountInString(content); chars > inbandDetector.MaxChars {
+			stream.Unread(events...)
+			return nil
+		}
+	}
+	stream.Unread(events...)
+	if err := stream.Err(); err != nil {
+		return err
+	}
+
+	if kind, ok := inbandDetector.Detect(text.String()); ok {
+		fmt.Printf("检测到上游内嵌错误: kind=%s, text=%q\n", kind, text.String())
+		return &inBandError{kind: kind, text: strings.TrimSpace(text.String())}
+	}
+	return nil
+}
+
+// openYouStream 发送 You.com 请求并用 detectInBand 检查回答的开头，识别出的额度、鉴权提示或
+// 空回答以 *inBandError 返回并冷却账号。用于不重试的接口，聊天接口见 openChatStream。
+func openYouStream(account *upstreamAccount, client *http.Client, youReq *http.Request) (*youclient.Stream, error) {
+	events, err := doYouRequest(account, client, youReq)
+	if err != nil {
+		return nil, err
+	}
+	if err := detectInBand(events); err != nil {
+		events.Close()
+		account.report(err)
+		return nil, err
+	}
+	return events, nil
+}
+
+// upstreamStatus 返回上游错误对应的状态码：上游返回的状态码或内嵌错误的等效状态码，
+// 其他错误（如网络错误）返回 fallback。
+func upstreamStatus(err error, fallback int) int {
+	var statusErr *youclient.StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode
+	}
+	var inBandErr *inBandError
+	if errors.As(err, &inBandErr) {
+		return inBandErr.StatusCode()
+	}
+	return fallback
+}
@@ -0,0 +1,144 @@
+package handler
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"you2api/pool"
+	"you2api/retry"
+)
+
+func TestHandlerInBandErrors(t *testing.T) {
+	const limitMessage = "You've reached your daily limit. Upgrade to YouPro to keep chatting."
+	tests := []struct {
+		name        string
+		maxAttempts int
+		answers     map[string]string // DS token -> 回答
+		body        string
+		wantStatus  int
+		wantText    string
+		wantState   string // 账号 a 的状态
+	}{
+		{
+			name:        "quota message",
+			maxAttempts: 1,
+			answers:     map[string]string{"ta": limitMessage},
+			body:        `{"model": "gpt-4o", "stream": true, "messages": [{"role": "user", "content": "hi"}]}`,
+			wantStatus:  http.StatusTooManyRequests,
+			wantText:    `"code":"insufficient_quota"`,
+			wantState:   "cooling_down",
+		},
+		{
+			name:        "auth message",
+			maxAttempts: 1,
+			answers:     map[string]string{"ta": "Please log in to continue."},
+			body:        `{"model": "gpt-4o", "messages": [{"role": "user", "content": "hi"}]}`,
+			wantStatus:  http.StatusUnauthorized,
+			wantText:    `"code":"upstream_authentication_failed"`,
+			wantState:   "cooling_down",
+		},
+		{
+			name:        "empty answer",
+			maxAttempts: 1,
+			answers:     map[string]string{"ta": ""},
+			body:        `{"model": "gpt-4o", "messages": [{"role": "user", "content": "hi"}]}`,
+			wantStatus:  http.StatusBadGateway,
+			wantText:    `"code":"empty_response"`,
+			wantState:   "active",
+		},
+		{
+			name:        "answer mentioning status codes",
+			maxAttempts: 1,
+			answers:     map[string]string{"ta": "HTTP 401 means Unauthorized."},
+			body:        `{"model": "gpt-4o", "messages": [{"role": "user", "content": "hi"}]}`,
+			wantStatus:  http.StatusOK,
+			wantText:    "401 means Unauthorized",
+			wantState:   "active",
+		},
+		{
+			name:        "retry on another account",
+			maxAttempts: 2,
+			answers:     map[string]string{"ta": limitMessage, "tb": "Hello from b"},
+			body:        `{"model": "gpt-4o", "stream": true, "messages": [{"role": "user", "content": "hi"}]}`,
+			wantStatus:  http.StatusOK,
+			wantText:    "Hello from b",
+			wantState:   "cooling_down",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := retry.DefaultPolicy()
+			policy.MaxAttempts = tt.maxAttempts
+			policy.InitialBackoff = time.Millisecond
+			useRetryPolicy(t, policy)
+			p := useAccountPool(t, pool.AccountConfig{Name: "a", Token: "ta"}, pool.AccountConfig{Name: "b", Token: "tb"})
+			newFakeYou(t, func(w http.ResponseWriter, r *http.Request) {
+				for token, answer := range tt.answers {
+					if strings.Contains(r.Header.Get("Cookie"), "DS="+token+";") {
+						if answer != "" {
+							io.WriteString(w, youTokens(answer))
+						}
+						return
+					}
+				}
+				t.Errorf("unexpected account: %s", r.Header.Get("Cookie"))
+			})
+
+			rec := httptest.NewRecorder()
+			Handler(rec, chatRequest(tt.body))
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if !strings.Contains(rec.Body.String(), tt.wantText) {
+				t.Errorf("body = %s, want %s", rec.Body.String(), tt.wantText)
+			}
+			if tt.wantStatus != http.StatusOK && strings.Contains(rec.Body.String(), "chat.completion") {
+				t.Errorf("in-band error was streamed as a completion: %s", rec.Body.String())
+			}
+			if state := p.Status()[0].State; state != tt.wantState {
+				t.Errorf("account a state = %s, want %s", state, tt.wantState)
+			}
+		})
+	}
+}
+
+// TestInBandErrorsOtherEndpoints 其他接口同样识别内嵌错误，以各自的错误格式返回并冷却账号。
+func TestInBandErrorsOtherEndpoints(t *testing.T) {
+	tests := []struct {
+		name string
+		path string
+		body string
+	}{
+		{"anthropic", "/v1/messages", `{"model": "claude-3-opus", "max_tokens": 100, "messages": [{"role": "user", "content": "hi"}]}`},
+		{"completions", "/v1/completions", `{"model": "gpt-4o", "prompt": "hi"}`},
+		{"ollama chat", "/api/chat", `{"model": "gpt-4o", "messages": [{"role": "user", "content": "hi"}]}`},
+		{"ollama generate", "/api/generate", `{"model": "gpt-4o", "prompt": "hi"}`},
+		{"responses", "/v1/responses", `{"model": "gpt-4o", "input": "hi"}`},
+		{"gemini", "/v1beta/models/gpt-4o:generateContent", `{"contents": [{"role": "user", "parts": [{"text": "hi"}]}]}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := useAccountPool(t, pool.AccountConfig{Name: "a", Token: "ta"})
+			newFakeYou(t, func(w http.ResponseWriter, r *http.Request) {
+				io.WriteString(w, youTokens("You've reached your daily limit. Upgrade to YouPro to keep chatting."))
+			})
+
+			req := httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(tt.body))
+			req.Header.Set("Authorization", "Bearer test-token")
+			rec := httptest.NewRecorder()
+			Handler(rec, req)
+
+			if rec.Code != http.StatusTooManyRequests {
+				t.Errorf("status = %d, want 429: %s", rec.Code, rec.Body.String())
+			}
+			if state := p.Status()[0].State; state != "cooling_down" {
+				t.Errorf("account state = %s, want cooling_down", state)
+			}
+		})
+	}
+}
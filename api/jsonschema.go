@@ -0,0 +1,385 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// validateJSONSchema 按 JSON Schema 校验已解析的 JSON 值，返回所有错误。
+// 支持结构化输出常用的子集：type、enum、const、properties、required、
+// additionalProperties、items、min/max 系列约束、anyOf/oneOf/allOf 以及本地 $ref。
+func validateJSONSchema(schema, value interface{}) []string {
+	v := schemaValidator{root: schema, budget: &schemaBudget{steps: maxSchemaSteps}}
+	v.validate(schema, value, "$")
+	if v.budget.exceeded {
+		v.fail("$", "schema 过于复杂，校验超过 %d 步", maxSchemaSteps)
+	}
+	return v.errors
+}
+
+// maxSchemaSteps 是单次校验最多展开的子 schema 数量，防止 anyOf/oneOf 的分支
+// 组合使校验时间呈指数增长。
+const maxSchemaSteps = 10000
+
+// schemaBudget 是一次校验中所有 schemaValidator（包括 countMatches 创建的）共享的步数预算。
+type schemaBudget struct {
+	steps    int
+	exceeded bool
+}
+
+type schemaValidator struct {
+	root   interface{}
+	errors []string
+	depth  int
+	budget *schemaBudget
+}
+
+func (v *schemaValidator) fail(path, format string, args ...interface{}) {
+	v.errors = append(v.errors, path+": "+fmt.Sprintf(format, args...))
+}
+
+func (v *schemaValidator) validate(schema, value interface{}, path string) {
+	// 防止循环 $ref 导致无限递归
+	if v.depth > 64 {
+		return
+	}
+	if v.budget.steps <= 0 {
+		v.budget.exceeded = true
+		return
+	}
+	v.budget.steps--
+	v.depth++
+	defer func() { v.depth-- }()
+
+	switch s := schema.(type) {
+	case bool:
+		if !s {
+			v.fail(path, "不允许出现任何值")
+		}
+		return
+	case map[string]interface{}:
+		v.validateObjectSchema(s, value, path)
+	}
+}
+
+func (v *schemaValidator) validateObjectSchema(s map[string]interface{}, value interface{}, path string) {
+	if ref, ok := s["$ref"].(string); ok {
+		resolved, found := v.resolveRef(ref)
+		if !found {
+			v.fail(path, "无法解析 $ref %s", ref)
+			return
+		}
+		v.validate(resolved, value, path)
+	}
+
+	if types, ok := s["type"]; ok && !matchesType(types, value) {
+		v.fail(path, "类型应为 %v，实际为 %s", types, jsonTypeName(value))
+		return
+	}
+
+	if enum, ok := s["enum"].([]interface{}); ok {
+		matched := false
+		for _, candidate := range enum {
+			if reflect.DeepEqual(candidate, value) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			v.fail(path, "值必须是 %v 之一", enum)
+		}
+	}
+	if constant, ok := s["const"]; ok && !reflect.DeepEqual(constant, value) {
+		v.fail(path, "值必须等于 %v", constant)
+	}
+
+	v.validateCombinators(s, value, path)
+
+	switch val := value.(type) {
+	case map[string]interface{}:
+		v.validateObject(s, val, path)
+	case []interface{}:
+		v.validateArray(s, val, path)
+	case string:
+		length := utf8.RuneCountInString(val)
+		if min, ok := schemaNumber(s, "minLength"); ok && float64(length) < min {
+			v.fail(path, "长度不能小于 %v", min)
+		}
+		if max, ok := schemaNumber(s, "maxLength"); ok && float64(length) > max {
+			v.fail(path, "长度不能大于 %v", max)
+		}
+	case float64:
+		if min, ok := schemaNumber(s, "minimum"); ok && val < min {
+			v.fail(path, "不能小于 %v", min)
+		}
+		if max, ok := schemaNumber(s, "maximum"); ok && val > max {
+			v.fail(path, "不能大于 %v", max)
+		}
+		if min, ok := schemaNumber(s, "exclusiveMinimum"); ok && val <= min {
+			v.fail(path, "必须大于 %v", min)
+		}
+		if max, ok := schemaNumber(s, "exclusiveMaximum"); ok && val >= max {
+			v.fail(path, "必须小于 %v", max)
+		}
+	}
+}
+
+func (v *schemaValidator) validateCombinators(s map[string]interface{}, value interface{}, path string) {
+	if allOf, ok := s["allOf"].([]interface{}); ok {
+		for _, sub := range allOf {
+			v.validate(sub, value, path)
+		}
+	}
+	if anyOf, ok := s["anyOf"].([]interface{}); ok {
+		if v.countMatches(anyOf, value, path) == 0 {
+			v.fail(path, "不符合 anyOf 中的任何一个 schema")
+		}
+	}
+	if oneOf, ok := s["oneOf"].([]interface{}); ok {
+		if n := v.countMatches(oneOf, value, path); n != 1 {
+			v.fail(path, "必须恰好符合 oneOf 中的一个 schema，实际符合 %d 个", n)
+		}
+	}
+}
+
+// countMatches 返回 value 符合的子 schema 数量，不记录子 schema 的错误。
+func (v *schemaValidator) countMatches(schemas []interface{}, value interface{}, path string) int {
+	matches := 0
+	for _, sub := range schemas {
+		inner := schemaValidator{root: v.root, depth: v.depth, budget: v.budget}
+		inner.validate(sub, value, path)
+		if len(inner.errors) == 0 {
+			matches++
+		}
+	}
+	return matches
+}
+
+func (v *schemaValidator) validateObject(s map[string]interface{}, obj map[string]interface{}, path string) {
+	properties, _ := s["properties"].(map[string]interface{})
+
+	if required, ok := s["required"].([]interface{}); ok {
+		for _, name := range required {
+			key, _ := name.(string)
+			if _, exists := obj[key]; !exists {
+				v.fail(path, "缺少必需字段 %q", key)
+			}
+		}
+	}
+
+	// 按键名排序，保证错误顺序稳定
+	keys := make([]string, 0, len(obj))
+	for key := range obj {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		childPath := path + "." + key
+		if propSchema, ok := properties[key]; ok {
+			v.validate(propSchema, obj[key], childPath)
+			continue
+		}
+		switch additional := s["additionalProperties"].(type) {
+		case bool:
+			if !additional {
+				v.fail(path, "不允许额外字段 %q", key)
+			}
+		case map[string]interface{}:
+			v.validate(additional, obj[key], childPath)
+		}
+	}
+}
+
+func (v *schemaValidator) validateArray(s map[string]interface{}, arr []interface{}, path string) {
+	if min, ok := schemaNumber(s, "minItems"); ok && float64(len(arr)) < min {
+		v.fail(path, "元素数量不能少于 %v", min)
+	}
+	if max, ok := schemaNumber(s, "maxItems"); ok && float64(len(arr)) > max {
+		v.fail(path, "元素数量不能多于 %v", max)
+	}
+	if items, ok := s["items"]; ok {
+		for i, item := range arr {
+			v.validate(items, item, fmt.Sprintf("%s[%d]", path, i))
+		}
+	}
+}
+
+// resolveRef 解析 "#/$defs/name" 形式的本地引用。
+func (v *schemaValidator) resolveRef(ref string) (interface{}, bool) {
+	return resolveSchemaRef(v.root, ref)
+}
+
+// resolveSchemaRef 在 root 中解析 "#" 或 "#/..." 形式的本地引用。
+func resolveSchemaRef(root interface{}, ref string) (interface{}, bool) {
+	if ref == "#" {
+		return root, true
+	}
+	if !strings.HasPrefix(ref, "#/") {
+		return nil, false
+	}
+	current := root
+	for _, segment := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+		segment = strings.ReplaceAll(strings.ReplaceAll(segment, "~1", "/"), "~0", "~")
+		obj, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if current, ok = obj[segment]; !ok {
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+// matchesType 检查值是否符合 type（字符串或字符串数组）。
+func matchesType(types interface{}, value interface{}) bool {
+	switch t := types.(type) {
+	case string:
+		return matchesSingleType(t, value)
+	case []interface{}:
+		for _, item := range t {
+			if name, ok := item.(string); ok && matchesSingleType(name, value) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+func matchesSingleType(typeName string, value interface{}) bool {
+	switch typeName {
+	case "object":
+		_, ok := value.(map[string]interface{})
+		return ok
+	case "array":
+		_, ok := value.([]interface{})
+		return ok
+	case "string":
+		_, ok := value.(string)
+		return ok
+	case "number":
+		_, ok := value.(float64)
+		return ok
+	case "integer":
+		n, ok := value.(float64)
+		return ok && n == math.Trunc(n)
+	case "boolean":
+		_, ok := value.(bool)
+		return ok
+	case "null":
+		return value == nil
+	}
+	return true
+}
+
+func jsonTypeName(value interface{}) string {
+	switch value.(type) {
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case string:
+		return "string"
+	case float64:
+		return "number"
+	case bool:
+		return "boolean"
+	case nil:
+		return "null"
+	}
+	return "unknown"
+}
+
+func schemaNumber(s map[string]interface{}, key string) (float64, bool) {
+	n, ok := s[key].(float64)
+	return n, ok
+}
+
+// parseSchema 将原始 JSON Schema 解析为通用结构，并拒绝自我递归的 $ref。
+func parseSchema(raw json.RawMessage) (interface{}, error) {
+	var schema interface{}
+	if err := json.Unmarshal(raw, &schema); err != nil {
+		return nil, err
+	}
+	if err := checkSchemaRefs(schema); err != nil {
+		return nil, err
+	}
+	return schema, nil
+}
+
+// errRecursiveRef 表示 schema 中的 $ref 不经过 properties、items 等关键字就引用回自身。
+var errRecursiveRef = errors.New("$ref must not refer back to itself without descending into properties or items")
+
+// checkSchemaRefs 检查 schema 中是否存在只经过 $ref、allOf、anyOf、oneOf 的循环，
+// 如 {"anyOf": [{"$ref": "#"}]}。这类循环在校验同一个值时会不断展开；
+// 经过 properties、items 等关键字的递归引用会消耗输入，是合法的。
+func checkSchemaRefs(root interface{}) error {
+	const visiting, done = 1, 2
+	state := map[uintptr]int{}
+
+	var visit func(schema interface{}) error
+	visit = func(schema interface{}) error {
+		s, ok := schema.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		id := reflect.ValueOf(s).Pointer()
+		switch state[id] {
+		case visiting:
+			return errRecursiveRef
+		case done:
+			return nil
+		}
+		state[id] = visiting
+
+		var next []interface{}
+		if ref, ok := s["$ref"].(string); ok {
+			if resolved, found := resolveSchemaRef(root, ref); found {
+				next = append(next, resolved)
+			}
+		}
+		for _, keyword := range []string{"allOf", "anyOf", "oneOf"} {
+			subs, _ := s[keyword].([]interface{})
+			next = append(next, subs...)
+		}
+		for _, sub := range next {
+			if err := visit(sub); err != nil {
+				return err
+			}
+		}
+		state[id] = done
+		return nil
+	}
+
+	// 任何对象都可能作为子 schema 被引用，逐一检查
+	var walk func(node interface{}) error
+	walk = func(node interface{}) error {
+		switch n := node.(type) {
+		case map[string]interface{}:
+			if err := visit(n); err != nil {
+				return err
+			}
+			for _, child := range n {
+				if err := walk(child); err != nil {
+					return err
+				}
+			}
+		case []interface{}:
+			for _, child := range n {
+				if err := walk(child); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	return walk(root)
+}
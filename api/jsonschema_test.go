@@ -0,0 +1,156 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateJSONSchema(t *testing.T) {
+	schema := `{
+		"type": "object",
+		"properties": {
+			"name": {"type": "string", "minLength": 1},
+			"age": {"type": "integer", "minimum": 0},
+			"tags": {"type": "array", "items": {"$ref": "#/$defs/tag"}},
+			"role": {"enum": ["admin", "user"]}
+		},
+		"required": ["name", "age"],
+		"additionalProperties": false,
+		"$defs": {"tag": {"type": "string"}}
+	}`
+
+	tests := []struct {
+		name       string
+		value      string
+		wantErrors int
+	}{
+		{name: "合法对象", value: `{"name": "a", "age": 3, "tags": ["x"], "role": "user"}`, wantErrors: 0},
+		{name: "缺少必需字段", value: `{"name": "a"}`, wantErrors: 1},
+		{name: "类型错误", value: `{"name": "a", "age": 1.5}`, wantErrors: 1},
+		{name: "额外字段", value: `{"name": "a", "age": 1, "extra": true}`, wantErrors: 1},
+		{name: "引用的元素类型错误", value: `{"name": "a", "age": 1, "tags": [1, "x", 2]}`, wantErrors: 2},
+		{name: "枚举不匹配", value: `{"name": "a", "age": 1, "role": "root"}`, wantErrors: 1},
+		{name: "根类型错误", value: `[1, 2]`, wantErrors: 1},
+	}
+
+	parsedSchema, err := parseSchema(json.RawMessage(schema))
+	if err != nil {
+		t.Fatalf("parseSchema() error = %v", err)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var value interface{}
+			if err := json.Unmarshal([]byte(tt.value), &value); err != nil {
+				t.Fatalf("invalid test value: %v", err)
+			}
+			if errs := validateJSONSchema(parsedSchema, value); len(errs) != tt.wantErrors {
+				t.Errorf("validateJSONSchema() errors = %v, want %d errors", errs, tt.wantErrors)
+			}
+		})
+	}
+}
+
+func TestExtractJSON(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{name: "纯 JSON", text: `{"a": 1}`, want: `{"a": 1}`},
+		{name: "Markdown 代码块", text: "```json\n{\"a\": 1}\n```", want: `{"a": 1}`},
+		{name: "前后有说明文字", text: "结果如下：{\"a\": 1} 完成", want: `{"a": 1}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := extractJSON(tt.text); got != tt.want {
+				t.Errorf("extractJSON() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseSchemaRejectsRecursiveRef(t *testing.T) {
+	tests := []struct {
+		name    string
+		schema  string
+		wantErr bool
+	}{
+		{name: "根的 anyOf 引用自身", schema: `{"anyOf": [{"$ref": "#"}, {"$ref": "#"}]}`, wantErr: true},
+		{name: "$ref 指向自身", schema: `{"$defs": {"a": {"$ref": "#/$defs/a"}}, "$ref": "#/$defs/a"}`, wantErr: true},
+		{name: "经过 allOf 的间接循环", schema: `{"$defs": {"a": {"allOf": [{"$ref": "#/$defs/b"}]}, "b": {"oneOf": [{"$ref": "#/$defs/a"}]}}, "properties": {"x": {"$ref": "#/$defs/a"}}}`, wantErr: true},
+		{name: "经过 properties 的递归", schema: `{"type": "object", "properties": {"children": {"type": "array", "items": {"$ref": "#"}}}}`},
+		{name: "重复引用同一个定义", schema: `{"$defs": {"tag": {"type": "string"}}, "anyOf": [{"$ref": "#/$defs/tag"}, {"$ref": "#/$defs/tag"}]}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseSchema(json.RawMessage(tt.schema))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseSchema() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateJSONSchemaBudget(t *testing.T) {
+	// 没有循环，但每一层都有两个分支，完整展开需要 2^40 步
+	defs := map[string]interface{}{"d40": map[string]interface{}{"type": "string"}}
+	for i := 0; i < 40; i++ {
+		next := map[string]interface{}{"$ref": fmt.Sprintf("#/$defs/d%d", i+1)}
+		defs[fmt.Sprintf("d%d", i)] = map[string]interface{}{"anyOf": []interface{}{next, next}}
+	}
+	raw, _ := json.Marshal(map[string]interface{}{"$defs": defs, "$ref": "#/$defs/d0"})
+	schema, err := parseSchema(raw)
+	if err != nil {
+		t.Fatalf("parseSchema() error = %v", err)
+	}
+
+	done := make(chan []string, 1)
+	go func() { done <- validateJSONSchema(schema, float64(1)) }()
+	select {
+	case errs := <-done:
+		if len(errs) == 0 || !strings.Contains(errs[len(errs)-1], "过于复杂") {
+			t.Errorf("validateJSONSchema() errors = %v, want the step budget error", errs)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("validateJSONSchema() did not return within 5s")
+	}
+
+	// 未经 parseSchema 检查的自我递归 schema 同样受步数限制
+	recursive := map[string]interface{}{"anyOf": []interface{}{map[string]interface{}{"$ref": "#"}, map[string]interface{}{"$ref": "#"}}}
+	go func() { done <- validateJSONSchema(recursive, "x") }()
+	select {
+	case errs := <-done:
+		if len(errs) == 0 {
+			t.Error("validateJSONSchema() accepted a value after exhausting the step budget")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("validateJSONSchema() did not return within 5s for a self-recursive schema")
+	}
+}
+
+func TestHandlerRejectsRecursiveSchema(t *testing.T) {
+	searches := 0
+	newFakeYou(t, func(w http.ResponseWriter, r *http.Request) {
+		searches++
+		io.WriteString(w, youTokens(`"x"`))
+	})
+
+	body := `{"model": "gpt-4o", "messages": [{"role": "user", "content": "hi"}],
+		"response_format": {"type": "json_schema", "json_schema": {"name": "bad", "schema": {"anyOf": [{"$ref": "#"}, {"$ref": "#"}]}}}}`
+	rec := httptest.NewRecorder()
+	Handler(rec, chatRequest(body))
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "$ref") {
+		t.Errorf("status = %d: %s, want 400 for a self-recursive $ref", rec.Code, rec.Body.String())
+	}
+	if searches != 0 {
+		t.Errorf("upstream received %d requests, want none", searches)
+	}
+}
@@ -0,0 +1,150 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+// MaxStopSequences 是单个请求允许的停止序列数量上限（与 OpenAI 一致）。
+const MaxStopSequences = 4
+
+// outputLimits 定义了客户端对输出的限制：停止序列与最大输出 token 数（0 表示不限制）。
+type outputLimits struct {
+	Stops     []string
+	MaxTokens int
+}
+
+// parseStopSequences 解析字符串或字符串数组形式的 stop 参数。
+func parseStopSequences(raw json.RawMessage) ([]string, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+
+	var stop string
+	if err := json.Unmarshal(raw, &stop); err == nil {
+		if stop == "" {
+			return nil, nil
+		}
+		return []string{stop}, nil
+	}
+
+	var stops []string
+	if err := json.Unmarshal(raw, &stops); err != nil {
+		return nil, errors.New("stop must be a string or an array of strings")
+	}
+	return cleanStopSequences(stops)
+}
+
+// cleanStopSequences 去除空的停止序列并检查数量。
+func cleanStopSequences(stops []string) ([]string, error) {
+	var cleaned []string
+	for _, stop := range stops {
+		if stop != "" {
+			cleaned = append(cleaned, stop)
+		}
+	}
+	if len(cleaned) > MaxStopSequences {
+		return nil, errors.New("stop accepts at most 4 sequences")
+	}
+	return cleaned, nil
+}
+
+// outputLimiter 对逐个到达的 token 应用停止序列与长度限制。
+// 可能是停止序列前缀的结尾文本会被暂存，直到能够确定是否匹配。
+type outputLimiter struct {
+	limits       outputLimits
+	pending      string
+	englishCount int // 已输出文本中的 ASCII 字符数
+	otherCount   int // 已输出文本中的非 ASCII 字符数
+	finishReason string
+	stopSequence string
+}
+
+// newOutputLimiter 创建一个新的输出限制器。
+func newOutputLimiter(limits outputLimits) *outputLimiter {
+	return &outputLimiter{limits: limits}
+}
+
+// push 处理一个 token，返回可以立即输出的文本；done 为 true 时应停止读取上游。
+func (l *outputLimiter) push(token string) (string, bool) {
+	if l.finishReason != "" {
+		return "", true
+	}
+
+	text := l.pending + token
+	l.pending = ""
+
+	// 查找最早出现的停止序列
+	cut := -1
+	var matched string
+	for _, stop := range l.limits.Stops {
+		if idx := strings.Index(text, stop); idx >= 0 && (cut < 0 || idx < cut) {
+			cut = idx
+			matched = stop
+		}
+	}
+	if cut >= 0 {
+		out, capped := l.release(text[:cut])
+		if !capped {
+			l.finishReason = "stop"
+			l.stopSequence = matched
+		}
+		return out, true
+	}
+
+	// 暂存可能构成停止序列前缀的结尾部分
+	keep := 0
+	for _, stop := range l.limits.Stops {
+		if n := partialSuffixLen(text, stop); n > keep {
+			keep = n
+		}
+	}
+	l.pending = text[len(text)-keep:]
+	return l.release(text[:len(text)-keep])
+}
+
+// flush 在上游结束时调用，返回暂存的剩余文本。
+func (l *outputLimiter) flush() string {
+	if l.finishReason != "" {
+		return ""
+	}
+	out, _ := l.release(l.pending)
+	l.pending = ""
+	return out
+}
+
+// release 按最大 token 数截断要输出的文本，超出时标记为 length 并返回 true。
+func (l *outputLimiter) release(text string) (string, bool) {
+	if l.limits.MaxTokens <= 0 {
+		return text, false
+	}
+	for i, r := range text {
+		englishCount, otherCount := l.englishCount, l.otherCount
+		if r <= 127 {
+			englishCount++
+		} else {
+			otherCount++
+		}
+		// 与 estimateTextTokens 使用相同的估算方式
+		if int(float64(englishCount)*0.3+float64(otherCount)*1) > l.limits.MaxTokens {
+			l.finishReason = "length"
+			return text[:i], true
+		}
+		l.englishCount, l.otherCount = englishCount, otherCount
+	}
+	return text, false
+}
+
+// FinishReason 返回停止原因："stop"（正常结束或命中停止序列）或 "length"。
+func (l *outputLimiter) FinishReason() string {
+	if l.finishReason == "" {
+		return "stop"
+	}
+	return l.finishReason
+}
+
+// StopSequence 返回命中的停止序列，未命中时为空。
+func (l *outputLimiter) StopSequence() string {
+	return l.stopSequence
+}
@@ -0,0 +1,70 @@
+package handler
+
+import "testing"
+
+func TestOutputLimiter(t *testing.T) {
+	tests := []struct {
+		name       string
+		limits     outputLimits
+		tokens     []string
+		wantText   string
+		wantReason string
+	}{
+		{
+			name:       "无限制",
+			tokens:     []string{"Hello", " world"},
+			wantText:   "Hello world",
+			wantReason: "stop",
+		},
+		{
+			name:       "停止序列跨越 token 边界",
+			limits:     outputLimits{Stops: []string{"END"}},
+			tokens:     []string{"abc E", "N", "D tail"},
+			wantText:   "abc ",
+			wantReason: "stop",
+		},
+		{
+			name:       "部分匹配后继续输出",
+			limits:     outputLimits{Stops: []string{"END"}},
+			tokens:     []string{"abc EN", "ough"},
+			wantText:   "abc ENough",
+			wantReason: "stop",
+		},
+		{
+			name:       "达到最大 token 数",
+			limits:     outputLimits{MaxTokens: 3},
+			tokens:     []string{"0123456789", "abcdef"},
+			wantText:   "0123456789abc",
+			wantReason: "length",
+		},
+		{
+			name:       "中文按字符计数",
+			limits:     outputLimits{MaxTokens: 2},
+			tokens:     []string{"你好世界"},
+			wantText:   "你好",
+			wantReason: "length",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limiter := newOutputLimiter(tt.limits)
+			var got string
+			for _, token := range tt.tokens {
+				text, done := limiter.push(token)
+				got += text
+				if done {
+					break
+				}
+			}
+			got += limiter.flush()
+
+			if got != tt.wantText {
+				t.Errorf("text = %q, want %q", got, tt.wantText)
+			}
+			if reason := limiter.FinishReason(); reason != tt.wantReason {
+				t.Errorf("FinishReason() = %q, want %q", reason, tt.wantReason)
+			}
+		})
+	}
+}
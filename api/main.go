@@ -1,20 +1,19 @@
 package handler
 
 import (
-	"bufio"
-	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"math/rand"
-	"mime/multipart"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
 	"time"
 
-	"github.com/google/uuid"
+	"you2api/youclient"
 )
 
 // 新增：存储agent模型ID的全局变量
@@ -26,6 +25,9 @@ func init() {
 
 	// 新增：初始化agent模型ID
 	initAgentModelIDs()
+
+	// 账号池与本地 API key
+	initAccounts()
 }
 
 // 新增：初始化函数读取环境变量中的agent模型ID
@@ -71,46 +73,105 @@ type YouChatResponse struct {
 }
 
 // OpenAIStreamResponse 定义了 OpenAI API 流式响应的结构。
+// 启用 stream_options.include_usage 时，最后一个块的 choices 为空并携带 usage。
 type OpenAIStreamResponse struct {
-	ID      string   `json:"id"`
-	Object  string   `json:"object"`
-	Created int64    `json:"created"`
-	Model   string   `json:"model"`
-	Choices []Choice `json:"choices"`
+	ID                string      `json:"id"`
+	Object            string      `json:"object"`
+	Created           int64       `json:"created"`
+	Model             string      `json:"model"`
+	SystemFingerprint string      `json:"system_fingerprint,omitempty"`
+	Choices           []Choice    `json:"choices"`
+	Usage             *TokenCount `json:"usage,omitempty"`
+	Citations         []string    `json:"citations,omitempty"` // 扩展字段：搜索结果 URL，见 citations.go
 }
 
 // Choice 定义了 OpenAI 流式响应中 choices 数组的单个元素的结构。
+// FinishReason 仅在最后一个块中非 null。
 type Choice struct {
-	Delta        Delta  `json:"delta"`
-	Index        int    `json:"index"`
-	FinishReason string `json:"finish_reason"`
+	Delta        Delta   `json:"delta"`
+	Index        int     `json:"index"`
+	FinishReason *string `json:"finish_reason"`
 }
 
 // Delta 定义了流式响应中表示增量内容的结构。
 type Delta struct {
-	Content string `json:"content"`
+	Role             string          `json:"role,omitempty"`
+	Content          string          `json:"content,omitempty"`
+	ReasoningContent string          `json:"reasoning_content,omitempty"`
+	ToolCalls        []ToolCallDelta `json:"tool_calls,omitempty"`
+	Annotations      []Annotation    `json:"annotations,omitempty"`
 }
 
 // OpenAIRequest 定义了 OpenAI API 请求体的结构。
 type OpenAIRequest struct {
-	Messages []Message `json:"messages"`
-	Stream   bool      `json:"stream"`
-	Model    string    `json:"model"`
+	Messages       []Message       `json:"messages"`
+	Stream         bool            `json:"stream"`
+	StreamOptions  *StreamOptions  `json:"stream_options,omitempty"`
+	Model          string          `json:"model"`
+	Tools          []Tool          `json:"tools,omitempty"`
+	ToolChoice     json.RawMessage `json:"tool_choice,omitempty"` // "none"、"auto"、"required" 或指定函数
+	ResponseFormat *ResponseFormat `json:"response_format,omitempty"`
+
+	Stop                json.RawMessage `json:"stop,omitempty"` // 字符串或字符串数组
+	MaxTokens           int             `json:"max_tokens,omitempty"`
+	MaxCompletionTokens int             `json:"max_completion_tokens,omitempty"`
+
+	ReasoningFormat string `json:"reasoning_format,omitempty"` // separate、inline 或 hidden
+
+	You *YouOptions `json:"you,omitempty"` // 按请求覆盖的 You.com 选项，见 options.go
+}
+
+// outputLimits 返回请求中的停止序列与最大输出 token 数，max_completion_tokens 优先。
+func (req *OpenAIRequest) outputLimits() (outputLimits, error) {
+	stops, err := parseStopSequences(req.Stop)
+	if err != nil {
+		return outputLimits{}, err
+	}
+	maxTokens := req.MaxCompletionTokens
+	if maxTokens == 0 {
+		maxTokens = req.MaxTokens
+	}
+	return outputLimits{Stops: stops, MaxTokens: maxTokens}, nil
+}
+
+// includeUsage 报告流式请求是否要求在结尾输出 usage 块。
+func (req *OpenAIRequest) includeUsage() bool {
+	return req.StreamOptions != nil && req.StreamOptions.IncludeUsage
+}
+
+// completionText 返回助手消息中计入 completion_tokens 的文本（内容与工具调用）。
+func completionText(message Message) string {
+	text := message.Content
+	for _, call := range message.ToolCalls {
+		text += call.Function.Name + call.Function.Arguments
+	}
+	return text
 }
 
 // Message 定义了 OpenAI 聊天消息的结构。
+// 请求中的 content 可以是字符串或内容部分数组，文本部分合并到 Content，
+// 图片与文件部分解析为 Attachments（见 attachments.go）。
 type Message struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
+	Role             string       `json:"role"`
+	Content          string       `json:"content"`
+	ReasoningContent string       `json:"reasoning_content,omitempty"` // 仅用于响应，见 reasoning.go
+	Name             string       `json:"name,omitempty"`
+	ToolCalls        []ToolCall   `json:"tool_calls,omitempty"`
+	ToolCallID       string       `json:"tool_call_id,omitempty"`
+	Annotations      []Annotation `json:"annotations,omitempty"` // 仅用于响应，见 citations.go
+	Attachments      []Attachment `json:"-"`
 }
 
 // OpenAIResponse 定义了 OpenAI API 非流式响应的结构。
 type OpenAIResponse struct {
-	ID      string         `json:"id"`
-	Object  string         `json:"object"`
-	Created int64          `json:"created"`
-	Model   string         `json:"model"`
-	Choices []OpenAIChoice `json:"choices"`
+	ID                string         `json:"id"`
+	Object            string         `json:"object"`
+	Created           int64          `json:"created"`
+	Model             string         `json:"model"`
+	SystemFingerprint string         `json:"system_fingerprint,omitempty"`
+	Choices           []OpenAIChoice `json:"choices"`
+	Usage             TokenCount     `json:"usage"`
+	Citations         []string       `json:"citations,omitempty"` // 扩展字段：搜索结果 URL，见 citations.go
 }
 
 // OpenAIChoice 定义了 OpenAI 非流式响应中 choices 数组的单个元素的结构。
@@ -195,28 +256,20 @@ func reverseMapModelName(youModel string) string {
 	return "deepseek-chat" // 默认模型
 }
 
-// originalModel 存储原始的 OpenAI 模型名称。
-var originalModel string
-
 // NonceResponse 定义了获取 nonce 的响应结构
-type NonceResponse struct {
-	Uuid string
-}
+type NonceResponse = youclient.NonceResponse
 
 // UploadResponse 定义了文件上传的响应结构
-type UploadResponse struct {
-	Filename     string `json:"filename"`
-	UserFilename string `json:"user_filename"`
-}
+type UploadResponse = youclient.UploadResponse
+
+// upstreamTimeout 是非流式请求读取完整上游响应的超时时间。
+const upstreamTimeout = 60 * time.Second
 
 // 定义最大查询长度
 const MaxQueryLength = 2000
 
 // ChatEntry 定义了聊天历史中的单个问答对的结构
-type ChatEntry struct {
-	Question string `json:"question"`
-	Answer   string `json:"answer"`
-}
+type ChatEntry = youclient.ChatEntry
 
 // Handler 是处理所有传入 HTTP 请求的主处理函数。
 func Handler(w http.ResponseWriter, r *http.Request) {
@@ -253,6 +306,17 @@ func Handler(w http.ResponseWriter, r *http.Request) {
 			})
 		}
 
+		// 使用本地 API key 时只列出 key 允许的模型
+		if key := requestKey(r); key != nil {
+			allowed := models[:0]
+			for _, model := range models {
+				if key.AllowsModel(modelNames(model.ID)...) {
+					allowed = append(allowed, model)
+				}
+			}
+			models = allowed
+		}
+
 		response := ModelResponse{
 			Object: "list",
 			Data:   models,
@@ -262,8 +326,60 @@ func Handler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// 处理 Anthropic Messages API 请求
+	if r.URL.Path == "/v1/messages" {
+		handleAnthropicMessages(w, r)
+		return
+	}
+
+	// 处理旧版文本补全请求
+	if r.URL.Path == "/v1/completions" {
+		handleCompletions(w, r)
+		return
+	}
+
+	// 处理 Ollama 兼容接口
+	switch r.URL.Path {
+	case "/api/tags", "/api/version", "/api/chat", "/api/generate":
+		handleOllama(w, r)
+		return
+	}
+
+	// 处理 OpenAI Responses API 请求
+	if r.URL.Path == "/v1/responses" || strings.HasPrefix(r.URL.Path, "/v1/responses/") {
+		handleResponses(w, r)
+		return
+	}
+
+	// 处理独立的网页搜索请求
+	if r.URL.Path == "/v1/search" {
+		handleSearch(w, r)
+		return
+	}
+
+	// 处理 Gemini generateContent 请求
+	if strings.HasPrefix(r.URL.Path, "/v1beta/models/") {
+		handleGemini(w, r)
+		return
+	}
+
+	// 账号池状态
+	if r.URL.Path == "/v1/accounts/status" {
+		handleAccountsStatus(w, r)
+		return
+	}
+
+	// 处理 Azure OpenAI 风格的部署路由
+	isAzureChat := false
+	if deployment, operation, ok := parseAzureDeploymentPath(r.URL.Path); ok {
+		if r = handleAzureDeployment(w, r, deployment, operation); r == nil {
+			return
+		}
+		isAzureChat = true
+	}
+
 	// 处理非 /v1/chat/completions 请求（服务状态检查）
-	if r.URL.Path != "/v1/chat/completions" && r.URL.Path != "/none/v1/chat/completions" && r.URL.Path != "/such/chat/completions" {
+	if !isAzureChat && r.URL.Path != "/v1/chat/completions" && r.URL.Path != "/none/v1/chat/completions" && r.URL.Path != "/such/chat/completions" {
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(map[string]string{
 			"status":  "You2Api Service Running...",
@@ -282,13 +398,12 @@ func Handler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// 验证 Authorization 头部
-	authHeader := r.Header.Get("Authorization")
-	if !strings.HasPrefix(authHeader, "Bearer ") {
-		http.Error(w, "Missing or invalid authorization header", http.StatusUnauthorized)
+	// 校验凭据（DS token 或本地 API key），缺失时在解析请求体之前拒绝
+	credential, _ := extractDSToken(r)
+	if _, err := authorizeCredential(credential); err != nil {
+		writeAccountError(w, err)
 		return
 	}
-	dsToken := strings.TrimPrefix(authHeader, "Bearer ") // 提取 DS token
 
 	// 解析 OpenAI 请求体
 	var openAIReq OpenAIRequest
@@ -296,85 +411,191 @@ func Handler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
+	if model := modelOverride(r); model != "" {
+		openAIReq.Model = model
+	}
 
-	originalModel = openAIReq.Model
+	// 选择 You.com 账号：账号池中的账号（API key 限定的账号或分组），或 Authorization 中的 DS token
+	account, err := acquireAccount(w, accountRequest{credential: credential, model: openAIReq.Model, stream: openAIReq.Stream})
+	if err != nil {
+		writeAccountError(w, err)
+		return
+	}
+	defer account.release()
 
-	// 转换 system 消息为 user 消息
-	openAIReq.Messages = convertSystemToUser(openAIReq.Messages)
+	// 客户端指定的停止序列与输出长度限制
+	limits, err := openAIReq.outputLimits()
+	if err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, "invalid_request_error", "", err.Error())
+		return
+	}
 
-	// 打印OpenAI消息
-	fmt.Printf("\n=== 接收到的OpenAI消息 ===\n")
-	for i, msg := range openAIReq.Messages {
-		fmt.Printf("消息 %d: 角色=%s, 内容=%s\n", i, msg.Role, msg.Content)
+	// prompt token 数按客户端发送的完整消息列表估算
+	promptTokens, err := countTokens(openAIReq.Messages)
+	if err != nil {
+		http.Error(w, errCountTokens.Error(), http.StatusInternalServerError)
+		return
 	}
-	fmt.Printf("===================\n\n")
 
-	// 构建 You.com 聊天历史
-	var chatHistory []ChatEntry
-	var sources []map[string]interface{}
+	// 按请求覆盖的 You.com 选项
+	youOptions, err := resolveYouOptions(r, openAIReq.You)
+	if err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, "invalid_request_error", "", err.Error())
+		return
+	}
 
-	// 处理历史消息（不包括最后一条）
-	var currentQuestion string
-	var currentAnswer string
-	var hasQuestion bool
-	var hasAnswer bool
+	// 思考过程的返回方式
+	reasoning, err := resolveReasoningFormat(openAIReq.ReasoningFormat, openAIReq.Model)
+	if err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, "invalid_request_error", "", err.Error())
+		return
+	}
 
-	for i := 0; i < len(openAIReq.Messages)-1; i++ {
-		msg := openAIReq.Messages[i]
+	// 模拟工具调用：渲染工具说明并转换 tool 相关消息
+	tools := prepareToolEmulation(&openAIReq)
 
-		if msg.Role == "user" {
-			// 如果已经有问题和回答，添加到历史
-			if hasQuestion && hasAnswer {
-				chatHistory = append(chatHistory, ChatEntry{
-					Question: currentQuestion,
-					Answer:   currentAnswer,
-				})
-				// 重置状态
-				currentQuestion = msg.Content
-				currentAnswer = ""
-				hasQuestion = true
-				hasAnswer = false
-			} else if hasQuestion {
-				// 如果已经有问题但没有回答，合并问题
-				currentQuestion += "\n" + msg.Content
-			} else {
-				// 新的问题
-				currentQuestion = msg.Content
-				hasQuestion = true
-			}
-		} else if msg.Role == "assistant" {
-			if hasQuestion {
-				// 如果有问题，设置回答
-				currentAnswer = msg.Content
-				hasAnswer = true
-			} else if hasAnswer {
-				// 如果已经有回答但没有问题，合并回答
-				currentAnswer += "\n" + msg.Content
-			} else {
-				// 没有问题的回答，创建空问题
-				currentQuestion = ""
-				currentAnswer = msg.Content
-				hasQuestion = true
-				hasAnswer = true
-			}
-		}
+	// 结构化输出：在消息前插入 JSON 输出要求
+	structured, err := prepareStructuredOutput(&openAIReq)
+	if err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, "invalid_request_error", "", err.Error())
+		return
 	}
 
-	// 添加最后一对问答（如果有）
-	if hasQuestion {
-		if hasAnswer {
-			chatHistory = append(chatHistory, ChatEntry{
-				Question: currentQuestion,
-				Answer:   currentAnswer,
-			})
-		} else {
-			// 有问题但没有回答，添加空回答
-			chatHistory = append(chatHistory, ChatEntry{
-				Question: currentQuestion,
-				Answer:   "",
-			})
+	rc := newRequestContext(r.Context(), account, openAIReq.Model)
+	rc.tools = tools
+	rc.limits = limits
+	rc.reasoning = reasoning
+	rc.you = youOptions
+	rc.includeUsage = openAIReq.includeUsage()
+	rc.promptTokens = promptTokens
+	defer rc.logDone()
+
+	// 构建并发送请求，之后直接读取这一个响应，不会重复请求上游；失败时按重试策略
+	// 换用账号或回退模型。流式请求不设置总超时，客户端断开时通过请求的 context 取消。
+	client := newUpstreamClient(upstreamTimeoutFor(openAIReq.Stream && structured == nil))
+	events, ok := openChatStream(w, rc, client, openAIReq.Messages)
+	if !ok {
+		return
+	}
+	defer events.Close()
+
+	// 结构化输出需要校验完整结果，流式请求也在校验通过后一次性输出
+	if structured != nil {
+		handleStructuredResponse(w, events, client, openAIReq, structured, rc)
+		return
+	}
+
+	// 根据 OpenAI 请求的 stream 参数选择处理函数
+	if !openAIReq.Stream {
+		handleNonStreamingResponse(w, events, rc) // 处理非流式响应
+		return
+	}
+
+	handleStreamingResponse(w, events, rc) // 处理流式响应
+}
+
+// extractDSToken 从请求头中提取 DS token，支持 Authorization: Bearer
+// 以及 Azure/Anthropic 客户端使用的 api-key、x-api-key 头部。
+func extractDSToken(r *http.Request) (string, bool) {
+	authHeader := r.Header.Get("Authorization")
+	if strings.HasPrefix(authHeader, "Bearer ") {
+		return strings.TrimPrefix(authHeader, "Bearer "), true
+	}
+	for _, header := range []string{"api-key", "x-api-key"} {
+		if token := r.Header.Get(header); token != "" {
+			return token, true
 		}
 	}
+	return "", false
+}
+
+// OpenAIError 定义了 OpenAI 风格错误响应中的 error 对象。
+type OpenAIError struct {
+	Message string  `json:"message"`
+	Type    string  `json:"type"`
+	Param   *string `json:"param"`
+	Code    *string `json:"code"`
+}
+
+// writeOpenAIError 以 OpenAI 错误格式（{"error": {...}}）返回错误，code 为空时输出 null。
+func writeOpenAIError(w http.ResponseWriter, status int, errType, code, message string) {
+	apiErr := OpenAIError{Message: message, Type: errType}
+	if code != "" {
+		apiErr.Code = &code
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]OpenAIError{"error": apiErr})
+}
+
+// writeUpstreamError 以 OpenAI 错误格式返回上游请求失败：透传上游状态码，内嵌错误使用
+// inBandError 的错误码，网络错误返回 502。
+func writeUpstreamError(w http.ResponseWriter, err error) {
+	var inBandErr *inBandError
+	if errors.As(err, &inBandErr) {
+		inBandErr.write(w)
+		return
+	}
+	var statusErr *youclient.StatusError
+	if errors.As(err, &statusErr) {
+		writeOpenAIError(w, statusErr.StatusCode, "api_error", "", statusErr.Error())
+		return
+	}
+	writeOpenAIError(w, http.StatusBadGateway, "api_error", "", err.Error())
+}
+
+// 构建 You.com 请求过程中可能返回的错误，其文本直接返回给客户端。
+var (
+	errNonce       = errors.New("Failed to get nonce")
+	errTempFile    = errors.New("Failed to create temp file")
+	errUpload      = errors.New("Failed to upload file")
+	errCountTokens = errors.New("Failed to count tokens")
+	errNoMessages  = errors.New("No messages provided")
+
+	errTooManyAttachments = fmt.Errorf("Too many attachments, at most %d per request", MaxAttachments)
+)
+
+// youConversation 保存已经处理（长内容已上传）的聊天历史，供后续轮次直接复用，
+// 避免重复上传。LastQuestion 为本轮发送的查询，LastAnswer 为尚未上传的原始回答。
+type youConversation struct {
+	ChatHistory  []ChatEntry
+	Sources      []youclient.Source
+	LastQuestion string
+	LastAnswer   string
+}
+
+// buildYouRequest 将消息列表转换为 You.com streamingSearch 请求。
+// 它负责转换 system 消息、构建聊天历史、上传过长的内容并设置请求头与 Cookie，
+// 供所有兼容协议（OpenAI、Anthropic 等）共用。
+func buildYouRequest(ctx context.Context, dsToken string, model string, messages []Message, options YouOptions) (*http.Request, error) {
+	youReq, _, err := buildYouRequestWithHistory(ctx, dsToken, model, nil, messages, options)
+	return youReq, err
+}
+
+// buildYouRequestWithHistory 与 buildYouRequest 相同，但会在新消息之前拼接 prior 中
+// 已处理的历史，并返回本轮处理后的会话状态。
+func buildYouRequestWithHistory(ctx context.Context, dsToken string, model string, prior *youConversation, messages []Message, options YouOptions) (*http.Request, *youConversation, error) {
+	// 转换 system 消息为 user 消息
+	messages = convertSystemToUser(messages)
+	if len(messages) == 0 {
+		return nil, nil, errNoMessages
+	}
+
+	// 打印OpenAI消息
+	fmt.Printf("\n=== 接收到的OpenAI消息 ===\n")
+	for i, msg := range messages {
+		fmt.Printf("消息 %d: 角色=%s, 内容=%s\n", i, msg.Role, msg.Content)
+	}
+	fmt.Printf("===================\n\n")
+
+	// 上传消息中的图片与文件附件
+	sources, err := uploadAttachments(ctx, dsToken, messages)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// 构建 You.com 聊天历史
+	chatHistory := buildChatHistory(messages[:len(messages)-1])
 
 	// 处理聊天历史中的每个条目，上传文件
 	for i := range chatHistory {
@@ -386,86 +607,43 @@ func Handler(w http.ResponseWriter, r *http.Request) {
 
 			// 如果问题较长，上传为文件
 			if questionTokenCount >= 30 {
-				// 获取nonce
-				_, err := getNonce(dsToken)
+				source, ref, err := uploadTextAsSource(ctx, dsToken, entry.Question)
 				if err != nil {
-					fmt.Printf("获取nonce失败: %v\n", err)
-					http.Error(w, "Failed to get nonce", http.StatusInternalServerError)
-					return
+					return nil, nil, err
 				}
-
-				// 创建问题临时文件
-				questionShortFileName := generateShortFileName()
-				questionTempFile := questionShortFileName + ".txt"
-
-				if err := os.WriteFile(questionTempFile, addUTF8BOM(entry.Question), 0644); err != nil {
-					fmt.Printf("创建问题文件失败: %v\n", err)
-					http.Error(w, "Failed to create temp file", http.StatusInternalServerError)
-					return
-				}
-				defer os.Remove(questionTempFile)
-
-				// 上传问题文件
-				questionUploadResp, err := uploadFile(dsToken, questionTempFile)
-				if err != nil {
-					fmt.Printf("上传问题文件失败: %v\n", err)
-					http.Error(w, "Failed to upload file", http.StatusInternalServerError)
-					return
-				}
-
-				// 添加问题文件源信息
-				sources = append(sources, map[string]interface{}{
-					"source_type":   "user_file",
-					"filename":      questionUploadResp.Filename,
-					"user_filename": questionUploadResp.UserFilename,
-					"size_bytes":    len(entry.Question),
-				})
-
-				// 更新问题为文件引用
-				entry.Question = fmt.Sprintf("查看这个文件并且直接与文件内容进行聊天：%s.txt", strings.TrimSuffix(questionUploadResp.UserFilename, ".txt"))
+				sources = append(sources, source)
+				entry.Question = ref // 更新问题为文件引用
 			}
 		}
 
 		// 处理回答
 		if entry.Answer != "" {
-			// 获取nonce
-			_, err := getNonce(dsToken)
+			source, ref, err := uploadTextAsSource(ctx, dsToken, entry.Answer)
 			if err != nil {
-				fmt.Printf("获取nonce失败: %v\n", err)
-				http.Error(w, "Failed to get nonce", http.StatusInternalServerError)
-				return
-			}
-
-			// 创建回答临时文件
-			answerShortFileName := generateShortFileName()
-			answerTempFile := answerShortFileName + ".txt"
-
-			if err := os.WriteFile(answerTempFile, addUTF8BOM(entry.Answer), 0644); err != nil {
-				fmt.Printf("创建回答文件失败: %v\n", err)
-				http.Error(w, "Failed to create temp file", http.StatusInternalServerError)
-				return
+				return nil, nil, err
 			}
-			defer os.Remove(answerTempFile)
+			sources = append(sources, source)
+			entry.Answer = ref // 更新回答为文件引用
+		}
+	}
 
-			// 上传回答文件
-			answerUploadResp, err := uploadFile(dsToken, answerTempFile)
-			if err != nil {
-				fmt.Printf("上传回答文件失败: %v\n", err)
-				http.Error(w, "Failed to upload file", http.StatusInternalServerError)
-				return
+	// 拼接之前已处理的历史，只需上传上一轮的回答
+	if prior != nil {
+		history := append([]ChatEntry{}, prior.ChatHistory...)
+		sources = append(append([]youclient.Source{}, prior.Sources...), sources...)
+		if prior.LastQuestion != "" || prior.LastAnswer != "" {
+			lastEntry := ChatEntry{Question: prior.LastQuestion}
+			if prior.LastAnswer != "" {
+				source, ref, err := uploadTextAsSource(ctx, dsToken, prior.LastAnswer)
+				if err != nil {
+					return nil, nil, err
+				}
+				sources = append(sources, source)
+				lastEntry.Answer = ref
 			}
-
-			// 添加回答文件源信息
-			sources = append(sources, map[string]interface{}{
-				"source_type":   "user_file",
-				"filename":      answerUploadResp.Filename,
-				"user_filename": answerUploadResp.UserFilename,
-				"size_bytes":    len(entry.Answer),
-			})
-
-			// 更新回答为文件引用
-			entry.Answer = fmt.Sprintf("查看这个文件并且直接与文件内容进行聊天：%s.txt", strings.TrimSuffix(answerUploadResp.UserFilename, ".txt"))
+			history = append(history, lastEntry)
 		}
+		chatHistory = append(history, chatHistory...)
 	}
 
 	// 输出构建的聊天历史
@@ -474,115 +652,69 @@ func Handler(w http.ResponseWriter, r *http.Request) {
 		fmt.Printf("历史 %d: Q=%s, A=%s\n", i, entry.Question, entry.Answer)
 	}
 
-	chatHistoryJSON, _ := json.Marshal(chatHistory)
-
-	// 创建 You.com API 请求
-	youReq, _ := http.NewRequest("GET", "https://you.com/api/streamingSearch", nil)
-
-	// 生成必要的 ID
-	chatId := uuid.New().String()
-	conversationTurnId := uuid.New().String()
-	traceId := fmt.Sprintf("%s|%s|%s", chatId, conversationTurnId, time.Now().Format(time.RFC3339))
-
 	// 处理最后一条消息
-	lastMessage := openAIReq.Messages[len(openAIReq.Messages)-1]
+	lastMessage := messages[len(messages)-1]
 	lastMessageTokens, err := countTokens([]Message{lastMessage})
 	if err != nil {
-		http.Error(w, "Failed to count tokens", http.StatusInternalServerError)
-		return
+		return nil, nil, errCountTokens
 	}
 
 	// 构建查询参数
-	q := youReq.URL.Query()
-
-	// 设置基本参数
-	q.Add("page", "1")
-	q.Add("count", "10")
-	q.Add("safeSearch", "Moderate")
-	q.Add("mkt", "zh-HK")
-	q.Add("enable_worklow_generation_ux", "true")
-	q.Add("domain", "youchat")
-	q.Add("use_personalization_extraction", "true")
-	q.Add("queryTraceId", chatId)
-	q.Add("chatId", chatId)
-	q.Add("conversationTurnId", conversationTurnId)
-	q.Add("pastChatLength", fmt.Sprintf("%d", len(chatHistory)))
-	//q.Add("selectedChatMode", "custom")
-	//q.Add("selectedAiModel", mapModelName(openAIReq.Model))
-	q.Add("enable_agent_clarification_questions", "true")
-	q.Add("traceId", traceId)
-	q.Add("use_nested_youchat_updates", "true")
+	searchReq := &youclient.StreamingSearchRequest{
+		Count:      options.Count,
+		SafeSearch: options.SafeSearch,
+		Market:     options.Market,
+		Chat:       chatHistory,
+		Extra: url.Values{
+			"enable_worklow_generation_ux":         {"true"},
+			"use_personalization_extraction":       {"true"},
+			"enable_agent_clarification_questions": {"true"},
+			"use_nested_youchat_updates":           {"true"},
+		},
+	}
+	if options.WebAccess != nil {
+		searchReq.Extra.Set(youWebAccessParam, fmt.Sprintf("%t", *options.WebAccess))
+	}
 
 	// 新增：根据模型类型设置不同的参数
-	isAgent := isAgentModel(openAIReq.Model)
+	isAgent := isAgentModel(model)
 	if isAgent {
 		// 新增：Agent模型: 只使用selectedChatMode=agent模型ID
-		fmt.Printf("使用Agent模型: %s\n", openAIReq.Model)
-		q.Add("selectedChatMode", openAIReq.Model) // 修改：直接使用模型ID作为chatMode
+		fmt.Printf("使用Agent模型: %s\n", model)
+		searchReq.SelectedChatMode = model // 修改：直接使用模型ID作为chatMode
 	} else {
 		// 修改：默认模型: 使用selectedAiModel和selectedChatMode=custom
-		fmt.Printf("使用默认模型: %s (映射为: %s)\n", openAIReq.Model, mapModelName(openAIReq.Model))
-		q.Add("selectedAiModel", mapModelName(openAIReq.Model))
-		q.Add("selectedChatMode", "custom")
+		// 其他聊天模式（如 default、research）由 You.com 自行选择模型
+		fmt.Printf("使用默认模型: %s (映射为: %s)\n", model, mapModelName(model))
+		if options.ChatMode == "custom" {
+			searchReq.SelectedAIModel = mapModelName(model)
+		}
+		searchReq.SelectedChatMode = options.ChatMode
 	}
 
 	// 如果最后一条消息超过限制，使用文件上传
+	query := lastMessage.Content
 	if lastMessageTokens > MaxContextTokens {
-		// 获取 nonce - 不再需要nonce
-		_, err := getNonce(dsToken) // 仍然调用以保持API流程，但不使用返回值
-		if err != nil {
-			fmt.Printf("获取 nonce 失败: %v\n", err)
-			http.Error(w, "Failed to get nonce", http.StatusInternalServerError)
-			return
-		}
-
-		// 创建临时文件，使用短文件名
-		shortFileName := generateShortFileName()
-		tempFile := shortFileName + ".txt"
-
-		// 确保使用UTF-8编码写入文件，添加BOM标记
-		if err := os.WriteFile(tempFile, addUTF8BOM(lastMessage.Content), 0644); err != nil {
-			fmt.Printf("创建临时文件失败: %v\n", err)
-			http.Error(w, "Failed to create temp file", http.StatusInternalServerError)
-			return
-		}
-		defer os.Remove(tempFile)
-
-		// 上传文件
-		uploadResp, err := uploadFile(dsToken, tempFile)
+		source, ref, err := uploadTextAsSource(ctx, dsToken, lastMessage.Content)
 		if err != nil {
-			fmt.Printf("上传文件失败: %v\n", err)
-			http.Error(w, "Failed to upload file", http.StatusInternalServerError)
-			return
+			return nil, nil, err
 		}
-
-		// 添加文件源信息
-		sources = append(sources, map[string]interface{}{
-			"source_type":   "user_file",
-			"filename":      uploadResp.Filename,
-			"user_filename": uploadResp.UserFilename,
-			"size_bytes":    len(lastMessage.Content),
-		})
-
-		// 添加 sources 参数
-		sourcesJSON, _ := json.Marshal(sources)
-		q.Add("sources", string(sourcesJSON))
+		sources = append(sources, source)
 
 		// 使用文件引用作为查询，确保包含.txt后缀
-		q.Add("q", fmt.Sprintf("查看这个文件并且直接与文件内容进行聊天：%s.txt", strings.TrimSuffix(uploadResp.UserFilename, ".txt")))
-	} else {
-		// 如果有之前上传的文件，添加 sources
-		if len(sources) > 0 {
-			sourcesJSON, _ := json.Marshal(sources)
-			q.Add("sources", string(sourcesJSON))
-		}
-		q.Add("q", lastMessage.Content)
+		query = ref
 	}
+	searchReq.Query = query
+	searchReq.Sources = sources // 包括之前上传的文件
 
-	q.Add("chat", string(chatHistoryJSON))
-	youReq.URL.RawQuery = q.Encode()
+	// 创建 You.com API 请求
+	youReq, err := newYouClient(dsToken).NewStreamingSearchRequest(ctx, searchReq)
+	if err != nil {
+		return nil, nil, err
+	}
 
 	// 添加调试信息
+	chatHistoryJSON, _ := json.Marshal(chatHistory)
 	fmt.Printf("\n=== 聊天历史内容 ===\n")
 	fmt.Printf("历史条数: %d\n", len(chatHistory))
 	for i, entry := range chatHistory {
@@ -599,136 +731,281 @@ func Handler(w http.ResponseWriter, r *http.Request) {
 	for key, values := range youReq.Header {
 		fmt.Printf("%s: %v\n", key, values)
 	}
-
-	// 设置请求头
-	youReq.Header = http.Header{
-		"sec-ch-ua-platform":         {"Windows"},
-		"Cache-Control":              {"no-cache"},
-		"sec-ch-ua":                  {`"Not(A:Brand";v="99", "Microsoft Edge";v="133", "Chromium";v="133"`},
-		"sec-ch-ua-bitness":          {"64"},
-		"sec-ch-ua-model":            {""},
-		"sec-ch-ua-mobile":           {"?0"},
-		"sec-ch-ua-arch":             {"x86"},
-		"sec-ch-ua-full-version":     {"133.0.3065.39"},
-		"Accept":                     {"text/event-stream"},
-		"User-Agent":                 {"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/133.0.0.0 Safari/537.36 Edg/133.0.0.0"},
-		"sec-ch-ua-platform-version": {"19.0.0"},
-		"Sec-Fetch-Site":             {"same-origin"},
-		"Sec-Fetch-Mode":             {"cors"},
-		"Sec-Fetch-Dest":             {"empty"},
-		"Host":                       {"you.com"},
-	}
-
-	// 设置 Cookie
-	cookies := getCookies(dsToken)
-	var cookieStrings []string
-	for name, value := range cookies {
-		cookieStrings = append(cookieStrings, fmt.Sprintf("%s=%s", name, value))
-	}
-	youReq.Header.Add("Cookie", strings.Join(cookieStrings, ";"))
-	fmt.Printf("Cookie: %s\n", strings.Join(cookieStrings, ";"))
 	fmt.Printf("===================\n\n")
 
-	// 发送请求并获取响应
-	client := &http.Client{}
-	resp, err := client.Do(youReq)
-	if err != nil {
-		fmt.Printf("发送请求失败: %v\n", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+	conversation := &youConversation{
+		ChatHistory:  chatHistory,
+		Sources:      sources,
+		LastQuestion: query,
 	}
-	defer resp.Body.Close()
+	return youReq, conversation, nil
+}
 
-	// 打印响应状态码
-	fmt.Printf("响应状态码: %d\n", resp.StatusCode)
+// buildChatHistory 将历史消息（不包括最后一条）合并为 You.com 的问答对。
+func buildChatHistory(messages []Message) []ChatEntry {
+	var chatHistory []ChatEntry
 
-	// 如果状态码不是 200，打印响应内容
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		fmt.Printf("错误响应内容: %s\n", string(body))
-		http.Error(w, fmt.Sprintf("API returned status %d", resp.StatusCode), resp.StatusCode)
-		return
+	var currentQuestion string
+	var currentAnswer string
+	var hasQuestion bool
+	var hasAnswer bool
+
+	for _, msg := range messages {
+		if msg.Role == "user" {
+			// 如果已经有问题和回答，添加到历史
+			if hasQuestion && hasAnswer {
+				chatHistory = append(chatHistory, ChatEntry{
+					Question: currentQuestion,
+					Answer:   currentAnswer,
+				})
+				// 重置状态
+				currentQuestion = msg.Content
+				currentAnswer = ""
+				hasQuestion = true
+				hasAnswer = false
+			} else if hasQuestion {
+				// 如果已经有问题但没有回答，合并问题
+				currentQuestion += "\n" + msg.Content
+			} else {
+				// 新的问题
+				currentQuestion = msg.Content
+				hasQuestion = true
+			}
+		} else if msg.Role == "assistant" {
+			if hasQuestion {
+				// 如果有问题，设置回答
+				currentAnswer = msg.Content
+				hasAnswer = true
+			} else if hasAnswer {
+				// 如果已经有回答但没有问题，合并回答
+				currentAnswer += "\n" + msg.Content
+			} else {
+				// 没有问题的回答，创建空问题
+				currentQuestion = ""
+				currentAnswer = msg.Content
+				hasQuestion = true
+				hasAnswer = true
+			}
+		}
 	}
 
-	// 根据 OpenAI 请求的 stream 参数选择处理函数
-	if !openAIReq.Stream {
-		handleNonStreamingResponse(w, youReq) // 处理非流式响应
-		return
+	// 添加最后一对问答（如果有）
+	if hasQuestion {
+		// 有问题但没有回答时 currentAnswer 为空
+		chatHistory = append(chatHistory, ChatEntry{
+			Question: currentQuestion,
+			Answer:   currentAnswer,
+		})
 	}
 
-	handleStreamingResponse(w, youReq) // 处理流式响应
+	return chatHistory
 }
 
-// getCookies 根据提供的 DS token 生成所需的 Cookie。
-func getCookies(dsToken string) map[string]string {
-	return map[string]string{
-		"guest_has_seen_legal_disclaimer": "true",
-		"youchat_personalization":         "true",
-		"DS":                              dsToken,                // 关键的 DS token
-		"you_subscription":                "youpro_standard_year", // 示例订阅信息
-		"youpro_subscription":             "true",
-		"ai_model":                        "deepseek_r1", // 示例 AI 模型
-		"youchat_smart_learn":             "true",
+// uploadTextAsSource 将文本内容写入临时文件并上传，返回 sources 条目和用于查询的文件引用。
+func uploadTextAsSource(ctx context.Context, dsToken, content string) (youclient.Source, string, error) {
+	// 获取nonce
+	if _, err := getNonce(ctx, dsToken); err != nil {
+		fmt.Printf("获取nonce失败: %v\n", err)
+		return youclient.Source{}, "", errNonce
 	}
-}
 
-// handleNonStreamingResponse 处理非流式请求。
-func handleNonStreamingResponse(w http.ResponseWriter, youReq *http.Request) {
-	client := &http.Client{
-		Timeout: 60 * time.Second, // 设置超时时间
+	// 创建临时文件，使用短文件名
+	tempFile := generateShortFileName() + ".txt"
+
+	// 确保使用UTF-8编码写入文件，添加BOM标记
+	if err := os.WriteFile(tempFile, addUTF8BOM(content), 0644); err != nil {
+		fmt.Printf("创建临时文件失败: %v\n", err)
+		return youclient.Source{}, "", errTempFile
 	}
-	resp, err := client.Do(youReq)
+	defer os.Remove(tempFile)
+
+	// 上传文件
+	uploadResp, err := uploadFile(ctx, dsToken, tempFile)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		fmt.Printf("上传文件失败: %v\n", err)
+		return youclient.Source{}, "", errUpload
 	}
-	defer resp.Body.Close()
 
-	var fullResponse strings.Builder
-	scanner := bufio.NewScanner(resp.Body)
+	// 文件源信息
+	source := uploadResp.Source(len(content))
 
-	// 设置 scanner 的缓冲区大小（可选，但对于大型响应很重要）
-	buf := make([]byte, 0, 64*1024)
-	scanner.Buffer(buf, 1024*1024)
+	// 文件引用，确保包含.txt后缀
+	ref := fmt.Sprintf("查看这个文件并且直接与文件内容进行聊天：%s.txt", strings.TrimSuffix(uploadResp.UserFilename, ".txt"))
+	return source, ref, nil
+}
 
-	// 逐行扫描响应，寻找 youChatToken 事件
-	for scanner.Scan() {
-		line := scanner.Text()
-		if strings.HasPrefix(line, "event: youChatToken") {
-			scanner.Scan() // 读取下一行 (data 行)
-			data := scanner.Text()
-			if !strings.HasPrefix(data, "data: ") {
-				continue // 如果不是 data 行，则跳过
-			}
-			var token YouChatResponse
-			if err := json.Unmarshal([]byte(strings.TrimPrefix(data, "data: ")), &token); err != nil {
-				continue // 如果解析失败，则跳过
+// doYouRequest 使用 account 发送 You.com 请求并返回事件流，非 200 时返回 *youclient.StatusError，
+// 并据此冷却或移除账号池中的账号。
+func doYouRequest(account *upstreamAccount, client *http.Client, youReq *http.Request) (*youclient.Stream, error) {
+	stream, err := newYouClient(account.token, youclient.WithHTTPClient(client)).Do(youReq)
+	if err != nil {
+		account.report(err)
+		var statusErr *youclient.StatusError
+		if errors.As(err, &statusErr) {
+			// 打印错误响应内容
+			fmt.Printf("响应状态码: %d\n", statusErr.StatusCode)
+			fmt.Printf("错误响应内容: %s\n", statusErr.Body)
+		} else {
+			fmt.Printf("发送请求失败: %v\n", err)
+		}
+		return nil, err
+	}
+	return stream, nil
+}
+
+// readYouEvents 对 You.com 响应中的每个事件调用 onEvent，onEvent 返回 false 时停止读取。
+func readYouEvents(stream *youclient.Stream, onEvent func(event youclient.Event) bool) error {
+	for stream.Next() {
+		if !onEvent(stream.Event()) {
+			return nil
+		}
+	}
+	return stream.Err()
+}
+
+// readYouChatTokens 对 You.com 响应中的每个 youChatToken 调用 onToken，忽略其他事件。
+// onToken 返回 false 时停止读取。
+func readYouChatTokens(stream *youclient.Stream, onToken func(token string) bool) error {
+	return readYouEvents(stream, func(event youclient.Event) bool {
+		token, ok := event.Token()
+		if !ok {
+			return true
+		}
+		return onToken(token)
+	})
+}
+
+// readYouChatStream 逐个事件读取 You.com 响应，将思考过程与正文分别传给 onChunk，
+// 搜索结果传给 onSearch（可为 nil）。思考事件始终视为思考过程；splitThink 为 true 时
+// 还会拆分 youChatToken 中的 <think> 段落。onChunk 返回 false 时停止读取。
+func readYouChatStream(stream *youclient.Stream, splitThink bool, onChunk func(reasoning, content string) bool, onSearch func(results []youclient.SearchResult)) error {
+	var splitter thinkSplitter
+	stopped := false
+	emit := func(reasoning, content string) bool {
+		if reasoning == "" && content == "" {
+			return true
+		}
+		stopped = !onChunk(reasoning, content)
+		return !stopped
+	}
+
+	err := readYouEvents(stream, func(event youclient.Event) bool {
+		if token, ok := event.Token(); ok {
+			if !splitThink {
+				return emit("", token)
 			}
-			fullResponse.WriteString(token.YouChatToken) // 将 token 添加到完整响应中
+			return emit(splitter.push(token))
+		}
+		if isReasoningEvent(event.Name) {
+			return emit(reasoningEventText(event.Name, event.Data), "")
 		}
+		if results := event.SearchResults(); len(results) > 0 && onSearch != nil {
+			onSearch(results)
+		}
+		return true
+	})
+	if err != nil || stopped {
+		return err
+	}
+	if splitThink {
+		emit(splitter.flush())
+	}
+	return nil
+}
+
+// errReadResponse 表示读取 You.com 响应流失败。
+var errReadResponse = errors.New("Error reading response")
+
+// youAnswer 是聚合后的 You.com 响应：正文、思考过程、停止原因与搜索结果。
+type youAnswer struct {
+	Text         string
+	Reasoning    string
+	FinishReason string
+	Sources      []youclient.SearchResult
+}
+
+// collectYouResponse 读取上游响应并聚合所有 youChatToken 与思考过程，读取结束后关闭 events。
+// 命中停止序列或达到最大 token 数时提前关闭上游连接。
+func collectYouResponse(events *youclient.Stream, rc *requestContext) (youAnswer, error) {
+	defer events.Close()
+
+	var fullResponse, reasoning strings.Builder
+	var sources []youclient.SearchResult
+	limiter := newOutputLimiter(rc.limits)
+	err := readYouChatStream(events, isReasoningModel(rc.model), func(thinking, content string) bool {
+		reasoning.WriteString(thinking)
+		text, done := limiter.push(content)
+		fullResponse.WriteString(text) // 将 token 添加到完整响应中
+		return !done
+	}, func(results []youclient.SearchResult) {
+		sources = append(sources, results...)
+	})
+	if err != nil {
+		return youAnswer{}, errReadResponse
 	}
+	fullResponse.WriteString(limiter.flush())
+	return youAnswer{
+		Text:         fullResponse.String(),
+		Reasoning:    reasoning.String(),
+		FinishReason: limiter.FinishReason(),
+		Sources:      sources,
+	}, nil
+}
 
-	if scanner.Err() != nil {
-		http.Error(w, "Error reading response", http.StatusInternalServerError)
+// handleNonStreamingResponse 处理非流式请求。
+func handleNonStreamingResponse(w http.ResponseWriter, events *youclient.Stream, rc *requestContext) {
+	answer, err := collectYouResponse(events, rc)
+	if err != nil {
+		if rc.canceled(stageStream) {
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	message, finishReason := assistantMessage(answer.Text, answer.FinishReason, rc.tools)
+	usage := rc.recordUsage(answer.Reasoning + completionText(message))
+	rc.reasoning.apply(&message, answer.Reasoning)
+	message.Annotations = citationAnnotations(message.Content, answer.Sources)
+	writeChatCompletion(w, message, finishReason, usage, citationURLs(answer.Sources), rc)
+}
+
+// assistantMessage 根据完整的响应文本构建助手消息与停止原因，并解析模拟的工具调用。
+func assistantMessage(text string, finishReason string, tools *toolEmulation) (Message, string) {
+	message := Message{
+		Role:    "assistant",
+		Content: text, // 完整的响应内容
+	}
+
+	// 解析模拟的工具调用
+	if tools != nil {
+		if content, calls, ok := tools.parseToolCalls(message.Content); ok {
+			message.Content = content
+			message.ToolCalls = calls
+			finishReason = "tool_calls"
+		}
+	}
+	return message, finishReason
+}
 
+// writeChatCompletion 写入 OpenAI 格式的非流式响应。
+// citations 为搜索结果 URL，没有搜索结果时为 nil。
+func writeChatCompletion(w http.ResponseWriter, message Message, finishReason string, usage TokenCount, citations []string, rc *requestContext) {
 	// 构建 OpenAI 格式的非流式响应
 	openAIResp := OpenAIResponse{
-		ID:      "chatcmpl-" + fmt.Sprintf("%d", time.Now().Unix()),
-		Object:  "chat.completion",
-		Created: time.Now().Unix(),
-		Model:   reverseMapModelName(mapModelName(originalModel)), // 映射回 OpenAI 模型名称
+		ID:                rc.id,
+		Object:            "chat.completion",
+		Created:           rc.created,
+		Model:             reverseMapModelName(mapModelName(rc.model)), // 映射回 OpenAI 模型名称
+		SystemFingerprint: systemFingerprint(rc.model),
 		Choices: []OpenAIChoice{
 			{
-				Message: Message{
-					Role:    "assistant",
-					Content: fullResponse.String(), // 完整的响应内容
-				},
+				Message:      message,
 				Index:        0,
-				FinishReason: "stop", // 停止原因
+				FinishReason: finishReason,
 			},
 		},
+		Usage:     usage,
+		Citations: citations,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -739,78 +1016,112 @@ func handleNonStreamingResponse(w http.ResponseWriter, youReq *http.Request) {
 }
 
 // handleStreamingResponse 处理流式请求。
-func handleStreamingResponse(w http.ResponseWriter, youReq *http.Request) {
-	client := &http.Client{} // 流式请求不需要设置超时，因为它会持续接收数据
-	resp, err := client.Do(youReq)
+func handleStreamingResponse(w http.ResponseWriter, events *youclient.Stream, rc *requestContext) {
+	stream := newChatStreamWriter(w, rc)
+	stream.start()
+
+	var completion strings.Builder // 已输出的内容（含思考过程），用于估算 completion_tokens
+	var content strings.Builder    // 已输出的 content，用于定位引用标记
+	var sources []youclient.SearchResult
+	encoder := reasoningEncoder{format: rc.reasoning}
+	// closeReasoning 在正文或工具调用开始前闭合 inline 方式的 <think> 标签
+	closeReasoning := func() {
+		if tag := encoder.close(); tag != "" {
+			content.WriteString(tag)
+			stream.write(Delta{Content: tag})
+		}
+	}
+	writeReasoning := func(text string) {
+		completion.WriteString(text)
+		delta := encoder.delta(text)
+		content.WriteString(delta.Content)
+		if delta.Content != "" || delta.ReasoningContent != "" {
+			stream.write(delta)
+		}
+	}
+	writeContent := func(text string) {
+		closeReasoning()
+		completion.WriteString(text)
+		content.WriteString(text)
+		stream.write(Delta{Content: text})
+	}
+	// writeCitations 在结束前输出引用注释与 citations 扩展字段
+	writeCitations := func() {
+		if len(sources) > 0 {
+			stream.annotate(citationAnnotations(content.String(), sources), citationURLs(sources))
+		}
+	}
+
+	var filter toolStreamFilter
+	// emit 输出经过限制器的文本，启用工具时先拦截工具调用块
+	emit := func(text string) {
+		if rc.tools != nil {
+			text = filter.push(text)
+		}
+		if text != "" {
+			writeContent(text)
+		}
+	}
+
+	limiter := newOutputLimiter(rc.limits)
+	err := readYouChatStream(events, isReasoningModel(rc.model), func(thinking, token string) bool {
+		if thinking != "" {
+			writeReasoning(thinking)
+		}
+		text, done := limiter.push(token)
+		emit(text)
+		return !done // 命中停止序列或达到长度限制时关闭上游连接
+	}, func(results []youclient.SearchResult) {
+		sources = append(sources, results...)
+	})
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		if rc.canceled(stageStream) {
+			return // 客户端已断开，上游连接已随请求 context 关闭
+		}
+		// 响应头已发送，只能通过错误块通知客户端
+		fmt.Printf("读取流式响应失败: %v\n", err)
+		stream.fail("Error reading response")
 		return
 	}
-	defer resp.Body.Close()
-
-	// 设置流式响应的头部
-	w.Header().Set("Content-Type", "text/event-stream")
-	w.Header().Set("Cache-Control", "no-cache")
-	w.Header().Set("Connection", "keep-alive")
-
-	scanner := bufio.NewScanner(resp.Body)
-	// 逐行扫描响应，寻找 youChatToken 事件
-	for scanner.Scan() {
-		line := scanner.Text()
-
-		if strings.HasPrefix(line, "event: youChatToken") {
-			scanner.Scan()         // 读取下一行 (data 行)
-			data := scanner.Text() // 获取数据行
-
-			var token YouChatResponse
-			json.Unmarshal([]byte(strings.TrimPrefix(data, "data: ")), &token) // 解析 JSON
-
-			// 构建 OpenAI 格式的流式响应块
-			openAIResp := OpenAIStreamResponse{
-				ID:      "chatcmpl-" + fmt.Sprintf("%d", time.Now().Unix()),
-				Object:  "chat.completion.chunk",
-				Created: time.Now().Unix(),
-				Model:   reverseMapModelName(mapModelName(originalModel)), // 映射回 OpenAI 模型名称
-				Choices: []Choice{
-					{
-						Delta: Delta{
-							Content: token.YouChatToken, // 增量内容
-						},
-						Index:        0,
-						FinishReason: "", // 流式响应中通常为空
-					},
-				},
+	emit(limiter.flush())
+	closeReasoning()
+
+	if rc.tools != nil {
+		// 上游结束后，输出暂存的文本或工具调用片段
+		text, block := filter.flush()
+		if _, calls, ok := rc.tools.parseToolCalls(block); ok {
+			if text != "" {
+				writeContent(text)
 			}
-
-			respBytes, _ := json.Marshal(openAIResp)          // 将响应块序列化为 JSON
-			fmt.Fprintf(w, "data: %s\n\n", string(respBytes)) // 写入响应数据
-			w.(http.Flusher).Flush()                          // 立即刷新输出
+			for i, call := range calls {
+				completion.WriteString(call.Function.Name + call.Function.Arguments)
+				stream.write(Delta{ToolCalls: []ToolCallDelta{{
+					Index:    i,
+					ID:       call.ID,
+					Type:     call.Type,
+					Function: ToolCallFunction{Name: call.Function.Name},
+				}}})
+				stream.write(Delta{ToolCalls: []ToolCallDelta{{
+					Index:    i,
+					Function: ToolCallFunction{Arguments: call.Function.Arguments},
+				}}})
+			}
+			writeCitations()
+			stream.finish("tool_calls", rc.recordUsage(completion.String()))
+			return
+		}
+		if text+block != "" {
+			writeContent(text + block)
 		}
 	}
 
+	writeCitations()
+	stream.finish(limiter.FinishReason(), rc.recordUsage(completion.String()))
 }
 
 // 获取上传文件所需的 nonce
-func getNonce(dsToken string) (*NonceResponse, error) {
-	req, _ := http.NewRequest("GET", "https://you.com/api/get_nonce", nil)
-	req.Header.Set("Cookie", fmt.Sprintf("DS=%s", dsToken))
-
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	// 读取完整的响应内容
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("读取响应失败: %v", err)
-	}
-
-	// 直接使用响应内容作为 UUID
-	return &NonceResponse{
-		Uuid: strings.TrimSpace(string(body)),
-	}, nil
+func getNonce(ctx context.Context, dsToken string) (*NonceResponse, error) {
+	return newYouClient(dsToken).GetNonce(ctx)
 }
 
 // 生成短文件名
@@ -825,57 +1136,28 @@ func generateShortFileName() string {
 }
 
 // 上传文件
-func uploadFile(dsToken, filePath string) (*UploadResponse, error) {
-	file, err := os.Open(filePath)
-	if err != nil {
-		return nil, err
-	}
-	defer file.Close()
-
-	body := &bytes.Buffer{}
-	writer := multipart.NewWriter(body)
-	part, err := writer.CreateFormFile("file", filepath.Base(filePath))
-	if err != nil {
-		return nil, err
-	}
-
-	if _, err := io.Copy(part, file); err != nil {
-		return nil, err
-	}
-	writer.Close()
-
-	req, _ := http.NewRequest("POST", "https://you.com/api/upload", body)
-	req.Header.Set("Content-Type", writer.FormDataContentType())
-	req.Header.Set("Cookie", fmt.Sprintf("DS=%s", dsToken))
-
-	resp, err := http.DefaultClient.Do(req)
+func uploadFile(ctx context.Context, dsToken, filePath string) (*UploadResponse, error) {
+	data, err := os.ReadFile(filePath)
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
-
-	// 打印上传响应状态码
-	fmt.Printf("文件上传响应状态码: %d\n", resp.StatusCode)
-
-	// 如果上传失败，记录错误响应
-	if resp.StatusCode != http.StatusOK {
-		respBody, _ := io.ReadAll(resp.Body)
-		fmt.Printf("文件上传错误响应内容: %s\n", string(respBody))
-		return nil, fmt.Errorf("上传文件失败，状态码: %d", resp.StatusCode)
-	}
+	return uploadFileData(ctx, dsToken, filepath.Base(filePath), "application/octet-stream", data)
+}
 
-	// 先读取完整的响应体
-	respBody, err := io.ReadAll(resp.Body)
+// 以指定的文件名和 MIME 类型上传内存中的文件内容
+func uploadFileData(ctx context.Context, dsToken, filename, contentType string, data []byte) (*UploadResponse, error) {
+	uploadResp, err := newYouClient(dsToken).Upload(ctx, &youclient.UploadRequest{
+		Filename:    filename,
+		ContentType: contentType,
+		Data:        data,
+	})
 	if err != nil {
-		return nil, err
-	}
-
-	// 打印完整的响应内容
-	fmt.Printf("文件上传响应内容: %s\n", string(respBody))
-
-	// 解析响应
-	var uploadResp UploadResponse
-	if err := json.Unmarshal(respBody, &uploadResp); err != nil {
+		var statusErr *youclient.StatusError
+		if errors.As(err, &statusErr) {
+			// 如果上传失败，记录错误响应
+			fmt.Printf("文件上传错误响应内容: %s\n", statusErr.Body)
+			return nil, fmt.Errorf("上传文件失败，状态码: %d", statusErr.StatusCode)
+		}
 		return nil, err
 	}
 
@@ -883,35 +1165,37 @@ func uploadFile(dsToken, filePath string) (*UploadResponse, error) {
 	fmt.Printf("上传文件成功: filename=%s, user_filename=%s\n",
 		uploadResp.Filename, uploadResp.UserFilename)
 
-	return &uploadResp, nil
+	return uploadResp, nil
 }
 
 // 计算消息的 token 数（使用字符估算方法）
 func countTokens(messages []Message) (int, error) {
 	totalTokens := 0
 	for _, msg := range messages {
-		content := msg.Content
-		englishCount := 0
-		chineseCount := 0
-
-		// 遍历每个字符
-		for _, r := range content {
-			if r <= 127 { // ASCII 字符（英文和符号）
-				englishCount++
-			} else { // 非 ASCII 字符（中文等）
-				chineseCount++
-			}
-		}
-
-		// 计算 tokens：英文字符 * 0.3 + 中文字符 * 0.6
-		tokens := int(float64(englishCount)*0.3 + float64(chineseCount)*1)
-
 		// 加上角色名的 token（约 2 个）
-		totalTokens += tokens + 2
+		totalTokens += estimateTextTokens(msg.Content) + 2
 	}
 	return totalTokens, nil
 }
 
+// 估算一段文本的 token 数
+func estimateTextTokens(content string) int {
+	englishCount := 0
+	chineseCount := 0
+
+	// 遍历每个字符
+	for _, r := range content {
+		if r <= 127 { // ASCII 字符（英文和符号）
+			englishCount++
+		} else { // 非 ASCII 字符（中文等）
+			chineseCount++
+		}
+	}
+
+	// 计算 tokens：英文字符 * 0.3 + 中文字符 * 1
+	return int(float64(englishCount)*0.3 + float64(chineseCount)*1)
+}
+
 // 将 system 消息转换为第一条 user 消息
 func convertSystemToUser(messages []Message) []Message {
 	if len(messages) == 0 {
@@ -0,0 +1,229 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newFakeYou 启动替身 You.com 服务器并让 youBaseURL 指向它，测试结束后恢复。
+// get_nonce 与 upload 由替身直接应答，streamingSearch 交给 search 处理。
+func newFakeYou(t *testing.T, search http.HandlerFunc) *httptest.Server {
+	t.Helper()
+	return newFakeYouWithUpload(t, search, func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `{"filename": "file.txt", "user_filename": "file.txt"}`)
+	})
+}
+
+// newFakeYouWithUpload 与 newFakeYou 相同，但 upload 交给 upload 处理。
+func newFakeYouWithUpload(t *testing.T, search, upload http.HandlerFunc) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/get_nonce":
+			io.WriteString(w, "nonce")
+		case "/api/upload":
+			upload(w, r)
+		case "/api/streamingSearch":
+			search(w, r)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	previous := youBaseURL
+	youBaseURL = server.URL
+	t.Cleanup(func() {
+		youBaseURL = previous
+		server.Close()
+	})
+	return server
+}
+
+// youTokens 将 token 编码为 streamingSearch 响应。
+func youTokens(tokens ...string) string {
+	var b strings.Builder
+	for _, token := range tokens {
+		data, _ := json.Marshal(map[string]string{"youChatToken": token})
+		b.WriteString("event: youChatToken\ndata: " + string(data) + "\n\n")
+	}
+	return b.String()
+}
+
+// cutYouStream 输出 tokens 后中断连接，模拟上游在流中途断开。
+func cutYouStream(w http.ResponseWriter, tokens ...string) {
+	io.WriteString(w, youTokens(tokens...))
+	w.(http.Flusher).Flush()
+	panic(http.ErrAbortHandler)
+}
+
+func chatRequest(body string) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer test-token")
+	return req
+}
+
+func TestHandlerSingleUpstreamRequest(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		upstream   int
+		tokens     []string
+		wantStatus int
+		wantText   string
+	}{
+		{"non-streaming", `{"model": "gpt-4o", "messages": [{"role": "user", "content": "hi"}]}`, http.StatusOK, []string{"Hello", " world"}, http.StatusOK, `"content":"Hello world"`},
+		{"streaming", `{"model": "gpt-4o", "stream": true, "messages": [{"role": "user", "content": "hi"}]}`, http.StatusOK, []string{"Hello", " world"}, http.StatusOK, "data: [DONE]"},
+		{"structured", `{"model": "gpt-4o", "response_format": {"type": "json_object"}, "messages": [{"role": "user", "content": "hi"}]}`, http.StatusOK, []string{`{"a":1}`}, http.StatusOK, `{\"a\":1}`},
+		{"upstream error", `{"model": "gpt-4o", "messages": [{"role": "user", "content": "hi"}]}`, http.StatusTooManyRequests, nil, http.StatusTooManyRequests, "API returned status 429"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests int32
+			newFakeYou(t, func(w http.ResponseWriter, r *http.Request) {
+				atomic.AddInt32(&requests, 1)
+				if tt.upstream != http.StatusOK {
+					w.WriteHeader(tt.upstream)
+					return
+				}
+				io.WriteString(w, youTokens(tt.tokens...))
+			})
+
+			rec := httptest.NewRecorder()
+			Handler(rec, chatRequest(tt.body))
+
+			if got := atomic.LoadInt32(&requests); got != 1 {
+				t.Errorf("upstream requests = %d, want 1", got)
+			}
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if !strings.Contains(rec.Body.String(), tt.wantText) {
+				t.Errorf("body = %s, want it to contain %s", rec.Body.String(), tt.wantText)
+			}
+		})
+	}
+}
+
+// TestHandlerConcurrentRequests 并发请求不同模型，检查响应中的模型与 ID 不会串到其他请求。
+// 使用 go test -race 运行以检测共享状态。
+func TestHandlerConcurrentRequests(t *testing.T) {
+	newFakeYou(t, func(w http.ResponseWriter, r *http.Request) {
+		// 回显上游收到的模型，用于确认请求没有串线
+		io.WriteString(w, youTokens("model=", r.URL.Query().Get("selectedAiModel")))
+	})
+
+	models := []string{"gpt-4o", "gpt-4o-mini", "gpt-4-turbo", "gpt-4"}
+	const requests = 40
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	ids := make(map[string]bool)
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			model := models[i%len(models)]
+			stream := i%2 == 1
+			body, _ := json.Marshal(map[string]interface{}{
+				"model":    model,
+				"stream":   stream,
+				"messages": []map[string]string{{"role": "user", "content": "hi"}},
+			})
+			rec := httptest.NewRecorder()
+			Handler(rec, chatRequest(string(body)))
+			if rec.Code != http.StatusOK {
+				t.Errorf("%s: status = %d, body = %s", model, rec.Code, rec.Body.String())
+				return
+			}
+
+			var id string
+			var content strings.Builder
+			if stream {
+				for _, event := range strings.Split(strings.TrimSpace(rec.Body.String()), "\n\n") {
+					if event == "data: [DONE]" {
+						continue
+					}
+					var chunk OpenAIStreamResponse
+					if err := json.Unmarshal([]byte(strings.TrimPrefix(event, "data: ")), &chunk); err != nil {
+						t.Errorf("%s: invalid chunk %q: %v", model, event, err)
+						return
+					}
+					if chunk.Model != model {
+						t.Errorf("chunk model = %q, want %q", chunk.Model, model)
+					}
+					if id != "" && chunk.ID != id {
+						t.Errorf("%s: chunk id = %q, want %q", model, chunk.ID, id)
+					}
+					id = chunk.ID
+					for _, choice := range chunk.Choices {
+						content.WriteString(choice.Delta.Content)
+					}
+				}
+			} else {
+				var resp OpenAIResponse
+				if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+					t.Errorf("%s: invalid response: %v", model, err)
+					return
+				}
+				if resp.Model != model {
+					t.Errorf("response model = %q, want %q", resp.Model, model)
+				}
+				id = resp.ID
+				content.WriteString(resp.Choices[0].Message.Content)
+			}
+
+			if want := "model=" + mapModelName(model); content.String() != want {
+				t.Errorf("content = %q, want %q", content.String(), want)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if ids[id] {
+				t.Errorf("duplicate response id %q", id)
+			}
+			ids[id] = true
+		}(i)
+	}
+	wg.Wait()
+}
+
+// TestHandlerClientDisconnect 客户端断开后应立即关闭上游连接，且不再输出结束块。
+func TestHandlerClientDisconnect(t *testing.T) {
+	started := make(chan struct{})
+	upstreamClosed := make(chan struct{})
+	newFakeYou(t, func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, youTokens("Hello"))
+		w.(http.Flusher).Flush()
+		close(started)
+		<-r.Context().Done() // 一直等到代理关闭上游连接
+		close(upstreamClosed)
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req := chatRequest(`{"model": "gpt-4o", "stream": true, "messages": [{"role": "user", "content": "hi"}]}`).WithContext(ctx)
+	rec := httptest.NewRecorder()
+	handled := make(chan struct{})
+	go func() {
+		Handler(rec, req)
+		close(handled)
+	}()
+
+	<-started
+	cancel()
+	for name, ch := range map[string]chan struct{}{"upstream connection": upstreamClosed, "Handler": handled} {
+		select {
+		case <-ch:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s still open after client disconnect", name)
+		}
+	}
+	if strings.Contains(rec.Body.String(), "[DONE]") {
+		t.Errorf("body = %s, want no [DONE] after disconnect", rec.Body.String())
+	}
+}
@@ -0,0 +1,280 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ollamaDSToken 是 Ollama 接口在客户端未提供 Authorization 时使用的 DS token，
+// 因为多数 Ollama 客户端无法设置请求头。它让未携带凭据的请求直接使用该 token，
+// 因此只在未配置账号池与 API key 的直连模式下生效（此时服务本就接受任意 DS token），
+// 配置了账号池或 API key 时 initAccounts 会忽略它，避免绕过 key 的模型允许列表与限额。
+var ollamaDSToken = os.Getenv("OLLAMA_DS_TOKEN")
+
+// OllamaChatRequest 定义了 /api/chat 请求体的结构。
+type OllamaChatRequest struct {
+	Model    string    `json:"model"`
+	Messages []Message `json:"messages"`
+	Stream   *bool     `json:"stream"` // Ollama 默认流式输出
+}
+
+// OllamaGenerateRequest 定义了 /api/generate 请求体的结构。
+type OllamaGenerateRequest struct {
+	Model  string `json:"model"`
+	Prompt string `json:"prompt"`
+	System string `json:"system"`
+	Stream *bool  `json:"stream"`
+}
+
+// OllamaResponse 定义了 /api/chat 与 /api/generate 的 NDJSON 响应行。
+// /api/chat 使用 Message 字段，/api/generate 使用 Response 字段。
+type OllamaResponse struct {
+	Model           string   `json:"model"`
+	CreatedAt       string   `json:"created_at"`
+	Message         *Message `json:"message,omitempty"`
+	Response        *string  `json:"response,omitempty"`
+	Done            bool     `json:"done"`
+	DoneReason      string   `json:"done_reason,omitempty"`
+	TotalDuration   int64    `json:"total_duration,omitempty"`
+	PromptEvalCount int      `json:"prompt_eval_count,omitempty"`
+	EvalCount       int      `json:"eval_count,omitempty"`
+}
+
+// OllamaModel 定义了 /api/tags 中单个模型的结构。
+type OllamaModel struct {
+	Name       string             `json:"name"`
+	Model      string             `json:"model"`
+	ModifiedAt string             `json:"modified_at"`
+	Size       int64              `json:"size"`
+	Digest     string             `json:"digest"`
+	Details    OllamaModelDetails `json:"details"`
+}
+
+// OllamaModelDetails 定义了 Ollama 模型的详细信息。
+type OllamaModelDetails struct {
+	Format string `json:"format"`
+	Family string `json:"family"`
+}
+
+// handleOllama 处理 Ollama 兼容接口（/api/tags、/api/chat、/api/generate、/api/version）。
+func handleOllama(w http.ResponseWriter, r *http.Request) {
+	// 设置 CORS 头部
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "*")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	switch r.URL.Path {
+	case "/api/tags":
+		handleOllamaTags(w, r)
+	case "/api/version":
+		writeOllamaJSON(w, http.StatusOK, map[string]string{"version": "0.5.0"})
+	case "/api/chat":
+		var chatReq OllamaChatRequest
+		if err := json.NewDecoder(r.Body).Decode(&chatReq); err != nil {
+			writeOllamaError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+		serveOllama(w, r, chatReq.Model, chatReq.Messages, chatReq.Stream, true)
+	case "/api/generate":
+		var generateReq OllamaGenerateRequest
+		if err := json.NewDecoder(r.Body).Decode(&generateReq); err != nil {
+			writeOllamaError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+		var messages []Message
+		if generateReq.System != "" {
+			messages = append(messages, Message{Role: "system", Content: generateReq.System})
+		}
+		messages = append(messages, Message{Role: "user", Content: generateReq.Prompt})
+		serveOllama(w, r, generateReq.Model, messages, generateReq.Stream, false)
+	default:
+		writeOllamaError(w, http.StatusNotFound, "404 page not found")
+	}
+}
+
+// handleOllamaTags 以 Ollama 格式列出 modelMap 与 agent 模型，使用本地 API key 时只列出 key 允许的模型。
+func handleOllamaTags(w http.ResponseWriter, r *http.Request) {
+	names := make([]string, 0, len(modelMap)+len(agentModelIDs))
+	for modelID := range modelMap {
+		names = append(names, modelID)
+	}
+	names = append(names, agentModelIDs...)
+	if key := requestKey(r); key != nil {
+		allowed := names[:0]
+		for _, name := range names {
+			if key.AllowsModel(modelNames(name)...) {
+				allowed = append(allowed, name)
+			}
+		}
+		names = allowed
+	}
+	sort.Strings(names)
+
+	modifiedAt := time.Now().UTC().Format(time.RFC3339Nano)
+	models := make([]OllamaModel, 0, len(names))
+	for _, name := range names {
+		family := "you.com"
+		if isAgentModel(name) {
+			family = "agent"
+		}
+		models = append(models, OllamaModel{
+			Name:       name,
+			Model:      name,
+			ModifiedAt: modifiedAt,
+			Digest:     name,
+			Details:    OllamaModelDetails{Format: "api", Family: family},
+		})
+	}
+
+	writeOllamaJSON(w, http.StatusOK, map[string]interface{}{"models": models})
+}
+
+// serveOllama 发送 You.com 请求并以 Ollama NDJSON 格式返回结果。
+// chat 为 true 时输出 /api/chat 格式，否则输出 /api/generate 格式。
+func serveOllama(w http.ResponseWriter, r *http.Request, model string, messages []Message, stream *bool, chat bool) {
+	// Ollama 客户端常带 :latest 标签
+	model = strings.TrimSuffix(model, ":latest")
+	start := time.Now()
+
+	// 直连模式下客户端未提供凭据时使用 OLLAMA_DS_TOKEN
+	var account *upstreamAccount
+	if credential, ok := extractDSToken(r); !ok && ollamaDSToken != "" && accountPool == nil && keyStore == nil {
+		account = &upstreamAccount{token: ollamaDSToken, owner: ollamaDSToken}
+	} else {
+		var err error
+		account, err = acquireAccount(w, accountRequest{credential: credential, model: model, stream: stream == nil || *stream})
+		if err != nil {
+			writeOllamaError(w, accountErrorStatus(w, err), err.Error())
+			return
+		}
+	}
+	defer account.release()
+	dsToken := account.token
+
+	// Ollama 请求体没有扩展字段，只支持 X-You-* 头部
+	youOptions, err := resolveYouOptions(r, nil)
+	if err != nil {
+		writeOllamaError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	youReq, err := buildYouRequest(r.Context(), dsToken, model, messages, youOptions)
+	if err != nil {
+		if clientCanceled(r.Context(), "ollama", stagePrepare) {
+			return
+		}
+		writeOllamaError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	events, err := openYouStream(account, newUpstreamClient(upstreamTimeoutFor(stream == nil || *stream)), youReq)
+	if err != nil {
+		if clientCanceled(r.Context(), "ollama", stageRequest) {
+			return
+		}
+		writeOllamaError(w, upstreamStatus(err, http.StatusInternalServerError), err.Error())
+		return
+	}
+	defer events.Close()
+
+	// newLine 根据接口类型构建一行响应
+	newLine := func(content string) OllamaResponse {
+		line := OllamaResponse{
+			Model:     model,
+			CreatedAt: time.Now().UTC().Format(time.RFC3339Nano),
+		}
+		if chat {
+			line.Message = &Message{Role: "assistant", Content: content}
+		} else {
+			line.Response = &content
+		}
+		return line
+	}
+
+	promptTokens, _ := countTokens(messages)
+	var fullResponse strings.Builder
+
+	if stream != nil && !*stream {
+		err := readYouChatTokens(events, func(token string) bool {
+			fullResponse.WriteString(token)
+			return true
+		})
+		if err != nil {
+			if clientCanceled(r.Context(), "ollama", stageStream) {
+				return
+			}
+			writeOllamaError(w, http.StatusInternalServerError, "Error reading response")
+			return
+		}
+
+		final := newLine(fullResponse.String())
+		final.Done = true
+		final.DoneReason = "stop"
+		final.TotalDuration = time.Since(start).Nanoseconds()
+		final.PromptEvalCount = promptTokens
+		final.EvalCount, _ = countTokens([]Message{{Role: "assistant", Content: fullResponse.String()}})
+		recordUsage(account, model, final.PromptEvalCount, final.EvalCount)
+		writeOllamaJSON(w, http.StatusOK, final)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	encoder := json.NewEncoder(w)
+	flusher, _ := w.(http.Flusher)
+
+	err = readYouChatTokens(events, func(token string) bool {
+		fullResponse.WriteString(token)
+		encoder.Encode(newLine(token))
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return true
+	})
+	if err != nil {
+		if clientCanceled(r.Context(), "ollama", stageStream) {
+			return
+		}
+		// 与 Ollama 一致，流中途出错时输出 {"error": "..."} 行，不输出 done 行
+		fmt.Printf("读取流式响应失败: %v\n", err)
+		encoder.Encode(map[string]string{"error": "Error reading response"})
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return
+	}
+
+	final := newLine("")
+	final.Done = true
+	final.DoneReason = "stop"
+	final.TotalDuration = time.Since(start).Nanoseconds()
+	final.PromptEvalCount = promptTokens
+	final.EvalCount, _ = countTokens([]Message{{Role: "assistant", Content: fullResponse.String()}})
+	recordUsage(account, model, final.PromptEvalCount, final.EvalCount)
+	encoder.Encode(final)
+	if flusher != nil {
+		flusher.Flush()
+	}
+}
+
+// writeOllamaJSON 写入 JSON 响应。
+func writeOllamaJSON(w http.ResponseWriter, status int, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(data)
+}
+
+// writeOllamaError 以 Ollama 错误格式（{"error": "..."}）返回错误。
+func writeOllamaError(w http.ResponseWriter, status int, message string) {
+	fmt.Printf("Ollama 请求失败: %s\n", message)
+	writeOllamaJSON(w, status, map[string]string{"error": message})
+}
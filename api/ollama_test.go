@@ -0,0 +1,119 @@
+package handler
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// ollamaRequest 发送 Ollama 接口请求。
+func ollamaRequest(path, body string) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer test-token")
+	return req
+}
+
+func TestOllama(t *testing.T) {
+	var query, model string
+	newFakeYou(t, func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query().Get("q")
+		model = r.URL.Query().Get("selectedAiModel")
+		io.WriteString(w, youTokens("Hello", " world"))
+	})
+
+	tests := []struct {
+		name string
+		path string
+		body string
+		chat bool
+	}{
+		{"chat", "/api/chat", `{"model": "gpt-4o:latest", "stream": false, "messages": [{"role": "user", "content": "hi"}]}`, true},
+		{"generate", "/api/generate", `{"model": "gpt-4o:latest", "stream": false, "system": "be brief", "prompt": "hi"}`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			Handler(rec, ollamaRequest(tt.path, tt.body))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+			}
+			if query != "hi" || model != mapModelName("gpt-4o") {
+				t.Errorf("upstream query = %q, model = %q", query, model)
+			}
+
+			var response OllamaResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+				t.Fatalf("invalid response: %v", err)
+			}
+			if response.Model != "gpt-4o" || !response.Done || response.DoneReason != "stop" {
+				t.Errorf("response = %+v", response)
+			}
+			if tt.chat {
+				if response.Message == nil || response.Message.Role != "assistant" || response.Message.Content != "Hello world" || response.Response != nil {
+					t.Errorf("chat response = %+v, want the answer in message", response)
+				}
+			} else if response.Response == nil || *response.Response != "Hello world" || response.Message != nil {
+				t.Errorf("generate response = %+v, want the answer in response", response)
+			}
+			if response.PromptEvalCount == 0 || response.EvalCount == 0 {
+				t.Errorf("eval counts = %d, %d, want non-zero", response.PromptEvalCount, response.EvalCount)
+			}
+		})
+	}
+}
+
+func TestOllamaStream(t *testing.T) {
+	newFakeYou(t, func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, youTokens("Hello", " world"))
+	})
+
+	// 未设置 stream 时 Ollama 默认流式输出
+	for _, path := range []string{"/api/chat", "/api/generate"} {
+		t.Run(path, func(t *testing.T) {
+			body := `{"model": "gpt-4o", "prompt": "hi"}`
+			if path == "/api/chat" {
+				body = `{"model": "gpt-4o", "messages": [{"role": "user", "content": "hi"}]}`
+			}
+			rec := httptest.NewRecorder()
+			Handler(rec, ollamaRequest(path, body))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+			}
+			if contentType := rec.Header().Get("Content-Type"); contentType != "application/x-ndjson" {
+				t.Errorf("Content-Type = %q", contentType)
+			}
+
+			lines := strings.Split(strings.TrimSpace(rec.Body.String()), "\n")
+			if len(lines) != 3 {
+				t.Fatalf("got %d lines, want two tokens and a final line: %s", len(lines), rec.Body.String())
+			}
+			var text string
+			for i, line := range lines {
+				var response OllamaResponse
+				if err := json.Unmarshal([]byte(line), &response); err != nil {
+					t.Fatalf("invalid line %q: %v", line, err)
+				}
+				if final := i == len(lines)-1; response.Done != final || (response.DoneReason == "stop") != final {
+					t.Errorf("line %d: done = %v, done_reason = %q", i, response.Done, response.DoneReason)
+				}
+				if path == "/api/chat" {
+					if response.Message == nil || response.Response != nil {
+						t.Fatalf("line %d = %s, want a chat message", i, line)
+					}
+					text += response.Message.Content
+				} else {
+					if response.Response == nil || response.Message != nil {
+						t.Fatalf("line %d = %s, want a generate response", i, line)
+					}
+					text += *response.Response
+				}
+			}
+			if text != "Hello world" {
+				t.Errorf("text = %q, want Hello world", text)
+			}
+		})
+	}
+}
@@ -0,0 +1,215 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// YouOptions 定义了可按请求覆盖的 You.com 查询选项。
+// 请求体中通过 "you" 对象传递（OpenAI SDK 的 extra_body），也可以使用 X-You-* 头部。
+type YouOptions struct {
+	Market     string `json:"mkt,omitempty"`
+	SafeSearch string `json:"safe_search,omitempty"` // Off、Moderate 或 Strict
+	Count      int    `json:"count,omitempty"`       // 搜索结果数量
+	WebAccess  *bool  `json:"web_access,omitempty"`  // 是否允许模型联网搜索，未设置时不发送
+	ChatMode   string `json:"chat_mode,omitempty"`   // selectedChatMode，默认 custom
+}
+
+// youWebAccessParam 是控制联网搜索的 streamingSearch 查询参数。You.com 没有公开
+// streamingSearch 的参数文档，该参数名未经官方确认，因此只在客户端或 YOU_WEB_ACCESS
+// 明确设置 web_access 时发送，默认请求与之前保持一致。
+const youWebAccessParam = "use_web_access"
+
+// YouOptions 中各选项的名称，用于允许覆盖列表与 X-You-* 头部。
+const (
+	youOptionMarket     = "mkt"
+	youOptionSafeSearch = "safe_search"
+	youOptionCount      = "count"
+	youOptionWebAccess  = "web_access"
+	youOptionChatMode   = "chat_mode"
+)
+
+// youOptionHeaders 将 X-You-* 头部映射到选项名称。
+var youOptionHeaders = map[string]string{
+	"X-You-Market":      youOptionMarket,
+	"X-You-Mkt":         youOptionMarket,
+	"X-You-Safe-Search": youOptionSafeSearch,
+	"X-You-Count":       youOptionCount,
+	"X-You-Web-Access":  youOptionWebAccess,
+	"X-You-Chat-Mode":   youOptionChatMode,
+}
+
+// defaultYouOptions 是服务端默认选项，可通过 YOU_MARKET、YOU_SAFE_SEARCH、YOU_COUNT、
+// YOU_WEB_ACCESS 与 YOU_CHAT_MODE 配置。
+var defaultYouOptions = YouOptions{
+	Market:     "zh-HK",
+	SafeSearch: "Moderate",
+	Count:      10,
+	ChatMode:   "custom",
+}
+
+// overridableYouOptions 列出允许客户端覆盖的选项，可通过 YOU_OVERRIDABLE_OPTIONS 配置
+// （逗号分隔，留空表示不允许覆盖任何选项）。chat_mode 默认不允许覆盖：设置为 agent ID
+// 或其他模式会改变实际使用的模型，从而绕过 API key 的模型允许列表。
+var overridableYouOptions = map[string]bool{
+	youOptionMarket:     true,
+	youOptionSafeSearch: true,
+	youOptionCount:      true,
+	youOptionWebAccess:  true,
+}
+
+func init() {
+	var env YouOptions
+	for name, envName := range map[string]string{
+		youOptionMarket:     "YOU_MARKET",
+		youOptionSafeSearch: "YOU_SAFE_SEARCH",
+		youOptionCount:      "YOU_COUNT",
+		youOptionWebAccess:  "YOU_WEB_ACCESS",
+		youOptionChatMode:   "YOU_CHAT_MODE",
+	} {
+		if value := os.Getenv(envName); value != "" {
+			if err := env.set(name, value); err != nil {
+				fmt.Printf("忽略无效的 %s: %v\n", envName, err)
+			}
+		}
+	}
+	if err := env.normalize(); err != nil {
+		fmt.Printf("忽略无效的 You.com 默认选项: %v\n", err)
+	} else {
+		defaultYouOptions = defaultYouOptions.merge(env)
+	}
+
+	if value, ok := os.LookupEnv("YOU_OVERRIDABLE_OPTIONS"); ok {
+		overridableYouOptions = map[string]bool{}
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				overridableYouOptions[name] = true
+			}
+		}
+	}
+}
+
+// set 按名称设置一个字符串形式的选项值。
+func (o *YouOptions) set(name, value string) error {
+	value = strings.TrimSpace(value)
+	switch name {
+	case youOptionMarket:
+		o.Market = value
+	case youOptionSafeSearch:
+		o.SafeSearch = value
+	case youOptionCount:
+		count, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("%s must be an integer", name)
+		}
+		o.Count = count
+	case youOptionWebAccess:
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("%s must be true or false", name)
+		}
+		o.WebAccess = &enabled
+	case youOptionChatMode:
+		o.ChatMode = value
+	}
+	return nil
+}
+
+// names 返回已设置的选项名称。
+func (o YouOptions) names() []string {
+	var names []string
+	if o.Market != "" {
+		names = append(names, youOptionMarket)
+	}
+	if o.SafeSearch != "" {
+		names = append(names, youOptionSafeSearch)
+	}
+	if o.Count != 0 {
+		names = append(names, youOptionCount)
+	}
+	if o.WebAccess != nil {
+		names = append(names, youOptionWebAccess)
+	}
+	if o.ChatMode != "" {
+		names = append(names, youOptionChatMode)
+	}
+	return names
+}
+
+// merge 返回用 override 中已设置的选项覆盖后的结果。
+func (o YouOptions) merge(override YouOptions) YouOptions {
+	if override.Market != "" {
+		o.Market = override.Market
+	}
+	if override.SafeSearch != "" {
+		o.SafeSearch = override.SafeSearch
+	}
+	if override.Count != 0 {
+		o.Count = override.Count
+	}
+	if override.WebAccess != nil {
+		o.WebAccess = override.WebAccess
+	}
+	if override.ChatMode != "" {
+		o.ChatMode = override.ChatMode
+	}
+	return o
+}
+
+// normalize 校验已设置的选项并规范 safe_search 的大小写。
+func (o *YouOptions) normalize() error {
+	if o.SafeSearch != "" {
+		safeSearch, ok := normalizeSafeSearch(o.SafeSearch)
+		if !ok {
+			return errors.New("safe_search must be one of Off, Moderate or Strict")
+		}
+		o.SafeSearch = safeSearch
+	}
+	if o.Count != 0 && (o.Count < 1 || o.Count > maxSearchCount) {
+		return fmt.Errorf("count must be between 1 and %d", maxSearchCount)
+	}
+	return nil
+}
+
+// normalizeSafeSearch 将不区分大小写的 safeSearch 取值规范为 You.com 使用的形式。
+func normalizeSafeSearch(value string) (string, bool) {
+	switch strings.ToLower(value) {
+	case "off":
+		return "Off", true
+	case "moderate":
+		return "Moderate", true
+	case "strict":
+		return "Strict", true
+	}
+	return "", false
+}
+
+// resolveYouOptions 合并服务端默认值、X-You-* 头部与请求体中的 you 对象（优先级依次升高），
+// 覆盖不在允许列表中的选项时返回错误。
+func resolveYouOptions(r *http.Request, body *YouOptions) (YouOptions, error) {
+	var requested YouOptions
+	for header, name := range youOptionHeaders {
+		if value := r.Header.Get(header); value != "" {
+			if err := requested.set(name, value); err != nil {
+				return YouOptions{}, err
+			}
+		}
+	}
+	if body != nil {
+		requested = requested.merge(*body)
+	}
+
+	for _, name := range requested.names() {
+		if !overridableYouOptions[name] {
+			return YouOptions{}, fmt.Errorf("you.%s cannot be overridden on this server", name)
+		}
+	}
+	if err := requested.normalize(); err != nil {
+		return YouOptions{}, fmt.Errorf("you.%v", err)
+	}
+	return defaultYouOptions.merge(requested), nil
+}
@@ -0,0 +1,66 @@
+package handler
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestResolveYouOptions(t *testing.T) {
+	r := httptest.NewRequest("POST", "/v1/chat/completions", nil)
+	r.Header.Set("X-You-Market", "en-US")
+	r.Header.Set("X-You-Safe-Search", "strict")
+	r.Header.Set("X-You-Web-Access", "false")
+
+	got, err := resolveYouOptions(r, &YouOptions{Market: "ja-JP", Count: 5})
+	if err != nil {
+		t.Fatalf("resolveYouOptions() error = %v", err)
+	}
+	if got.Market != "ja-JP" || got.SafeSearch != "Strict" || got.Count != 5 || got.ChatMode != defaultYouOptions.ChatMode {
+		t.Errorf("resolveYouOptions() = %+v", got)
+	}
+	if got.WebAccess == nil || *got.WebAccess {
+		t.Errorf("web_access = %v, want false", got.WebAccess)
+	}
+
+	overridable := overridableYouOptions
+	defer func() { overridableYouOptions = overridable }()
+	overridableYouOptions = map[string]bool{youOptionMarket: true}
+	if _, err := resolveYouOptions(r, nil); err == nil {
+		t.Errorf("resolveYouOptions() should reject options outside the allowlist")
+	}
+}
+
+func TestResolveYouOptionsChatModeNotOverridable(t *testing.T) {
+	r := httptest.NewRequest("POST", "/v1/chat/completions", nil)
+	if _, err := resolveYouOptions(r, &YouOptions{ChatMode: "research"}); err == nil {
+		t.Error("resolveYouOptions() accepted you.chat_mode by default")
+	}
+	r.Header.Set("X-You-Chat-Mode", "my-agent")
+	if _, err := resolveYouOptions(r, nil); err == nil {
+		t.Error("resolveYouOptions() accepted X-You-Chat-Mode by default")
+	}
+}
+
+func TestWebAccessOnlySentWhenSet(t *testing.T) {
+	var values []string
+	var present []bool
+	newFakeYou(t, func(w http.ResponseWriter, r *http.Request) {
+		value, ok := r.URL.Query()[youWebAccessParam]
+		present = append(present, ok)
+		values = append(values, strings.Join(value, ","))
+		io.WriteString(w, youTokens("ok"))
+	})
+
+	body := `{"model": "gpt-4o", "messages": [{"role": "user", "content": "hi"}]}`
+	Handler(httptest.NewRecorder(), chatRequest(body))
+	req := chatRequest(body)
+	req.Header.Set("X-You-Web-Access", "false")
+	Handler(httptest.NewRecorder(), req)
+
+	if len(present) != 2 || present[0] || !present[1] || values[1] != "false" {
+		t.Errorf("%s present = %v, values = %q, want it only on the request that set web_access", youWebAccessParam, present, values)
+	}
+}
@@ -0,0 +1,55 @@
+package handler
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"you2api/ratelimit"
+)
+
+// keyLimiter 与 accountLimiter 分别按本地 API key 与账号池账号记录用量，
+// 限额来自 API key 与账号池的配置（limits、default_limits）。
+var (
+	keyLimiter     = ratelimit.New("key")
+	accountLimiter = ratelimit.New("account")
+)
+
+// retryAfterSeconds 将时长转换为 Retry-After 头部使用的整数秒，至少为 1。
+func retryAfterSeconds(d time.Duration) string {
+	return strconv.Itoa(max(int(math.Ceil(d.Seconds())), 1))
+}
+
+// writeRateLimitHeaders 按 OpenAI 的格式写入 x-ratelimit-* 头部，只写入设置了限额的部分。
+func writeRateLimitHeaders(h http.Header, status ratelimit.Status) {
+	if limit := status.Limits.RequestsPerMinute; limit > 0 {
+		h.Set("x-ratelimit-limit-requests", strconv.Itoa(limit))
+		h.Set("x-ratelimit-remaining-requests", strconv.Itoa(status.RemainingRequests))
+		h.Set("x-ratelimit-reset-requests", status.ResetRequests.Round(time.Millisecond).String())
+	}
+	if limit := status.Limits.TokensPerDay; limit > 0 {
+		h.Set("x-ratelimit-limit-tokens", strconv.Itoa(limit))
+		h.Set("x-ratelimit-remaining-tokens", strconv.Itoa(status.RemainingTokens))
+		h.Set("x-ratelimit-reset-tokens", status.ResetTokens.Round(time.Second).String())
+	}
+}
+
+// writeRateLimitHeaders 写入本次请求的剩余额度，同时设置了两种限额时以 API key 的为准。
+func (a *upstreamAccount) writeRateLimitHeaders(w http.ResponseWriter) {
+	switch {
+	case a.keyPermit != nil:
+		writeRateLimitHeaders(w.Header(), a.keyPermit.Status())
+	case a.accountPermit != nil:
+		writeRateLimitHeaders(w.Header(), a.accountPermit.Status())
+	}
+}
+
+// addUsage 将本次请求的估算 token 数计入 API key 与账号的每日用量。
+func (a *upstreamAccount) addUsage(tokens int) {
+	for _, permit := range []*ratelimit.Permit{a.keyPermit, a.accountPermit} {
+		if permit != nil {
+			permit.AddTokens(tokens)
+		}
+	}
+}
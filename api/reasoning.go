@@ -0,0 +1,222 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// 推理模型在正文前以 <think>...</think> 输出思考过程。
+const (
+	thinkOpenTag  = "<think>"
+	thinkCloseTag = "</think>"
+)
+
+// reasoningFormat 决定思考过程的返回方式。
+type reasoningFormat string
+
+const (
+	reasoningSeparate reasoningFormat = "separate" // 通过 reasoning_content 单独返回
+	reasoningInline   reasoningFormat = "inline"   // 以 <think> 标签包裹放在正文前
+	reasoningHidden   reasoningFormat = "hidden"   // 不返回
+)
+
+// reasoningModels 列出会输出思考过程的 You.com 模型。
+var reasoningModels = map[string]bool{
+	"deepseek_r1":                true,
+	"claude_3_7_sonnet_thinking": true,
+	"openai_o1":                  true,
+	"openai_o1_mini":             true,
+	"openai_o1_preview":          true,
+	"openai_o3_mini_high":        true,
+	"openai_o3_mini_medium":      true,
+	"qwq_32b":                    true,
+}
+
+// defaultReasoningFormat 是未指定 reasoning_format 时的默认方式，可通过 REASONING_FORMAT 配置；
+// modelReasoningFormats 为按模型的默认方式，通过 REASONING_FORMATS 配置，
+// 例如 "deepseek_r1=inline,o1=hidden"。
+var (
+	defaultReasoningFormat = reasoningSeparate
+	modelReasoningFormats  = map[string]reasoningFormat{}
+)
+
+func init() {
+	if value := os.Getenv("REASONING_FORMAT"); value != "" {
+		if format, ok := parseReasoningFormat(value); ok {
+			defaultReasoningFormat = format
+		} else {
+			fmt.Printf("忽略无效的 REASONING_FORMAT: %s\n", value)
+		}
+	}
+	for _, entry := range strings.Split(os.Getenv("REASONING_FORMATS"), ",") {
+		model, value, found := strings.Cut(strings.TrimSpace(entry), "=")
+		if !found {
+			continue
+		}
+		if format, ok := parseReasoningFormat(value); ok {
+			modelReasoningFormats[strings.TrimSpace(model)] = format
+		} else {
+			fmt.Printf("忽略无效的 REASONING_FORMATS 配置: %s\n", entry)
+		}
+	}
+}
+
+// parseReasoningFormat 解析思考过程的返回方式。
+func parseReasoningFormat(value string) (reasoningFormat, bool) {
+	switch format := reasoningFormat(strings.TrimSpace(value)); format {
+	case reasoningSeparate, reasoningInline, reasoningHidden:
+		return format, true
+	}
+	return "", false
+}
+
+// resolveReasoningFormat 依次使用请求参数、模型配置与全局默认值确定思考过程的返回方式。
+func resolveReasoningFormat(requested, model string) (reasoningFormat, error) {
+	if requested != "" {
+		format, ok := parseReasoningFormat(requested)
+		if !ok {
+			return "", fmt.Errorf("reasoning_format must be one of separate, inline or hidden")
+		}
+		return format, nil
+	}
+	if format, ok := modelReasoningFormats[model]; ok {
+		return format, nil
+	}
+	if format, ok := modelReasoningFormats[mapModelName(model)]; ok {
+		return format, nil
+	}
+	return defaultReasoningFormat, nil
+}
+
+// isReasoningModel 报告模型是否会在正文中以 <think> 标签输出思考过程。
+func isReasoningModel(model string) bool {
+	return reasoningModels[mapModelName(model)]
+}
+
+// apply 按返回方式将完整的思考过程写入助手消息。
+func (f reasoningFormat) apply(message *Message, reasoning string) {
+	if reasoning == "" {
+		return
+	}
+	switch f {
+	case reasoningSeparate:
+		message.ReasoningContent = reasoning
+	case reasoningInline:
+		message.Content = thinkOpenTag + reasoning + thinkCloseTag + "\n\n" + message.Content
+	}
+}
+
+// reasoningEncoder 按返回方式将流式的思考过程转换为增量内容。
+type reasoningEncoder struct {
+	format reasoningFormat
+	open   bool // inline 方式下已输出 <think> 但尚未闭合
+}
+
+// delta 返回一段思考过程对应的增量内容，hidden 方式下返回空 Delta。
+func (e *reasoningEncoder) delta(reasoning string) Delta {
+	switch e.format {
+	case reasoningSeparate:
+		return Delta{ReasoningContent: reasoning}
+	case reasoningInline:
+		if !e.open {
+			e.open = true
+			return Delta{Content: thinkOpenTag + reasoning}
+		}
+		return Delta{Content: reasoning}
+	}
+	return Delta{}
+}
+
+// close 在正文开始前调用，inline 方式下返回闭合标签，否则返回空字符串。
+func (e *reasoningEncoder) close() string {
+	if !e.open {
+		return ""
+	}
+	e.open = false
+	return thinkCloseTag + "\n\n"
+}
+
+// thinkSplitter 将推理模型的输出拆分为思考过程与正文。
+// 只有位于输出开头（允许前导空白）的 <think> 标签被视为思考过程的开始。
+type thinkSplitter struct {
+	state   int // 0 尚未确定，1 思考中，2 正文
+	pending string
+	trim    bool // 思考结束后去除正文开头的换行
+}
+
+// push 处理一个 token，返回可以立即输出的思考过程与正文。
+func (s *thinkSplitter) push(token string) (reasoning, content string) {
+	s.pending += token
+
+	if s.state == 0 {
+		trimmed := strings.TrimLeft(s.pending, " \t\r\n")
+		switch {
+		case strings.HasPrefix(trimmed, thinkOpenTag):
+			s.state = 1
+			s.pending = trimmed[len(thinkOpenTag):]
+		case strings.HasPrefix(thinkOpenTag, trimmed):
+			return "", "" // 可能是 <think> 的一部分，继续等待
+		default:
+			s.state = 2
+		}
+	}
+
+	if s.state == 1 {
+		if idx := strings.Index(s.pending, thinkCloseTag); idx >= 0 {
+			reasoning = s.pending[:idx]
+			s.pending = s.pending[idx+len(thinkCloseTag):]
+			s.state = 2
+			s.trim = true
+		} else {
+			keep := partialSuffixLen(s.pending, thinkCloseTag)
+			reasoning = s.pending[:len(s.pending)-keep]
+			s.pending = s.pending[len(s.pending)-keep:]
+			return reasoning, ""
+		}
+	}
+
+	content = s.pending
+	s.pending = ""
+	if s.trim {
+		content = strings.TrimLeft(content, "\r\n")
+		s.trim = content == ""
+	}
+	return reasoning, content
+}
+
+// flush 在上游结束时调用，返回暂存的剩余文本。
+func (s *thinkSplitter) flush() (reasoning, content string) {
+	pending := s.pending
+	s.pending = ""
+	if s.state == 1 {
+		return pending, ""
+	}
+	return "", pending
+}
+
+// isReasoningEvent 报告 You.com 的事件是否携带思考过程。
+func isReasoningEvent(event string) bool {
+	event = strings.ToLower(event)
+	return strings.Contains(event, "reasoning") || strings.Contains(event, "thinking")
+}
+
+// reasoningEventText 从思考事件的数据中取出文本。与 youChatToken 相同，
+// 文本通常位于与事件同名的字段中。
+func reasoningEventText(event, data string) string {
+	var text string
+	if err := json.Unmarshal([]byte(data), &text); err == nil {
+		return text
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal([]byte(data), &fields); err != nil {
+		return ""
+	}
+	for _, key := range []string{event, "reasoning", "thinking", "text", "t"} {
+		if text, ok := fields[key].(string); ok {
+			return text
+		}
+	}
+	return ""
+}
@@ -0,0 +1,85 @@
+package handler
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"you2api/youclient"
+)
+
+func TestThinkSplitter(t *testing.T) {
+	tests := []struct {
+		name          string
+		tokens        []string
+		wantReasoning string
+		wantContent   string
+	}{
+		{
+			name:          "标签跨越 token 边界",
+			tokens:        []string{"\n<thi", "nk>想一想", "</th", "ink>\n\n答案"},
+			wantReasoning: "想一想",
+			wantContent:   "答案",
+		},
+		{
+			name:        "没有思考过程",
+			tokens:      []string{"Hello", " <think>"},
+			wantContent: "Hello <think>",
+		},
+		{
+			name:          "思考未结束",
+			tokens:        []string{"<think>abc", "</thi"},
+			wantReasoning: "abc</thi",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var splitter thinkSplitter
+			var reasoning, content string
+			for _, token := range tt.tokens {
+				r, c := splitter.push(token)
+				reasoning += r
+				content += c
+			}
+			r, c := splitter.flush()
+			reasoning += r
+			content += c
+
+			if reasoning != tt.wantReasoning {
+				t.Errorf("reasoning = %q, want %q", reasoning, tt.wantReasoning)
+			}
+			if content != tt.wantContent {
+				t.Errorf("content = %q, want %q", content, tt.wantContent)
+			}
+		})
+	}
+}
+
+func TestReadYouChatStream(t *testing.T) {
+	body := strings.Join([]string{
+		"event: youChatReasoningToken",
+		`data: {"youChatReasoningToken": "step 1"}`,
+		"",
+		"event: youChatToken",
+		`data: {"youChatToken": "<think>step 2</think>"}`,
+		"",
+		"event: youChatToken",
+		`data: {"youChatToken": "answer"}`,
+		"",
+	}, "\n")
+
+	var reasoning, content string
+	err := readYouChatStream(youclient.NewStream(context.Background(), io.NopCloser(strings.NewReader(body))), true, func(r, c string) bool {
+		reasoning += r
+		content += c
+		return true
+	}, nil)
+	if err != nil {
+		t.Fatalf("readYouChatStream() error = %v", err)
+	}
+	if reasoning != "step 1step 2" || content != "answer" {
+		t.Errorf("got reasoning %q, content %q", reasoning, content)
+	}
+}
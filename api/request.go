@@ -0,0 +1,67 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// requestContext 保存单个聊天请求在整个处理流程中的状态（模型、ID、DS token、
+// 计时与输出选项）。每个请求由 Handler 单独创建并向下传递，请求之间不共享任何可变状态。
+type requestContext struct {
+	ctx       context.Context // 客户端请求的 context，客户端断开时被取消
+	id        string          // chat.completion ID，同一请求的所有响应块共用
+	created   int64           // 响应中的 created 时间戳
+	startedAt time.Time
+
+	account *upstreamAccount
+	dsToken string // account 的 DS token
+	model   string // 客户端请求的 OpenAI 模型名称
+
+	tools        *toolEmulation
+	limits       outputLimits
+	reasoning    reasoningFormat
+	you          YouOptions
+	includeUsage bool
+	promptTokens int
+}
+
+// newRequestContext 为一次请求生成 ID 并开始计时。
+func newRequestContext(ctx context.Context, account *upstreamAccount, model string) *requestContext {
+	now := time.Now()
+	return &requestContext{
+		ctx:       ctx,
+		id:        newChatCompletionID(),
+		created:   now.Unix(),
+		startedAt: now,
+		account:   account,
+		dsToken:   account.token,
+		model:     model,
+	}
+}
+
+// elapsed 返回请求开始以来经过的时间。
+func (rc *requestContext) elapsed() time.Duration {
+	return time.Since(rc.startedAt)
+}
+
+// logDone 打印请求完成时的 ID、模型、账号（及 API key）与耗时。
+func (rc *requestContext) logDone() {
+	account := rc.account.name()
+	if label := rc.account.label(); label != "" {
+		account += ", key=" + label
+	}
+	fmt.Printf("请求完成: id=%s, model=%s, account=%s, 耗时=%s\n", rc.id, rc.model, account, rc.elapsed().Round(time.Millisecond))
+}
+
+// canceled 报告客户端是否已断开，是则按 stage 记录取消。
+func (rc *requestContext) canceled(stage string) bool {
+	return clientCanceled(rc.ctx, "chat", stage)
+}
+
+// recordUsage 根据输出文本估算本次请求的 token 用量，并计入指标。
+func (rc *requestContext) recordUsage(completion string) TokenCount {
+	usage := newTokenCount(rc.promptTokens, estimateTextTokens(completion))
+	recordUsage(rc.account, rc.model, usage.PromptTokens, usage.CompletionTokens)
+	return usage
+}
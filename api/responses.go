@@ -0,0 +1,445 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// responseStoreTTL 是服务端保存 Responses API 结果的时长。
+const responseStoreTTL = 24 * time.Hour
+
+// maxStoredResponses 是服务端最多保存的响应数，超出时删除最早保存的响应。
+var maxStoredResponses = 1000
+
+// ResponsesRequest 定义了 /v1/responses 请求体的结构。
+type ResponsesRequest struct {
+	Model              string          `json:"model"`
+	Input              json.RawMessage `json:"input"` // 字符串或输入项数组
+	Instructions       string          `json:"instructions"`
+	PreviousResponseID string          `json:"previous_response_id"`
+	Stream             bool            `json:"stream"`
+	Store              *bool           `json:"store"`         // 默认为 true
+	You                *YouOptions     `json:"you,omitempty"` // 按请求覆盖的 You.com 选项
+}
+
+// ResponseInputItem 定义了 input 数组中的单个消息项。
+type ResponseInputItem struct {
+	Type    string          `json:"type"`
+	Role    string          `json:"role"`
+	Content json.RawMessage `json:"content"` // 字符串或内容部分数组
+}
+
+// ResponseObject 定义了 Responses API 返回的 response 对象。
+type ResponseObject struct {
+	ID                 string               `json:"id"`
+	Object             string               `json:"object"`
+	CreatedAt          int64                `json:"created_at"`
+	Status             string               `json:"status"`
+	Model              string               `json:"model"`
+	Instructions       *string              `json:"instructions"`
+	PreviousResponseID *string              `json:"previous_response_id"`
+	Output             []ResponseOutputItem `json:"output"`
+	Usage              *ResponseUsage       `json:"usage"`
+	Error              *OpenAIError         `json:"error"`
+	Store              bool                 `json:"store"`
+}
+
+// ResponseOutputItem 定义了 response 对象 output 数组中的消息项。
+type ResponseOutputItem struct {
+	Type    string                  `json:"type"`
+	ID      string                  `json:"id"`
+	Status  string                  `json:"status"`
+	Role    string                  `json:"role"`
+	Content []ResponseOutputContent `json:"content"`
+}
+
+// ResponseOutputContent 定义了输出消息中的单个内容部分。
+type ResponseOutputContent struct {
+	Type        string        `json:"type"`
+	Text        string        `json:"text"`
+	Annotations []interface{} `json:"annotations"`
+}
+
+// ResponseUsage 定义了 Responses API 的 token 用量。
+type ResponseUsage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+	TotalTokens  int `json:"total_tokens"`
+}
+
+// storedResponse 是保存在服务端的一次响应及其会话状态。
+type storedResponse struct {
+	response     ResponseObject
+	owner        string // 只有同一个客户端凭据才能读取或续接
+	account      string // 生成响应的账号，续接时必须使用同一账号
+	conversation *youConversation
+	storedAt     time.Time
+}
+
+// responseStore 按 response ID 保存已完成的响应。
+var responseStore = struct {
+	sync.Mutex
+	items map[string]*storedResponse
+}{items: make(map[string]*storedResponse)}
+
+// saveResponse 保存响应，并顺带清理过期条目；已达到 maxStoredResponses 时删除最早保存的响应。
+func saveResponse(stored *storedResponse) {
+	responseStore.Lock()
+	defer responseStore.Unlock()
+
+	now := time.Now()
+	for id, item := range responseStore.items {
+		if now.Sub(item.storedAt) > responseStoreTTL {
+			delete(responseStore.items, id)
+		}
+	}
+	for len(responseStore.items) >= maxStoredResponses {
+		var oldestID string
+		var oldest time.Time
+		for id, item := range responseStore.items {
+			if oldestID == "" || item.storedAt.Before(oldest) {
+				oldestID, oldest = id, item.storedAt
+			}
+		}
+		delete(responseStore.items, oldestID)
+	}
+	stored.storedAt = now
+	responseStore.items[stored.response.ID] = stored
+}
+
+// loadResponse 读取属于 owner 的已保存响应。
+func loadResponse(id, owner string) (*storedResponse, bool) {
+	responseStore.Lock()
+	defer responseStore.Unlock()
+
+	stored, ok := responseStore.items[id]
+	if !ok || stored.owner != owner || time.Since(stored.storedAt) > responseStoreTTL {
+		return nil, false
+	}
+	return stored, true
+}
+
+// deleteResponse 删除属于 owner 的已保存响应。
+func deleteResponse(id, owner string) bool {
+	responseStore.Lock()
+	defer responseStore.Unlock()
+
+	stored, ok := responseStore.items[id]
+	if !ok || stored.owner != owner {
+		return false
+	}
+	delete(responseStore.items, id)
+	return true
+}
+
+// handleResponses 处理 /v1/responses（创建）与 /v1/responses/{id}（读取、删除）请求。
+func handleResponses(w http.ResponseWriter, r *http.Request) {
+	// 设置 CORS 头部
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "*")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	// 凭据同时用于区分响应的归属，只有同一凭据才能读取、删除或续接响应
+	credential, _ := extractDSToken(r)
+	if _, err := authorizeCredential(credential); err != nil {
+		writeAccountError(w, err)
+		return
+	}
+
+	responseID := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/v1/responses"), "/")
+	if responseID == "" {
+		if r.Method != http.MethodPost {
+			writeOpenAIError(w, http.StatusMethodNotAllowed, "invalid_request_error", "", "Method not allowed")
+			return
+		}
+		createResponse(w, r, credential)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		stored, ok := loadResponse(responseID, credential)
+		if !ok {
+			writeOpenAIError(w, http.StatusNotFound, "invalid_request_error", "", fmt.Sprintf("Response with id '%s' not found.", responseID))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(stored.response)
+	case http.MethodDelete:
+		if !deleteResponse(responseID, credential) {
+			writeOpenAIError(w, http.StatusNotFound, "invalid_request_error", "", fmt.Sprintf("Response with id '%s' not found.", responseID))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"id":      responseID,
+			"object":  "response",
+			"deleted": true,
+		})
+	default:
+		writeOpenAIError(w, http.StatusMethodNotAllowed, "invalid_request_error", "", "Method not allowed")
+	}
+}
+
+// createResponse 处理 POST /v1/responses。存在 previous_response_id 时复用服务端保存的历史，
+// 只发送并上传本轮新增的内容。
+func createResponse(w http.ResponseWriter, r *http.Request, credential string) {
+	var responsesReq ResponsesRequest
+	if err := json.NewDecoder(r.Body).Decode(&responsesReq); err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, "invalid_request_error", "", "Invalid request body")
+		return
+	}
+
+	messages, err := responsesInputToMessages(responsesReq.Input)
+	if err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, "invalid_request_error", "", err.Error())
+		return
+	}
+	if responsesReq.Instructions != "" {
+		messages = append([]Message{{Role: "system", Content: responsesReq.Instructions}}, messages...)
+	}
+
+	var prior *youConversation
+	var preferred string
+	if responsesReq.PreviousResponseID != "" {
+		stored, ok := loadResponse(responsesReq.PreviousResponseID, credential)
+		if !ok {
+			writeOpenAIError(w, http.StatusNotFound, "invalid_request_error", "previous_response_not_found",
+				fmt.Sprintf("Previous response with id '%s' not found.", responsesReq.PreviousResponseID))
+			return
+		}
+		prior = stored.conversation
+		preferred = stored.account
+	}
+
+	youOptions, err := resolveYouOptions(r, responsesReq.You)
+	if err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, "invalid_request_error", "", err.Error())
+		return
+	}
+
+	// 上传的文件只属于上传时使用的账号，续接时必须使用同一账号，否则历史中的文件引用会失效
+	account, err := acquireAccount(w, accountRequest{credential: credential, model: responsesReq.Model, preferred: preferred, stream: responsesReq.Stream})
+	if err != nil {
+		writeAccountError(w, err)
+		return
+	}
+	defer account.release()
+	if prior != nil && account.name() != preferred {
+		writeOpenAIError(w, http.StatusServiceUnavailable, "api_error", "previous_response_account_unavailable",
+			fmt.Sprintf("The account that stored previous response '%s' is currently unavailable; retry later or start a new conversation.", responsesReq.PreviousResponseID))
+		return
+	}
+	dsToken := account.token
+
+	youReq, conversation, err := buildYouRequestWithHistory(r.Context(), dsToken, responsesReq.Model, prior, messages, youOptions)
+	if err != nil {
+		if clientCanceled(r.Context(), "responses", stagePrepare) {
+			return
+		}
+		writeOpenAIError(w, http.StatusInternalServerError, "api_error", "", err.Error())
+		return
+	}
+
+	events, err := openYouStream(account, newUpstreamClient(upstreamTimeoutFor(responsesReq.Stream)), youReq)
+	if err != nil {
+		if clientCanceled(r.Context(), "responses", stageRequest) {
+			return
+		}
+		writeUpstreamError(w, err)
+		return
+	}
+	defer events.Close()
+
+	response := ResponseObject{
+		ID:        "resp_" + strings.ReplaceAll(uuid.New().String(), "-", ""),
+		Object:    "response",
+		CreatedAt: time.Now().Unix(),
+		Status:    "in_progress",
+		Model:     reverseMapModelName(mapModelName(responsesReq.Model)),
+		Output:    []ResponseOutputItem{},
+		Store:     responsesReq.Store == nil || *responsesReq.Store,
+	}
+	if responsesReq.Instructions != "" {
+		response.Instructions = &responsesReq.Instructions
+	}
+	if responsesReq.PreviousResponseID != "" {
+		response.PreviousResponseID = &responsesReq.PreviousResponseID
+	}
+	item := ResponseOutputItem{
+		Type:    "message",
+		ID:      "msg_" + strings.ReplaceAll(uuid.New().String(), "-", ""),
+		Status:  "in_progress",
+		Role:    "assistant",
+		Content: []ResponseOutputContent{},
+	}
+
+	// finish 填充最终输出并在需要时保存到服务端
+	finish := func(text string) {
+		item.Status = "completed"
+		item.Content = []ResponseOutputContent{{Type: "output_text", Text: text, Annotations: []interface{}{}}}
+		response.Status = "completed"
+		response.Output = []ResponseOutputItem{item}
+		inputTokens, _ := countTokens(messages)
+		outputTokens, _ := countTokens([]Message{{Role: "assistant", Content: text}})
+		recordUsage(account, responsesReq.Model, inputTokens, outputTokens)
+		response.Usage = &ResponseUsage{
+			InputTokens:  inputTokens,
+			OutputTokens: outputTokens,
+			TotalTokens:  inputTokens + outputTokens,
+		}
+		if response.Store {
+			conversation.LastAnswer = text
+			saveResponse(&storedResponse{
+				response:     response,
+				owner:        account.owner,
+				account:      account.name(),
+				conversation: conversation,
+			})
+		}
+	}
+
+	var fullResponse strings.Builder
+
+	if !responsesReq.Stream {
+		err := readYouChatTokens(events, func(token string) bool {
+			fullResponse.WriteString(token)
+			return true
+		})
+		if err != nil {
+			if clientCanceled(r.Context(), "responses", stageStream) {
+				return
+			}
+			writeOpenAIError(w, http.StatusBadGateway, "api_error", "", "Error reading response")
+			return
+		}
+		finish(fullResponse.String())
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+
+	// 设置流式响应的头部
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	sequence := 0
+	emit := func(eventType string, payload map[string]interface{}) {
+		payload["type"] = eventType
+		payload["sequence_number"] = sequence
+		sequence++
+		payloadBytes, _ := json.Marshal(payload)
+		fmt.Fprintf(w, "event: %s\ndata: %s\n\n", eventType, string(payloadBytes))
+		if flusher, ok := w.(http.Flusher); ok {
+			flusher.Flush()
+		}
+	}
+
+	emit("response.created", map[string]interface{}{"response": response})
+	emit("response.in_progress", map[string]interface{}{"response": response})
+	emit("response.output_item.added", map[string]interface{}{"output_index": 0, "item": item})
+	emptyPart := ResponseOutputContent{Type: "output_text", Text: "", Annotations: []interface{}{}}
+	emit("response.content_part.added", map[string]interface{}{
+		"item_id": item.ID, "output_index": 0, "content_index": 0, "part": emptyPart,
+	})
+
+	err = readYouChatTokens(events, func(token string) bool {
+		fullResponse.WriteString(token)
+		emit("response.output_text.delta", map[string]interface{}{
+			"item_id": item.ID, "output_index": 0, "content_index": 0, "delta": token,
+		})
+		return true
+	})
+	if err != nil {
+		if clientCanceled(r.Context(), "responses", stageStream) {
+			return
+		}
+		fmt.Printf("读取流式响应失败: %v\n", err)
+		response.Status = "failed"
+		response.Error = &OpenAIError{Message: "Error reading response", Type: "api_error"}
+		emit("response.failed", map[string]interface{}{"response": response})
+		return
+	}
+
+	finish(fullResponse.String())
+	part := item.Content[0]
+	emit("response.output_text.done", map[string]interface{}{
+		"item_id": item.ID, "output_index": 0, "content_index": 0, "text": part.Text,
+	})
+	emit("response.content_part.done", map[string]interface{}{
+		"item_id": item.ID, "output_index": 0, "content_index": 0, "part": part,
+	})
+	emit("response.output_item.done", map[string]interface{}{"output_index": 0, "item": item})
+	emit("response.completed", map[string]interface{}{"response": response})
+}
+
+// responsesInputToMessages 将字符串或输入项数组形式的 input 转换为 Message 列表。
+// developer 角色按 system 处理，其它类型的输入项（如工具输出）被忽略。
+func responsesInputToMessages(raw json.RawMessage) ([]Message, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, errors.New("Missing input")
+	}
+
+	var text string
+	if err := json.Unmarshal(raw, &text); err == nil {
+		return []Message{{Role: "user", Content: text}}, nil
+	}
+
+	var items []ResponseInputItem
+	if err := json.Unmarshal(raw, &items); err != nil {
+		return nil, errors.New("input must be a string or an array of input items")
+	}
+
+	var messages []Message
+	for i, item := range items {
+		if item.Type != "" && item.Type != "message" {
+			continue
+		}
+		content, err := responsesContentText(item.Content)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid content in input.%d: %v", i, err)
+		}
+		role := item.Role
+		if role == "developer" {
+			role = "system"
+		}
+		messages = append(messages, Message{Role: role, Content: content})
+	}
+	if len(messages) == 0 {
+		return nil, errNoMessages
+	}
+	return messages, nil
+}
+
+// responsesContentText 提取字符串或内容部分数组（input_text/output_text）中的文本。
+func responsesContentText(raw json.RawMessage) (string, error) {
+	var text string
+	if err := json.Unmarshal(raw, &text); err == nil {
+		return text, nil
+	}
+
+	var parts []ResponseOutputContent
+	if err := json.Unmarshal(raw, &parts); err != nil {
+		return "", err
+	}
+	var texts []string
+	for _, part := range parts {
+		if part.Type == "input_text" || part.Type == "output_text" || part.Type == "text" {
+			texts = append(texts, part.Text)
+		}
+	}
+	return strings.Join(texts, "\n"), nil
+}
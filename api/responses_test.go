@@ -0,0 +1,239 @@
+package handler
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"you2api/pool"
+)
+
+// responsesRequest 以 credential 发送 /v1/responses 请求。
+func responsesRequest(t *testing.T, method, path, credential, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+credential)
+	rec := httptest.NewRecorder()
+	Handler(rec, req)
+	return rec
+}
+
+func TestResponsesChaining(t *testing.T) {
+	var mu sync.Mutex
+	var uploads []string
+	answers := []string{"first answer", "second answer", "third answer"}
+	turn := 0
+	newFakeYouWithUpload(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		answer := answers[turn]
+		turn++
+		mu.Unlock()
+		io.WriteString(w, youTokens(answer))
+	}, func(w http.ResponseWriter, r *http.Request) {
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			t.Errorf("upload without file: %v", err)
+			return
+		}
+		data, _ := io.ReadAll(file)
+		mu.Lock()
+		uploads = append(uploads, strings.TrimPrefix(string(data), "\ufeff"))
+		mu.Unlock()
+		io.WriteString(w, `{"filename": "file.txt", "user_filename": "file.txt"}`)
+	})
+
+	previousID := ""
+	for i, want := range [][]string{nil, {"first answer"}, {"second answer"}} {
+		body := `{"model": "gpt-4o", "input": "question ` + string(rune('1'+i)) + `"`
+		if previousID != "" {
+			body += `, "previous_response_id": "` + previousID + `"`
+		}
+		rec := responsesRequest(t, http.MethodPost, "/v1/responses", "test-token", body+"}")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("turn %d: status = %d: %s", i+1, rec.Code, rec.Body.String())
+		}
+		var response ResponseObject
+		if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+			t.Fatalf("turn %d: invalid response: %v", i+1, err)
+		}
+		if text := response.Output[0].Content[0].Text; text != answers[i] {
+			t.Errorf("turn %d: output = %q, want %q", i+1, text, answers[i])
+		}
+		if previousID != "" && (response.PreviousResponseID == nil || *response.PreviousResponseID != previousID) {
+			t.Errorf("turn %d: previous_response_id = %v, want %s", i+1, response.PreviousResponseID, previousID)
+		}
+
+		// 每一轮只上传上一轮的回答，之前已上传的历史直接复用
+		mu.Lock()
+		if strings.Join(uploads, "|") != strings.Join(want, "|") {
+			t.Errorf("turn %d: uploads = %q, want %q", i+1, uploads, want)
+		}
+		uploads = nil
+		mu.Unlock()
+		previousID = response.ID
+	}
+}
+
+func TestResponsesOwnerIsolation(t *testing.T) {
+	newFakeYou(t, func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, youTokens("secret answer"))
+	})
+
+	rec := responsesRequest(t, http.MethodPost, "/v1/responses", "owner-token", `{"model": "gpt-4o", "input": "hi"}`)
+	var response ResponseObject
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil || response.ID == "" {
+		t.Fatalf("create response: %v (%s)", err, rec.Body.String())
+	}
+	path := "/v1/responses/" + response.ID
+
+	// 其他凭据既不能读取、删除，也不能续接该响应
+	if rec := responsesRequest(t, http.MethodGet, path, "other-token", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("GET by another credential: status = %d, want 404", rec.Code)
+	}
+	if rec := responsesRequest(t, http.MethodDelete, path, "other-token", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("DELETE by another credential: status = %d, want 404", rec.Code)
+	}
+	rec = responsesRequest(t, http.MethodPost, "/v1/responses", "other-token", `{"model": "gpt-4o", "input": "hi", "previous_response_id": "`+response.ID+`"}`)
+	if rec.Code != http.StatusNotFound || !strings.Contains(rec.Body.String(), "previous_response_not_found") {
+		t.Errorf("chaining by another credential: status = %d: %s", rec.Code, rec.Body.String())
+	}
+
+	if rec := responsesRequest(t, http.MethodGet, path, "owner-token", ""); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "secret answer") {
+		t.Errorf("GET by owner: status = %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := responsesRequest(t, http.MethodDelete, path, "owner-token", ""); rec.Code != http.StatusOK {
+		t.Errorf("DELETE by owner: status = %d", rec.Code)
+	}
+	if rec := responsesRequest(t, http.MethodGet, path, "owner-token", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("GET after delete: status = %d, want 404", rec.Code)
+	}
+}
+
+func TestResponsesStreamEvents(t *testing.T) {
+	newFakeYou(t, func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, youTokens("Hello", " world"))
+	})
+
+	rec := responsesRequest(t, http.MethodPost, "/v1/responses", "test-token", `{"model": "gpt-4o", "stream": true, "input": "hi"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var types []string
+	var text string
+	for i, event := range strings.Split(strings.TrimSpace(rec.Body.String()), "\n\n") {
+		name, data, ok := strings.Cut(event, "\n")
+		if !ok || !strings.HasPrefix(name, "event: ") || !strings.HasPrefix(data, "data: ") {
+			t.Fatalf("malformed event %q", event)
+		}
+		var payload struct {
+			Type     string `json:"type"`
+			Sequence int    `json:"sequence_number"`
+			Delta    string `json:"delta"`
+			Response struct {
+				Status string `json:"status"`
+			} `json:"response"`
+		}
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(data, "data: ")), &payload); err != nil {
+			t.Fatalf("invalid payload %q: %v", data, err)
+		}
+		if payload.Type != strings.TrimPrefix(name, "event: ") || payload.Sequence != i {
+			t.Errorf("event %d: type %q, sequence_number %d", i, payload.Type, payload.Sequence)
+		}
+		if payload.Type == "response.completed" && payload.Response.Status != "completed" {
+			t.Errorf("response.completed status = %q", payload.Response.Status)
+		}
+		types = append(types, payload.Type)
+		text += payload.Delta
+	}
+
+	want := []string{
+		"response.created",
+		"response.in_progress",
+		"response.output_item.added",
+		"response.content_part.added",
+		"response.output_text.delta",
+		"response.output_text.delta",
+		"response.output_text.done",
+		"response.content_part.done",
+		"response.output_item.done",
+		"response.completed",
+	}
+	if strings.Join(types, ",") != strings.Join(want, ",") {
+		t.Errorf("events = %v, want %v", types, want)
+	}
+	if text != "Hello world" {
+		t.Errorf("deltas = %q, want Hello world", text)
+	}
+}
+
+func TestResponseStoreLimit(t *testing.T) {
+	previous := maxStoredResponses
+	maxStoredResponses = 2
+	t.Cleanup(func() { maxStoredResponses = previous })
+
+	for _, id := range []string{"resp_limit_1", "resp_limit_2", "resp_limit_3"} {
+		saveResponse(&storedResponse{response: ResponseObject{ID: id}, owner: "owner"})
+	}
+	if _, ok := loadResponse("resp_limit_1", "owner"); ok {
+		t.Error("oldest response was kept beyond maxStoredResponses")
+	}
+	for _, id := range []string{"resp_limit_2", "resp_limit_3"} {
+		if _, ok := loadResponse(id, "owner"); !ok {
+			t.Errorf("response %s was evicted", id)
+		}
+	}
+}
+
+func TestResponsesChainingPinsAccount(t *testing.T) {
+	p := useAccountPool(t, pool.AccountConfig{Name: "a", Token: "ta"}, pool.AccountConfig{Name: "b", Token: "tb"})
+	var mu sync.Mutex
+	var cookies []string
+	newFakeYou(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		cookies = append(cookies, r.Header.Get("Cookie"))
+		mu.Unlock()
+		io.WriteString(w, youTokens("answer"))
+	})
+	create := func(previousID string) *httptest.ResponseRecorder {
+		body := `{"model": "gpt-4o", "input": "hi"`
+		if previousID != "" {
+			body += `, "previous_response_id": "` + previousID + `"`
+		}
+		return responsesRequest(t, http.MethodPost, "/v1/responses", "test-token", body+"}")
+	}
+
+	var first ResponseObject
+	rec := create("")
+	if err := json.Unmarshal(rec.Body.Bytes(), &first); err != nil || first.ID == "" {
+		t.Fatalf("create response: %v (%s)", err, rec.Body.String())
+	}
+	stored, _ := loadResponse(first.ID, "test-token")
+
+	// 续接时即使轮换策略会选中另一个账号，也使用保存响应的账号
+	if rec := create(first.ID); rec.Code != http.StatusOK {
+		t.Fatalf("chained request: status = %d: %s", rec.Code, rec.Body.String())
+	}
+	if len(cookies) != 2 || cookies[0] != cookies[1] {
+		t.Errorf("chained request used a different account: %q", cookies)
+	}
+
+	// 保存响应的账号不可用时返回错误，而不是换用其他账号发送失效的文件引用
+	lease, err := p.AcquirePreferred(stored.account)
+	if err != nil || lease.Name() != stored.account {
+		t.Fatalf("AcquirePreferred(%s) = %v, %v", stored.account, lease, err)
+	}
+	lease.Fail(pool.ReasonAuth)
+	lease.Release()
+	rec = create(first.ID)
+	if rec.Code != http.StatusServiceUnavailable || !strings.Contains(rec.Body.String(), "previous_response_account_unavailable") {
+		t.Errorf("chained request with the account unavailable: status = %d: %s", rec.Code, rec.Body.String())
+	}
+	if len(cookies) != 2 {
+		t.Errorf("upstream received %d requests, want no request on another account", len(cookies))
+	}
+}
@@ -0,0 +1,121 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"you2api/inband"
+	"you2api/pool"
+	"you2api/retry"
+	"you2api/youclient"
+)
+
+// retryPolicy 是聊天请求的重试与模型回退策略（见 retry.PolicyFromEnv），默认不重试。
+var retryPolicy = retry.DefaultPolicy()
+
+func init() {
+	policy, err := retry.PolicyFromEnv()
+	if err != nil {
+		fmt.Printf("加载重试策略失败，不重试: %v\n", err)
+		return
+	}
+	retryPolicy = policy
+}
+
+// openChatStream 构建并发送 You.com 请求，返回第一个成功的响应；以 200 返回、但被 detectInBand
+// 识别为额度、鉴权提示或空回答的响应同样视为失败。可重试的失败按 retryPolicy
+// 等待后换用账号池中的其他账号重试；当前模型的尝试次数用完后依次改用回退链中的模型
+// （跳过 API key 不允许的模型）。客户端直接提供的 DS token 因鉴权、额度或限流失败时不重试。
+// 这一切都发生在向客户端写入任何内容之前，成功时 rc.model 为实际回答的模型。
+// 失败时已以 OpenAI 错误格式写入响应或客户端已断开，返回 false。
+func openChatStream(w http.ResponseWriter, rc *requestContext, client *http.Client, messages []Message) (*youclient.Stream, bool) {
+	requested := rc.model
+	retries := 0
+	var lastErr error
+
+models:
+	for i, model := range retryPolicy.Models(requested) {
+		if i > 0 && rc.account.key != nil && !rc.account.key.AllowsModel(modelNames(model)...) {
+			continue
+		}
+		for attempt := 1; attempt <= retryPolicy.MaxAttempts; attempt++ {
+			if lastErr != nil {
+				retries++
+				if !sleepContext(rc.ctx, retryPolicy.Backoff(retries)) {
+					rc.canceled(stageRequest)
+					return nil, false
+				}
+				if err := rc.account.switchAccount(); err != nil {
+					fmt.Printf("重试时没有可用账号: %v\n", err)
+					break models
+				}
+				rc.dsToken = rc.account.token
+				fmt.Printf("重试上游请求: id=%s, model=%s, account=%s, 第 %d 次重试\n", rc.id, model, rc.account.name(), retries)
+			}
+
+			// 构建 You.com 请求（聊天历史、文件上传、查询参数），文件属于账号，换用账号后需要重新上传
+			youReq, err := buildYouRequest(rc.ctx, rc.dsToken, model, messages, rc.you)
+			if err != nil {
+				if rc.canceled(stagePrepare) {
+					return nil, false
+				}
+				writeOpenAIError(w, http.StatusInternalServerError, "api_error", "", err.Error())
+				return nil, false
+			}
+
+			// 以 200 返回的额度、鉴权提示或空回答同样换用账号重试
+			events, err := openYouStream(rc.account, client, youReq)
+			if err == nil {
+				if model != requested {
+					fmt.Printf("模型 %s 请求失败，已回退到 %s: id=%s\n", requested, model, rc.id)
+					rc.model = model
+				}
+				return events, true
+			}
+			if rc.canceled(stageRequest) {
+				return nil, false
+			}
+			lastErr = err
+			if !retryPolicy.Retryable(err) {
+				break models
+			}
+			// 客户端直接提供的 DS token 没有可换用的账号，重试只会再次使用同一个失效的 token
+			if rc.account.lease == nil && accountFailure(err) {
+				break models
+			}
+		}
+	}
+
+	writeUpstreamError(w, lastErr)
+	return nil, false
+}
+
+// accountFailure 判断失败是否由账号本身导致（鉴权失败、额度用尽或被限流），
+// 这类失败只有换用账号才可能恢复。
+func accountFailure(err error) bool {
+	var inBandErr *inBandError
+	if errors.As(err, &inBandErr) {
+		return inBandErr.kind == inband.KindQuota || inBandErr.kind == inband.KindAuth
+	}
+	var statusErr *youclient.StatusError
+	if errors.As(err, &statusErr) {
+		_, ok := pool.Classify(statusErr.StatusCode, statusErr.Body)
+		return ok
+	}
+	return false
+}
+
+// sleepContext 等待 d，ctx 先被取消时返回 false。
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,122 @@
+package handler
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"you2api/pool"
+	"you2api/retry"
+)
+
+// useRetryPolicy 让 retryPolicy 使用 policy，测试结束后恢复。
+func useRetryPolicy(t *testing.T, policy retry.Policy) {
+	t.Helper()
+	previous := retryPolicy
+	retryPolicy = policy
+	t.Cleanup(func() { retryPolicy = previous })
+}
+
+func TestHandlerRetry(t *testing.T) {
+	policy := retry.DefaultPolicy()
+	policy.MaxAttempts = 2
+	policy.InitialBackoff = time.Millisecond
+	policy.Fallbacks = map[string][]string{"claude-3-7-sonnet": {"claude-3.5-sonnet", "gpt-4o"}}
+	useRetryPolicy(t, policy)
+
+	tests := []struct {
+		name         string
+		accounts     []pool.AccountConfig
+		body         string
+		failModels   string // 这些 You.com 模型总是返回 failStatus
+		failToken    string // 使用该 DS token 的请求总是返回 failStatus
+		failStatus   int
+		wantStatus   int
+		wantRequests int32
+		wantText     string
+	}{
+		{
+			name:         "retry on another account",
+			accounts:     []pool.AccountConfig{{Name: "a", Token: "ta"}, {Name: "b", Token: "tb"}},
+			body:         `{"model": "gpt-4o", "stream": true, "messages": [{"role": "user", "content": "hi"}]}`,
+			failToken:    "ta",
+			failStatus:   http.StatusBadGateway,
+			wantStatus:   http.StatusOK,
+			wantRequests: 2,
+			wantText:     "data: [DONE]",
+		},
+		{
+			name:         "fall back to the next model",
+			accounts:     []pool.AccountConfig{{Name: "a", Token: "ta"}},
+			body:         `{"model": "claude-3-7-sonnet", "messages": [{"role": "user", "content": "hi"}]}`,
+			failModels:   "claude_3_7_sonnet,claude_3_5_sonnet",
+			failStatus:   http.StatusServiceUnavailable,
+			wantStatus:   http.StatusOK,
+			wantRequests: 5,
+			wantText:     `"model":"gpt-4o"`,
+		},
+		{
+			name:         "non-retryable status",
+			accounts:     []pool.AccountConfig{{Name: "a", Token: "ta"}, {Name: "b", Token: "tb"}},
+			body:         `{"model": "claude-3-7-sonnet", "messages": [{"role": "user", "content": "hi"}]}`,
+			failModels:   "claude_3_7_sonnet",
+			failStatus:   http.StatusBadRequest,
+			wantStatus:   http.StatusBadRequest,
+			wantRequests: 1,
+			wantText:     `"message":"API returned status 400`,
+		},
+		{
+			name:         "direct token auth failure is not retried",
+			body:         `{"model": "claude-3-7-sonnet", "messages": [{"role": "user", "content": "hi"}]}`,
+			failToken:    "test-token",
+			failStatus:   http.StatusUnauthorized,
+			wantStatus:   http.StatusUnauthorized,
+			wantRequests: 1,
+			wantText:     `"message":"API returned status 401`,
+		},
+		{
+			name:         "direct token server error is retried",
+			body:         `{"model": "gpt-4o", "messages": [{"role": "user", "content": "hi"}]}`,
+			failToken:    "test-token",
+			failStatus:   http.StatusBadGateway,
+			wantStatus:   http.StatusBadGateway,
+			wantRequests: 2,
+			wantText:     `"message":"API returned status 502`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.accounts != nil {
+				useAccountPool(t, tt.accounts...)
+			}
+			var requests int32
+			newFakeYou(t, func(w http.ResponseWriter, r *http.Request) {
+				atomic.AddInt32(&requests, 1)
+				model := r.URL.Query().Get("selectedAiModel")
+				if (tt.failModels != "" && strings.Contains(tt.failModels, model)) ||
+					(tt.failToken != "" && strings.Contains(r.Header.Get("Cookie"), "DS="+tt.failToken+";")) {
+					w.WriteHeader(tt.failStatus)
+					return
+				}
+				io.WriteString(w, youTokens("answered by ", model))
+			})
+
+			rec := httptest.NewRecorder()
+			Handler(rec, chatRequest(tt.body))
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if got := atomic.LoadInt32(&requests); got != tt.wantRequests {
+				t.Errorf("upstream requests = %d, want %d", got, tt.wantRequests)
+			}
+			if !strings.Contains(rec.Body.String(), tt.wantText) {
+				t.Errorf("body = %s, want %s", rec.Body.String(), tt.wantText)
+			}
+		})
+	}
+}
@@ -0,0 +1,193 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"you2api/youclient"
+)
+
+// 搜索参数的上限与超时时间，默认值与聊天请求相同，见 defaultYouOptions。
+const (
+	maxSearchCount = 50
+	searchTimeout  = 30 * time.Second
+)
+
+// SearchRequest 定义了 /v1/search 的请求参数，GET 请求通过同名查询参数传递。
+type SearchRequest struct {
+	Query      string `json:"query"`
+	Count      int    `json:"count,omitempty"`
+	SafeSearch string `json:"safeSearch,omitempty"` // Off、Moderate 或 Strict
+	Market     string `json:"mkt,omitempty"`
+	Page       int    `json:"page,omitempty"`
+}
+
+// SearchResult 定义了规范化后的单条搜索结果。
+type SearchResult struct {
+	Title   string `json:"title"`
+	URL     string `json:"url"`
+	Snippet string `json:"snippet"`
+	Date    string `json:"date,omitempty"`
+}
+
+// SearchResponse 定义了 /v1/search 的响应结构。
+type SearchResponse struct {
+	Object string         `json:"object"`
+	Query  string         `json:"query"`
+	Page   int            `json:"page"`
+	Data   []SearchResult `json:"data"`
+}
+
+// handleSearch 处理 /v1/search 请求：发送与聊天相同的 streamingSearch 请求，
+// 只收集网页搜索结果，收到结果或回答开始输出时即关闭上游连接。
+func handleSearch(w http.ResponseWriter, r *http.Request) {
+	// 设置 CORS 头部
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "*")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	credential, _ := extractDSToken(r)
+	account, err := acquireAccount(w, accountRequest{credential: credential})
+	if err != nil {
+		writeAccountError(w, err)
+		return
+	}
+	defer account.release()
+	dsToken := account.token
+
+	searchReq, err := parseSearchRequest(r)
+	if err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, "invalid_request_error", "", err.Error())
+		return
+	}
+
+	youReq, err := buildYouSearchRequest(r.Context(), dsToken, searchReq)
+	if err != nil {
+		writeOpenAIError(w, http.StatusInternalServerError, "api_error", "", err.Error())
+		return
+	}
+	stream, err := doYouRequest(account, newUpstreamClient(searchTimeout), youReq)
+	if err != nil {
+		if clientCanceled(r.Context(), "search", stageRequest) {
+			return
+		}
+		writeUpstreamError(w, err)
+		return
+	}
+	defer stream.Close()
+
+	results := []SearchResult{}
+	err = readYouEvents(stream, func(event youclient.Event) bool {
+		switch event.Name {
+		case youclient.EventSearchResults:
+			for _, result := range event.SearchResults() {
+				results = append(results, SearchResult{
+					Title:   result.Name,
+					URL:     result.URL,
+					Snippet: result.Snippet,
+					Date:    result.PageAge,
+				})
+			}
+			return false
+		case youclient.EventToken:
+			return false // 回答已开始，不会再有搜索结果
+		}
+		return true
+	})
+	if err != nil {
+		if clientCanceled(r.Context(), "search", stageStream) {
+			return
+		}
+		writeOpenAIError(w, http.StatusBadGateway, "api_error", "", errReadResponse.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(SearchResponse{
+		Object: "list",
+		Query:  searchReq.Query,
+		Page:   searchReq.Page,
+		Data:   results,
+	})
+}
+
+// parseSearchRequest 从 GET 查询参数或 POST JSON 请求体中解析搜索参数并填充默认值。
+func parseSearchRequest(r *http.Request) (SearchRequest, error) {
+	var req SearchRequest
+	switch r.Method {
+	case http.MethodGet:
+		query := r.URL.Query()
+		req.Query = query.Get("q")
+		if req.Query == "" {
+			req.Query = query.Get("query")
+		}
+		req.SafeSearch = query.Get("safeSearch")
+		req.Market = query.Get("mkt")
+		for name, target := range map[string]*int{"count": &req.Count, "page": &req.Page} {
+			if value := query.Get(name); value != "" {
+				n, err := strconv.Atoi(value)
+				if err != nil {
+					return req, fmt.Errorf("%s must be an integer", name)
+				}
+				*target = n
+			}
+		}
+	case http.MethodPost:
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			return req, errors.New("Invalid request body")
+		}
+	default:
+		return req, fmt.Errorf("Method %s not allowed", r.Method)
+	}
+
+	req.Query = strings.TrimSpace(req.Query)
+	if req.Query == "" {
+		return req, errors.New("query is required")
+	}
+	if req.Count == 0 {
+		req.Count = defaultYouOptions.Count
+	}
+	if req.Count < 1 || req.Count > maxSearchCount {
+		return req, fmt.Errorf("count must be between 1 and %d", maxSearchCount)
+	}
+	if req.Page == 0 {
+		req.Page = 1
+	}
+	if req.Page < 1 {
+		return req, errors.New("page must be a positive integer")
+	}
+	if req.SafeSearch == "" {
+		req.SafeSearch = defaultYouOptions.SafeSearch
+	}
+	safeSearch, ok := normalizeSafeSearch(req.SafeSearch)
+	if !ok {
+		return req, errors.New("safeSearch must be one of Off, Moderate or Strict")
+	}
+	req.SafeSearch = safeSearch
+	if req.Market == "" {
+		req.Market = defaultYouOptions.Market
+	}
+	return req, nil
+}
+
+// buildYouSearchRequest 构建只用于获取搜索结果的 streamingSearch 请求，不携带聊天历史与模型选择。
+func buildYouSearchRequest(ctx context.Context, dsToken string, searchReq SearchRequest) (*http.Request, error) {
+	return newYouClient(dsToken).NewStreamingSearchRequest(ctx, &youclient.StreamingSearchRequest{
+		Query:      searchReq.Query,
+		Page:       searchReq.Page,
+		Count:      searchReq.Count,
+		SafeSearch: searchReq.SafeSearch,
+		Market:     searchReq.Market,
+	})
+}
@@ -0,0 +1,54 @@
+package handler
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseSearchRequest(t *testing.T) {
+	tests := []struct {
+		name    string
+		method  string
+		target  string
+		body    string
+		want    SearchRequest
+		wantErr bool
+	}{
+		{
+			name:   "GET 默认值",
+			method: "GET",
+			target: "/v1/search?q=golang",
+			want:   SearchRequest{Query: "golang", Count: 10, SafeSearch: "Moderate", Market: "zh-HK", Page: 1},
+		},
+		{
+			name:   "GET 指定参数",
+			method: "GET",
+			target: "/v1/search?q=golang&count=5&safeSearch=strict&mkt=en-US&page=2",
+			want:   SearchRequest{Query: "golang", Count: 5, SafeSearch: "Strict", Market: "en-US", Page: 2},
+		},
+		{
+			name:   "POST JSON",
+			method: "POST",
+			target: "/v1/search",
+			body:   `{"query": "golang", "count": 3, "safeSearch": "Off"}`,
+			want:   SearchRequest{Query: "golang", Count: 3, SafeSearch: "Off", Market: "zh-HK", Page: 1},
+		},
+		{name: "缺少查询", method: "GET", target: "/v1/search", wantErr: true},
+		{name: "count 超出上限", method: "GET", target: "/v1/search?q=a&count=100", wantErr: true},
+		{name: "无效的 safeSearch", method: "GET", target: "/v1/search?q=a&safeSearch=maybe", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+			got, err := parseSearchRequest(r)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseSearchRequest() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("parseSearchRequest() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
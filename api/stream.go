@@ -0,0 +1,140 @@
+package handler
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// StreamOptions 定义了 OpenAI 请求中的 stream_options。
+type StreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
+}
+
+// newChatCompletionID 生成 chat.completion 的唯一 ID。
+func newChatCompletionID() string {
+	return "chatcmpl-" + strings.ReplaceAll(uuid.New().String(), "-", "")
+}
+
+// systemFingerprint 根据 You.com 模型名称生成稳定的 system_fingerprint。
+func systemFingerprint(model string) string {
+	sum := sha256.Sum256([]byte(mapModelName(model)))
+	return "fp_" + hex.EncodeToString(sum[:])[:10]
+}
+
+// chatStreamWriter 按 OpenAI 规范输出一次流式响应：所有块共用同一个 ID，
+// 首块包含 role，末块包含 finish_reason，可选的 usage 块，最后是 data: [DONE]。
+type chatStreamWriter struct {
+	w            http.ResponseWriter
+	id           string
+	created      int64
+	model        string
+	fingerprint  string
+	includeUsage bool
+	started      bool
+}
+
+// newChatStreamWriter 设置流式响应头并创建写入器，ID 与创建时间取自 rc。
+func newChatStreamWriter(w http.ResponseWriter, rc *requestContext) *chatStreamWriter {
+	// 设置流式响应的头部
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	return &chatStreamWriter{
+		w:            w,
+		id:           rc.id,
+		created:      rc.created,
+		model:        reverseMapModelName(mapModelName(rc.model)), // 映射回 OpenAI 模型名称
+		fingerprint:  systemFingerprint(rc.model),
+		includeUsage: rc.includeUsage,
+	}
+}
+
+// start 输出包含 role 的首块，重复调用无效果。
+func (s *chatStreamWriter) start() {
+	if s.started {
+		return
+	}
+	s.started = true
+	s.writeChunk([]Choice{{Delta: Delta{Role: "assistant"}}}, nil)
+}
+
+// write 输出一个增量块。
+func (s *chatStreamWriter) write(delta Delta) {
+	s.start()
+	s.writeChunk([]Choice{{Delta: delta}}, nil)
+}
+
+// annotate 输出包含引用注释的增量块，并在该块上附带 citations 扩展字段。
+func (s *chatStreamWriter) annotate(annotations []Annotation, citations []string) {
+	s.start()
+	chunk := s.chunk([]Choice{{Delta: Delta{Annotations: annotations}}})
+	chunk.Citations = citations
+	s.send(chunk)
+}
+
+// finish 输出带 finish_reason 的末块，最后写入 [DONE]。启用 include_usage 时按规范只在
+// 随后 choices 为空的块中输出 usage，避免客户端重复累计；否则 usage 附带在末块上。
+func (s *chatStreamWriter) finish(finishReason string, usage TokenCount) {
+	s.start()
+	if s.includeUsage {
+		s.writeChunk([]Choice{{Delta: Delta{}, FinishReason: &finishReason}}, nil)
+		s.writeChunk([]Choice{}, &usage)
+	} else {
+		s.writeChunk([]Choice{{Delta: Delta{}, FinishReason: &finishReason}}, &usage)
+	}
+	fmt.Fprint(s.w, "data: [DONE]\n\n")
+	s.flush()
+}
+
+// fail 在上游读取失败时输出错误块并结束流，不输出 finish_reason 与 [DONE]，
+// 避免截断的回答被客户端当作正常完成。
+func (s *chatStreamWriter) fail(message string) {
+	writeStreamError(s.w, message)
+}
+
+// writeStreamError 以 OpenAI 流式错误格式（data: {"error": {...}}）输出错误并刷新，
+// 响应头已发送时用它通知客户端。
+func writeStreamError(w http.ResponseWriter, message string) {
+	data, _ := json.Marshal(map[string]OpenAIError{"error": {Message: message, Type: "api_error"}})
+	fmt.Fprintf(w, "data: %s\n\n", string(data))
+	if flusher, ok := w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (s *chatStreamWriter) writeChunk(choices []Choice, usage *TokenCount) {
+	chunk := s.chunk(choices)
+	chunk.Usage = usage
+	s.send(chunk)
+}
+
+// chunk 构建带有本次流公共字段的响应块。
+func (s *chatStreamWriter) chunk(choices []Choice) OpenAIStreamResponse {
+	return OpenAIStreamResponse{
+		ID:                s.id,
+		Object:            "chat.completion.chunk",
+		Created:           s.created,
+		Model:             s.model,
+		SystemFingerprint: s.fingerprint,
+		Choices:           choices,
+	}
+}
+
+func (s *chatStreamWriter) send(chunk OpenAIStreamResponse) {
+	chunkBytes, _ := json.Marshal(chunk)                 // 将响应块序列化为 JSON
+	fmt.Fprintf(s.w, "data: %s\n\n", string(chunkBytes)) // 写入响应数据
+	s.flush()
+}
+
+func (s *chatStreamWriter) flush() {
+	if flusher, ok := s.w.(http.Flusher); ok {
+		flusher.Flush() // 立即刷新输出
+	}
+}
@@ -0,0 +1,129 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestChatStreamWriter(t *testing.T) {
+	rec := httptest.NewRecorder()
+	rc := newRequestContext(context.Background(), &upstreamAccount{token: "token"}, "gpt-4o")
+	rc.includeUsage = true
+	stream := newChatStreamWriter(rec, rc)
+	stream.write(Delta{Content: "Hello"})
+	stream.finish("stop", TokenCount{PromptTokens: 3, CompletionTokens: 1, TotalTokens: 4})
+
+	events := strings.Split(strings.TrimSpace(rec.Body.String()), "\n\n")
+	if len(events) != 5 {
+		t.Fatalf("got %d events, want 5: %q", len(events), events)
+	}
+	if events[4] != "data: [DONE]" {
+		t.Errorf("last event = %q, want data: [DONE]", events[4])
+	}
+
+	var chunks []OpenAIStreamResponse
+	for _, event := range events[:4] {
+		var chunk OpenAIStreamResponse
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(event, "data: ")), &chunk); err != nil {
+			t.Fatalf("invalid chunk %q: %v", event, err)
+		}
+		if chunk.ID != rc.id {
+			t.Errorf("chunk id = %q, want %q", chunk.ID, rc.id)
+		}
+		if chunk.SystemFingerprint == "" {
+			t.Errorf("chunk %q has no system_fingerprint", event)
+		}
+		chunks = append(chunks, chunk)
+	}
+
+	if role := chunks[0].Choices[0].Delta.Role; role != "assistant" {
+		t.Errorf("first delta role = %q, want assistant", role)
+	}
+	if content := chunks[1].Choices[0].Delta.Content; content != "Hello" {
+		t.Errorf("content delta = %q, want Hello", content)
+	}
+	if reason := chunks[1].Choices[0].FinishReason; reason != nil {
+		t.Errorf("intermediate finish_reason = %q, want null", *reason)
+	}
+	if reason := chunks[2].Choices[0].FinishReason; reason == nil || *reason != "stop" {
+		t.Errorf("final finish_reason = %v, want stop", reason)
+	}
+	if chunks[1].Usage != nil || chunks[2].Usage != nil {
+		t.Errorf("with include_usage, usage should only be sent in the trailing usage chunk")
+	}
+	if usage := chunks[3].Usage; len(chunks[3].Choices) != 0 || usage == nil || usage.TotalTokens != 4 {
+		t.Errorf("usage chunk = %+v, want empty choices and total_tokens 4", chunks[3])
+	}
+}
+
+func TestChatStreamWriterWithoutIncludeUsage(t *testing.T) {
+	rec := httptest.NewRecorder()
+	rc := newRequestContext(context.Background(), &upstreamAccount{token: "token"}, "gpt-4o")
+	stream := newChatStreamWriter(rec, rc)
+	stream.finish("stop", TokenCount{PromptTokens: 3, CompletionTokens: 1, TotalTokens: 4})
+
+	events := strings.Split(strings.TrimSpace(rec.Body.String()), "\n\n")
+	if len(events) != 3 {
+		t.Fatalf("got %d events, want role, finish and [DONE]: %q", len(events), events)
+	}
+	var chunk OpenAIStreamResponse
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(events[1], "data: ")), &chunk); err != nil {
+		t.Fatalf("invalid chunk %q: %v", events[1], err)
+	}
+	if chunk.Choices[0].FinishReason == nil || chunk.Usage == nil || chunk.Usage.TotalTokens != 4 {
+		t.Errorf("final chunk = %+v, want finish_reason with usage", chunk)
+	}
+}
+
+func TestStreamUpstreamCut(t *testing.T) {
+	newFakeYou(t, func(w http.ResponseWriter, r *http.Request) {
+		// 超过内嵌错误检测预读的长度，使部分内容在中断前已发送给客户端
+		cutYouStream(w, "Hello", strings.Repeat(" word", 100))
+	})
+
+	tests := []struct {
+		name      string
+		path      string
+		body      string
+		wantError string
+		normalEnd []string // 正常结束时才会出现的内容
+	}{
+		{"chat", "/v1/chat/completions", `{"model": "gpt-4o", "stream": true, "messages": [{"role": "user", "content": "hi"}]}`, `data: {"error":{"message":"Error reading response"`, []string{`"finish_reason":"`, "[DONE]"}},
+		{"completions", "/v1/completions", `{"model": "gpt-4o", "stream": true, "prompt": "hi"}`, `data: {"error":{"message":"Error reading response"`, []string{`"finish_reason":"`, "[DONE]"}},
+		{"ollama chat", "/api/chat", `{"model": "gpt-4o", "messages": [{"role": "user", "content": "hi"}]}`, `{"error":"Error reading response"}`, []string{`"done":true`}},
+		{"ollama generate", "/api/generate", `{"model": "gpt-4o", "prompt": "hi"}`, `{"error":"Error reading response"}`, []string{`"done":true`}},
+		{"gemini sse", "/v1beta/models/gpt-4o:streamGenerateContent?alt=sse", `{"contents": [{"parts": [{"text": "hi"}]}]}`, `data: {"error":`, []string{"STOP", "usageMetadata"}},
+		{"gemini json", "/v1beta/models/gpt-4o:streamGenerateContent", `{"contents": [{"parts": [{"text": "hi"}]}]}`, `"error":`, []string{"STOP", "usageMetadata"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(tt.body))
+			req.Header.Set("Authorization", "Bearer test-token")
+			rec := httptest.NewRecorder()
+			Handler(rec, req)
+
+			body := rec.Body.String()
+			if !strings.Contains(body, "Hello") {
+				t.Errorf("body = %s, want the tokens received before the cut", body)
+			}
+			if !strings.Contains(body, tt.wantError) {
+				t.Errorf("body = %s, want an error containing %s", body, tt.wantError)
+			}
+			for _, marker := range tt.normalEnd {
+				if strings.Contains(body, marker) {
+					t.Errorf("body = %s, should not contain %s after a failed upstream read", body, marker)
+				}
+			}
+			if strings.HasSuffix(tt.name, "json") {
+				var chunks []map[string]interface{}
+				if err := json.Unmarshal(rec.Body.Bytes(), &chunks); err != nil {
+					t.Errorf("JSON array stream is not valid JSON after the error: %v", err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,244 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"you2api/youclient"
+)
+
+// jsonRepairRetries 是结构化输出校验失败后重新询问模型的次数，可通过 JSON_REPAIR_RETRIES 配置。
+var jsonRepairRetries = 2
+
+func init() {
+	if value := os.Getenv("JSON_REPAIR_RETRIES"); value != "" {
+		if retries, err := strconv.Atoi(value); err == nil && retries >= 0 {
+			jsonRepairRetries = retries
+		}
+	}
+}
+
+// ResponseFormat 定义了 OpenAI 请求中的 response_format。
+type ResponseFormat struct {
+	Type       string            `json:"type"` // text、json_object 或 json_schema
+	JSONSchema *JSONSchemaFormat `json:"json_schema,omitempty"`
+}
+
+// JSONSchemaFormat 定义了 json_schema 类型的 response_format 内容。
+type JSONSchemaFormat struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	Schema      json.RawMessage `json:"schema"`
+	Strict      bool            `json:"strict,omitempty"`
+}
+
+// structuredOutput 保存本次请求的结构化输出要求。
+type structuredOutput struct {
+	format    ResponseFormat
+	schema    interface{} // 已解析的 JSON Schema，json_object 模式下为 nil
+	schemaRaw string
+}
+
+// prepareStructuredOutput 根据 response_format 在消息前插入输出要求，未启用时返回 nil。
+func prepareStructuredOutput(req *OpenAIRequest) (*structuredOutput, error) {
+	if req.ResponseFormat == nil {
+		return nil, nil
+	}
+
+	output := &structuredOutput{format: *req.ResponseFormat}
+	switch req.ResponseFormat.Type {
+	case "", "text":
+		return nil, nil
+	case "json_object":
+	case "json_schema":
+		if req.ResponseFormat.JSONSchema == nil || len(req.ResponseFormat.JSONSchema.Schema) == 0 {
+			return nil, fmt.Errorf("response_format.json_schema.schema is required")
+		}
+		schema, err := parseSchema(req.ResponseFormat.JSONSchema.Schema)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid response_format.json_schema.schema: %v", err)
+		}
+		output.schema = schema
+		output.schemaRaw = string(req.ResponseFormat.JSONSchema.Schema)
+	default:
+		return nil, fmt.Errorf("Unsupported response_format type: %s", req.ResponseFormat.Type)
+	}
+
+	req.Messages = append([]Message{{Role: "system", Content: output.prompt()}}, req.Messages...)
+	return output, nil
+}
+
+// prompt 生成要求模型输出 JSON 的提示词。
+func (o *structuredOutput) prompt() string {
+	var b strings.Builder
+	b.WriteString("请只输出一个合法的 JSON 值，不要使用 Markdown 代码块，也不要输出任何解释文字。")
+	if o.schema == nil {
+		b.WriteString("输出必须是一个 JSON 对象。")
+		return b.String()
+	}
+	b.WriteString("输出必须严格符合以下 JSON Schema")
+	if o.format.JSONSchema.Name != "" {
+		fmt.Fprintf(&b, "（%s）", o.format.JSONSchema.Name)
+	}
+	b.WriteString("：\n")
+	b.WriteString(o.schemaRaw)
+	if o.format.JSONSchema.Description != "" {
+		fmt.Fprintf(&b, "\n说明：%s", o.format.JSONSchema.Description)
+	}
+	return b.String()
+}
+
+// validate 从模型输出中提取 JSON 并校验，返回规范化后的 JSON 文本与错误列表。
+func (o *structuredOutput) validate(text string) (string, []string) {
+	candidate := extractJSON(text)
+
+	var value interface{}
+	if err := json.Unmarshal([]byte(candidate), &value); err != nil {
+		return "", []string{fmt.Sprintf("输出不是合法的 JSON: %v", err)}
+	}
+
+	var errs []string
+	if o.schema == nil {
+		if _, ok := value.(map[string]interface{}); !ok {
+			errs = append(errs, "输出必须是一个 JSON 对象")
+		}
+	} else {
+		errs = validateJSONSchema(o.schema, value)
+	}
+	if len(errs) > 0 {
+		return "", errs
+	}
+	return candidate, nil
+}
+
+// repairPrompt 生成要求模型根据校验错误修正输出的消息。
+func (o *structuredOutput) repairPrompt(errs []string) string {
+	return "上一次的输出不符合要求，错误如下：\n- " + strings.Join(errs, "\n- ") +
+		"\n请修正后重新输出完整的 JSON，只输出 JSON 本身。"
+}
+
+// extractJSON 去除 Markdown 代码块等包装，尽量提取出 JSON 文本。
+func extractJSON(text string) string {
+	text = strings.TrimSpace(text)
+	if strings.HasPrefix(text, "```") {
+		text = strings.TrimPrefix(text, "```json")
+		text = strings.TrimPrefix(text, "```")
+		if end := strings.LastIndex(text, "```"); end >= 0 {
+			text = text[:end]
+		}
+		text = strings.TrimSpace(text)
+	}
+	if json.Valid([]byte(text)) {
+		return text
+	}
+
+	// 截取第一个 { 或 [ 到与之对应的最后一个 } 或 ]
+	start := strings.IndexAny(text, "{[")
+	if start < 0 {
+		return text
+	}
+	closer := "}"
+	if text[start] == '[' {
+		closer = "]"
+	}
+	end := strings.LastIndex(text, closer)
+	if end <= start {
+		return text
+	}
+	return text[start : end+1]
+}
+
+// handleStructuredResponse 聚合模型输出并按 response_format 校验，校验失败时带上错误信息
+// 重新询问模型，最多重试 jsonRepairRetries 次。
+func handleStructuredResponse(w http.ResponseWriter, events *youclient.Stream, client *http.Client, openAIReq OpenAIRequest, structured *structuredOutput, rc *requestContext) {
+	messages := openAIReq.Messages
+	var message Message
+	var finishReason string
+	var answer youAnswer
+
+	for attempt := 0; ; attempt++ {
+		var err error
+		answer, err = collectYouResponse(events, rc)
+		if err != nil {
+			if rc.canceled(stageStream) {
+				return
+			}
+			writeOpenAIError(w, http.StatusInternalServerError, "api_error", "", err.Error())
+			return
+		}
+		text := answer.Text
+
+		message, finishReason = assistantMessage(text, answer.FinishReason, rc.tools)
+		if finishReason != "stop" {
+			break // 工具调用或被长度截断的输出不做 JSON 校验
+		}
+
+		cleaned, errs := structured.validate(text)
+		if len(errs) == 0 {
+			message.Content = cleaned
+			break
+		}
+
+		fmt.Printf("结构化输出校验失败（第 %d 次）: %v\n", attempt+1, errs)
+		if attempt >= jsonRepairRetries {
+			writeOpenAIError(w, http.StatusBadGateway, "api_error", "invalid_json_output",
+				fmt.Sprintf("Model output failed response_format validation after %d attempts: %s", attempt+1, strings.Join(errs, "; ")))
+			return
+		}
+
+		// 带上错误的输出与校验错误，重新询问模型
+		messages = append(messages,
+			Message{Role: "assistant", Content: text},
+			Message{Role: "user", Content: structured.repairPrompt(errs)},
+		)
+		youReq, err := buildYouRequest(rc.ctx, rc.dsToken, rc.model, messages, rc.you)
+		if err != nil {
+			if rc.canceled(stagePrepare) {
+				return
+			}
+			writeOpenAIError(w, http.StatusInternalServerError, "api_error", "", err.Error())
+			return
+		}
+		events, err = openYouStream(rc.account, client, youReq)
+		if err != nil {
+			if rc.canceled(stageRequest) {
+				return
+			}
+			writeUpstreamError(w, err)
+			return
+		}
+	}
+
+	usage := rc.recordUsage(answer.Reasoning + completionText(message))
+	rc.reasoning.apply(&message, answer.Reasoning)
+	citations := citationURLs(answer.Sources) // JSON 输出中的方括号不是引用标记，只返回 citations
+	if !openAIReq.Stream {
+		writeChatCompletion(w, message, finishReason, usage, citations, rc)
+		return
+	}
+
+	stream := newChatStreamWriter(w, rc)
+	stream.start()
+	if message.ReasoningContent != "" {
+		stream.write(Delta{ReasoningContent: message.ReasoningContent})
+	}
+	if message.Content != "" {
+		stream.write(Delta{Content: message.Content})
+	}
+	for i, call := range message.ToolCalls {
+		stream.write(Delta{ToolCalls: []ToolCallDelta{{
+			Index:    i,
+			ID:       call.ID,
+			Type:     call.Type,
+			Function: call.Function,
+		}}})
+	}
+	if len(citations) > 0 {
+		stream.annotate(nil, citations)
+	}
+	stream.finish(finishReason, usage)
+}
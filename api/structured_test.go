@@ -0,0 +1,85 @@
+package handler
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// useJSONRepairRetries 设置 jsonRepairRetries，测试结束后恢复。
+func useJSONRepairRetries(t *testing.T, retries int) {
+	t.Helper()
+	previous := jsonRepairRetries
+	jsonRepairRetries = retries
+	t.Cleanup(func() { jsonRepairRetries = previous })
+}
+
+const structuredBody = `{"model": "gpt-4o", "messages": [{"role": "user", "content": "give me a person"}],
+	"response_format": {"type": "json_schema", "json_schema": {"name": "person", "schema": {
+		"type": "object", "properties": {"name": {"type": "string"}}, "required": ["name"]}}}}`
+
+func TestStructuredOutputRepair(t *testing.T) {
+	useJSONRepairRetries(t, 2)
+	var mu sync.Mutex
+	var queries []string
+	answers := []string{`{"age": 3}`, "```json\n{\"name\": \"Ann\"}\n```"}
+	newFakeYou(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		queries = append(queries, r.URL.Query().Get("q"))
+		answer := answers[len(queries)-1]
+		mu.Unlock()
+		io.WriteString(w, youTokens(answer))
+	})
+
+	rec := httptest.NewRecorder()
+	Handler(rec, chatRequest(structuredBody))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+	if len(queries) != 2 {
+		t.Fatalf("upstream received %d requests, want the original and one repair", len(queries))
+	}
+	// 第二次请求的问题是带有校验错误的修正提示
+	if !strings.Contains(queries[1], "上一次的输出不符合要求") || !strings.Contains(queries[1], `缺少必需字段 "name"`) {
+		t.Errorf("repair query = %q, want the repair prompt with the validation error", queries[1])
+	}
+
+	var response OpenAIResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatalf("invalid response: %v", err)
+	}
+	if len(response.Choices) != 1 || response.Choices[0].Message.Content != `{"name": "Ann"}` {
+		t.Errorf("choices = %+v, want the repaired JSON without the code fence", response.Choices)
+	}
+}
+
+func TestStructuredOutputRepairExhausted(t *testing.T) {
+	useJSONRepairRetries(t, 1)
+	searches := 0
+	newFakeYou(t, func(w http.ResponseWriter, r *http.Request) {
+		searches++
+		io.WriteString(w, youTokens("not json"))
+	})
+
+	rec := httptest.NewRecorder()
+	Handler(rec, chatRequest(structuredBody))
+	if searches != 2 {
+		t.Errorf("upstream received %d requests, want 1 + JSON_REPAIR_RETRIES", searches)
+	}
+	if rec.Code != http.StatusBadGateway {
+		t.Fatalf("status = %d, want 502: %s", rec.Code, rec.Body.String())
+	}
+	var body struct {
+		Error OpenAIError `json:"error"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("invalid error body: %v", err)
+	}
+	if body.Error.Code == nil || *body.Error.Code != "invalid_json_output" || !strings.Contains(body.Error.Message, "after 2 attempts") {
+		t.Errorf("error = %+v, want invalid_json_output after 2 attempts", body.Error)
+	}
+}
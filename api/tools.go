@@ -0,0 +1,275 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// 模型输出工具调用时使用的标记
+const (
+	toolCallsOpenTag  = "<tool_calls>"
+	toolCallsCloseTag = "</tool_calls>"
+)
+
+// Tool 定义了 OpenAI 请求中 tools 数组的单个元素。
+type Tool struct {
+	Type     string       `json:"type"`
+	Function ToolFunction `json:"function"`
+}
+
+// ToolFunction 定义了可调用函数的名称、描述与 JSON Schema 参数。
+type ToolFunction struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	Parameters  json.RawMessage `json:"parameters,omitempty"`
+}
+
+// ToolCall 定义了助手消息中的单个工具调用。
+type ToolCall struct {
+	ID       string           `json:"id"`
+	Type     string           `json:"type"`
+	Function ToolCallFunction `json:"function"`
+}
+
+// ToolCallFunction 定义了工具调用的函数名称与 JSON 字符串参数。
+type ToolCallFunction struct {
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"`
+}
+
+// ToolCallDelta 定义了流式响应中 delta.tool_calls 的单个片段。
+type ToolCallDelta struct {
+	Index    int              `json:"index"`
+	ID       string           `json:"id,omitempty"`
+	Type     string           `json:"type,omitempty"`
+	Function ToolCallFunction `json:"function"`
+}
+
+// toolEmulation 保存本次请求的工具调用模拟设置。
+type toolEmulation struct {
+	tools      []Tool
+	forcedName string // tool_choice 指定的函数名
+	required   bool   // tool_choice 为 required 或指定函数
+}
+
+// prepareToolEmulation 将 tools 渲染为提示词，并把 tool 角色消息与助手的 tool_calls
+// 转换为普通文本消息，以便构建 ChatEntry 历史。未启用工具时返回 nil。
+func prepareToolEmulation(req *OpenAIRequest) *toolEmulation {
+	req.Messages = renderToolMessages(req.Messages)
+
+	if len(req.Tools) == 0 {
+		return nil
+	}
+
+	emulation := &toolEmulation{tools: req.Tools}
+	var choice string
+	if err := json.Unmarshal(req.ToolChoice, &choice); err == nil {
+		switch choice {
+		case "none":
+			return nil
+		case "required":
+			emulation.required = true
+		}
+	} else {
+		var named struct {
+			Function struct {
+				Name string `json:"name"`
+			} `json:"function"`
+		}
+		if err := json.Unmarshal(req.ToolChoice, &named); err == nil && named.Function.Name != "" {
+			emulation.forcedName = named.Function.Name
+			emulation.required = true
+		}
+	}
+
+	// 工具说明放在最前面，由 convertSystemToUser 与其它 system 消息合并
+	req.Messages = append([]Message{{Role: "system", Content: emulation.prompt()}}, req.Messages...)
+	return emulation
+}
+
+// prompt 生成描述可用工具及调用格式的提示词。
+func (t *toolEmulation) prompt() string {
+	var b strings.Builder
+	b.WriteString("你可以调用以下工具（JSON Schema 描述参数）：\n")
+	for _, tool := range t.tools {
+		fmt.Fprintf(&b, "- %s", tool.Function.Name)
+		if tool.Function.Description != "" {
+			fmt.Fprintf(&b, "：%s", tool.Function.Description)
+		}
+		if len(tool.Function.Parameters) > 0 {
+			fmt.Fprintf(&b, "\n  参数：%s", string(tool.Function.Parameters))
+		}
+		b.WriteString("\n")
+	}
+	b.WriteString("\n需要调用工具时，只输出如下格式，不要输出其它内容：\n")
+	b.WriteString(toolCallsOpenTag + "\n")
+	b.WriteString(`[{"name": "工具名称", "arguments": {"参数名": "参数值"}}]` + "\n")
+	b.WriteString(toolCallsCloseTag + "\n")
+	b.WriteString("可以在数组中同时调用多个工具。工具结果会在后续消息中提供。")
+	switch {
+	case t.forcedName != "":
+		fmt.Fprintf(&b, "\n本次回复必须调用工具 %s。", t.forcedName)
+	case t.required:
+		b.WriteString("\n本次回复必须调用至少一个工具。")
+	default:
+		b.WriteString("\n如果不需要工具，直接回答。")
+	}
+	return b.String()
+}
+
+// renderToolMessages 将 tool 角色消息转换为 user 消息，并把助手的 tool_calls 以
+// 约定的调用格式附加到助手消息中。
+func renderToolMessages(messages []Message) []Message {
+	rendered := make([]Message, 0, len(messages))
+	callNames := make(map[string]string) // tool_call_id -> 函数名
+	for _, msg := range messages {
+		switch {
+		case msg.Role == "tool":
+			name := msg.Name
+			if name == "" {
+				name = callNames[msg.ToolCallID]
+			}
+			rendered = append(rendered, Message{
+				Role:    "user",
+				Content: fmt.Sprintf("工具 %s 的调用结果（%s）：\n%s", name, msg.ToolCallID, msg.Content),
+			})
+		case msg.Role == "assistant" && len(msg.ToolCalls) > 0:
+			calls := make([]map[string]interface{}, 0, len(msg.ToolCalls))
+			for _, call := range msg.ToolCalls {
+				callNames[call.ID] = call.Function.Name
+				var arguments interface{} = call.Function.Arguments
+				if json.Valid([]byte(call.Function.Arguments)) {
+					arguments = json.RawMessage(call.Function.Arguments)
+				}
+				calls = append(calls, map[string]interface{}{"name": call.Function.Name, "arguments": arguments})
+			}
+			callsJSON, _ := json.Marshal(calls)
+			content := strings.TrimSpace(msg.Content + "\n" + toolCallsOpenTag + "\n" + string(callsJSON) + "\n" + toolCallsCloseTag)
+			rendered = append(rendered, Message{Role: "assistant", Content: content, Attachments: msg.Attachments})
+		default:
+			rendered = append(rendered, msg)
+		}
+	}
+	return rendered
+}
+
+// parseToolCalls 从模型输出中解析工具调用块，返回块之前的文本与解析出的调用。
+// 没有合法调用时 ok 为 false。
+func (t *toolEmulation) parseToolCalls(text string) (content string, calls []ToolCall, ok bool) {
+	start := strings.Index(text, toolCallsOpenTag)
+	if start < 0 {
+		return text, nil, false
+	}
+	body := text[start+len(toolCallsOpenTag):]
+	if end := strings.Index(body, toolCallsCloseTag); end >= 0 {
+		body = body[:end]
+	}
+
+	// 容忍模型在块内包裹 ```json 代码块
+	body = strings.TrimSpace(body)
+	body = strings.TrimPrefix(body, "```json")
+	body = strings.TrimPrefix(body, "```")
+	body = strings.TrimSuffix(body, "```")
+	body = strings.TrimSpace(body)
+
+	var rawCalls []struct {
+		Name      string          `json:"name"`
+		Arguments json.RawMessage `json:"arguments"`
+	}
+	if err := json.Unmarshal([]byte(body), &rawCalls); err != nil {
+		// 也接受单个对象
+		var single struct {
+			Name      string          `json:"name"`
+			Arguments json.RawMessage `json:"arguments"`
+		}
+		if err := json.Unmarshal([]byte(body), &single); err != nil {
+			return text, nil, false
+		}
+		rawCalls = append(rawCalls, single)
+	}
+
+	for _, raw := range rawCalls {
+		if !t.hasTool(raw.Name) {
+			continue
+		}
+		arguments := "{}"
+		var argumentString string
+		if err := json.Unmarshal(raw.Arguments, &argumentString); err == nil {
+			arguments = argumentString
+		} else if len(raw.Arguments) > 0 && string(raw.Arguments) != "null" {
+			arguments = string(raw.Arguments)
+		}
+		calls = append(calls, ToolCall{
+			ID:       "call_" + strings.ReplaceAll(uuid.New().String(), "-", "")[:24],
+			Type:     "function",
+			Function: ToolCallFunction{Name: raw.Name, Arguments: arguments},
+		})
+	}
+	if len(calls) == 0 {
+		return text, nil, false
+	}
+	return strings.TrimSpace(text[:start]), calls, true
+}
+
+// hasTool 检查请求中是否声明了该工具。
+func (t *toolEmulation) hasTool(name string) bool {
+	for _, tool := range t.tools {
+		if tool.Function.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// toolStreamFilter 在流式输出中拦截工具调用块：块之前的文本照常输出，
+// 可能是起始标记前缀的文本会被暂存，进入块之后的内容全部缓存直到结束。
+type toolStreamFilter struct {
+	pending  strings.Builder // 暂存的、可能构成起始标记的文本
+	captured strings.Builder // 起始标记之后的全部文本
+	inBlock  bool
+}
+
+// push 处理一个 token，返回可以立即作为 content 输出的文本。
+func (f *toolStreamFilter) push(token string) string {
+	if f.inBlock {
+		f.captured.WriteString(token)
+		return ""
+	}
+
+	f.pending.WriteString(token)
+	text := f.pending.String()
+	if idx := strings.Index(text, toolCallsOpenTag); idx >= 0 {
+		f.inBlock = true
+		f.captured.WriteString(text[idx:])
+		f.pending.Reset()
+		return text[:idx]
+	}
+
+	// 保留可能是起始标记前缀的结尾部分
+	keep := partialSuffixLen(text, toolCallsOpenTag)
+	f.pending.Reset()
+	f.pending.WriteString(text[len(text)-keep:])
+	return text[:len(text)-keep]
+}
+
+// flush 在上游结束时调用，返回仍未输出的文本以及捕获到的工具调用块。
+func (f *toolStreamFilter) flush() (text string, block string) {
+	return f.pending.String(), f.captured.String()
+}
+
+// partialSuffixLen 返回 text 结尾与 marker 前缀重叠的最大长度。
+func partialSuffixLen(text, marker string) int {
+	max := len(marker) - 1
+	if max > len(text) {
+		max = len(text)
+	}
+	for n := max; n > 0; n-- {
+		if strings.HasSuffix(text, marker[:n]) {
+			return n
+		}
+	}
+	return 0
+}
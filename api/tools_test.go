@@ -0,0 +1,247 @@
+package handler
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// weatherTools 是测试使用的工具模拟设置，只声明了 get_weather 与 get_time。
+var weatherTools = &toolEmulation{tools: []Tool{
+	{Type: "function", Function: ToolFunction{Name: "get_weather"}},
+	{Type: "function", Function: ToolFunction{Name: "get_time"}},
+}}
+
+func TestParseToolCalls(t *testing.T) {
+	tests := []struct {
+		name        string
+		text        string
+		wantOK      bool
+		wantContent string
+		wantCalls   []ToolCallFunction
+	}{
+		{
+			name:        "array",
+			text:        "Let me check.\n<tool_calls>\n[{\"name\": \"get_weather\", \"arguments\": {\"city\": \"Paris\"}}, {\"name\": \"get_time\", \"arguments\": {}}]\n</tool_calls>",
+			wantOK:      true,
+			wantContent: "Let me check.",
+			wantCalls:   []ToolCallFunction{{Name: "get_weather", Arguments: `{"city": "Paris"}`}, {Name: "get_time", Arguments: "{}"}},
+		},
+		{
+			name:      "fenced json block",
+			text:      "<tool_calls>\n```json\n[{\"name\": \"get_weather\", \"arguments\": {\"city\": \"Paris\"}}]\n```\n</tool_calls>",
+			wantOK:    true,
+			wantCalls: []ToolCallFunction{{Name: "get_weather", Arguments: `{"city": "Paris"}`}},
+		},
+		{
+			name:      "single object",
+			text:      `<tool_calls>{"name": "get_weather", "arguments": {"city": "Paris"}}</tool_calls>`,
+			wantOK:    true,
+			wantCalls: []ToolCallFunction{{Name: "get_weather", Arguments: `{"city": "Paris"}`}},
+		},
+		{
+			name:      "string arguments",
+			text:      `<tool_calls>[{"name": "get_weather", "arguments": "{\"city\": \"Paris\"}"}]</tool_calls>`,
+			wantOK:    true,
+			wantCalls: []ToolCallFunction{{Name: "get_weather", Arguments: `{"city": "Paris"}`}},
+		},
+		{
+			name:      "unknown tool skipped",
+			text:      `<tool_calls>[{"name": "rm_rf", "arguments": {}}, {"name": "get_time"}]</tool_calls>`,
+			wantOK:    true,
+			wantCalls: []ToolCallFunction{{Name: "get_time", Arguments: "{}"}},
+		},
+		{
+			name:        "only unknown tools",
+			text:        `<tool_calls>[{"name": "rm_rf", "arguments": {}}]</tool_calls>`,
+			wantContent: `<tool_calls>[{"name": "rm_rf", "arguments": {}}]</tool_calls>`,
+		},
+		{
+			name:      "no closing tag",
+			text:      `<tool_calls>[{"name": "get_time", "arguments": {}}]`,
+			wantOK:    true,
+			wantCalls: []ToolCallFunction{{Name: "get_time", Arguments: "{}"}},
+		},
+		{
+			name:        "truncated without closing tag",
+			text:        `<tool_calls>[{"name": "get_time", "argu`,
+			wantContent: `<tool_calls>[{"name": "get_time", "argu`,
+		},
+		{
+			name:        "no tag",
+			text:        "It is sunny.",
+			wantContent: "It is sunny.",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			content, calls, ok := weatherTools.parseToolCalls(tt.text)
+			if ok != tt.wantOK || content != tt.wantContent {
+				t.Fatalf("parseToolCalls() = %q, %v, %v, want %q, %v", content, calls, ok, tt.wantContent, tt.wantOK)
+			}
+			if len(calls) != len(tt.wantCalls) {
+				t.Fatalf("calls = %+v, want %+v", calls, tt.wantCalls)
+			}
+			for i, want := range tt.wantCalls {
+				if calls[i].Function != want || calls[i].Type != "function" || !strings.HasPrefix(calls[i].ID, "call_") {
+					t.Errorf("calls[%d] = %+v, want function %+v", i, calls[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestToolStreamFilter(t *testing.T) {
+	tests := []struct {
+		name      string
+		tokens    []string
+		wantOut   string // push 立即输出的文本
+		wantText  string // flush 返回的暂存文本
+		wantBlock string // flush 返回的工具调用块
+	}{
+		{
+			name:    "plain text",
+			tokens:  []string{"Hello", " world"},
+			wantOut: "Hello world",
+		},
+		{
+			name:      "opening tag split across tokens",
+			tokens:    []string{"Sure.", "<tool", "_ca", "lls>[{\"name\":", " \"get_time\"}]", "</tool_calls>"},
+			wantOut:   "Sure.",
+			wantBlock: `<tool_calls>[{"name": "get_time"}]</tool_calls>`,
+		},
+		{
+			name:     "prefix held until the stream ends",
+			tokens:   []string{"a < b", " and x <to"},
+			wantOut:  "a < b and x ",
+			wantText: "<to",
+		},
+		{
+			name:    "prefix that turns out to be text",
+			tokens:  []string{"<tool", "box>"},
+			wantOut: "<toolbox>",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var filter toolStreamFilter
+			var out strings.Builder
+			for _, token := range tt.tokens {
+				out.WriteString(filter.push(token))
+			}
+			text, block := filter.flush()
+			if out.String() != tt.wantOut || text != tt.wantText || block != tt.wantBlock {
+				t.Errorf("out = %q, flush() = %q, %q, want %q, %q, %q", out.String(), text, block, tt.wantOut, tt.wantText, tt.wantBlock)
+			}
+		})
+	}
+}
+
+func TestPartialSuffixLen(t *testing.T) {
+	tests := []struct {
+		text string
+		want int
+	}{
+		{"", 0},
+		{"hello", 0},
+		{"hello <", 1},
+		{"hello <tool_call", 10},
+		{"<tool_calls", 11},
+		{"<tool_calls>", 0}, // 完整标记由调用方处理
+		{"tool_calls", 0},
+	}
+	for _, tt := range tests {
+		if got := partialSuffixLen(tt.text, toolCallsOpenTag); got != tt.want {
+			t.Errorf("partialSuffixLen(%q) = %d, want %d", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestRenderToolMessages(t *testing.T) {
+	messages := []Message{
+		{Role: "user", Content: "Weather in Paris?"},
+		{Role: "assistant", ToolCalls: []ToolCall{{
+			ID:       "call_1",
+			Type:     "function",
+			Function: ToolCallFunction{Name: "get_weather", Arguments: `{"city":"Paris"}`},
+		}}},
+		{Role: "tool", ToolCallID: "call_1", Content: "sunny"},
+	}
+	rendered := renderToolMessages(messages)
+	if len(rendered) != 3 {
+		t.Fatalf("rendered %d messages, want 3: %+v", len(rendered), rendered)
+	}
+	if rendered[0].Role != "user" || rendered[0].Content != messages[0].Content {
+		t.Errorf("user message changed: %+v", rendered[0])
+	}
+
+	// 渲染后的助手消息能被原样解析回相同的调用
+	assistant := rendered[1]
+	if assistant.Role != "assistant" || len(assistant.ToolCalls) != 0 {
+		t.Errorf("assistant message = %+v, want plain text", assistant)
+	}
+	_, calls, ok := weatherTools.parseToolCalls(assistant.Content)
+	if !ok || len(calls) != 1 || calls[0].Function.Name != "get_weather" || calls[0].Function.Arguments != `{"city":"Paris"}` {
+		t.Errorf("round-trip of %q = %+v, %v", assistant.Content, calls, ok)
+	}
+
+	// tool 消息转换为 user 消息，函数名来自对应的 tool_call_id
+	result := rendered[2]
+	if result.Role != "user" || !strings.Contains(result.Content, "get_weather") || !strings.Contains(result.Content, "call_1") || !strings.Contains(result.Content, "sunny") {
+		t.Errorf("tool result message = %+v", result)
+	}
+}
+
+func TestHandlerStreamingToolCalls(t *testing.T) {
+	newFakeYou(t, func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, youTokens("Checking.", "<tool", "_calls>[{\"name\": \"get_weather\", ", "\"arguments\": {\"city\": \"Paris\"}}]</tool_calls>"))
+	})
+
+	body := `{"model": "gpt-4o", "stream": true, "messages": [{"role": "user", "content": "Weather in Paris?"}],
+		"tools": [{"type": "function", "function": {"name": "get_weather", "parameters": {"type": "object"}}}]}`
+	rec := httptest.NewRecorder()
+	Handler(rec, chatRequest(body))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var content, name, arguments, finishReason string
+	var id string
+	for _, event := range strings.Split(strings.TrimSpace(rec.Body.String()), "\n\n") {
+		if event == "data: [DONE]" {
+			continue
+		}
+		var chunk OpenAIStreamResponse
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(event, "data: ")), &chunk); err != nil {
+			t.Fatalf("invalid chunk %q: %v", event, err)
+		}
+		for _, choice := range chunk.Choices {
+			content += choice.Delta.Content
+			for _, call := range choice.Delta.ToolCalls {
+				if call.Index != 0 {
+					t.Errorf("tool call index = %d, want 0", call.Index)
+				}
+				if call.ID != "" {
+					id = call.ID
+				}
+				name += call.Function.Name
+				arguments += call.Function.Arguments
+			}
+			if choice.FinishReason != nil {
+				finishReason = *choice.FinishReason
+			}
+		}
+	}
+	if content != "Checking." {
+		t.Errorf("content = %q, want the text before the tool call block", content)
+	}
+	if id == "" || name != "get_weather" || arguments != `{"city": "Paris"}` {
+		t.Errorf("tool call = id %q, name %q, arguments %q", id, name, arguments)
+	}
+	if finishReason != "tool_calls" {
+		t.Errorf("finish_reason = %q, want tool_calls", finishReason)
+	}
+}
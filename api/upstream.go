@@ -0,0 +1,70 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"you2api/metrics"
+	"you2api/youclient"
+)
+
+// youBaseURL 是 You.com 上游地址，可通过环境变量 YOU_BASE_URL 指向代理或本地替身服务器。
+var youBaseURL = youclient.DefaultBaseURL
+
+func init() {
+	if value := strings.TrimSpace(os.Getenv("YOU_BASE_URL")); value != "" {
+		youBaseURL = value
+	}
+}
+
+// newYouClient 创建使用 dsToken 访问 youBaseURL 的客户端。
+func newYouClient(dsToken string, opts ...youclient.Option) *youclient.Client {
+	return youclient.New(dsToken, append([]youclient.Option{youclient.WithBaseURL(youBaseURL)}, opts...)...)
+}
+
+// upstreamHeaderTimeout 是等待上游返回响应头的最长时间。
+const upstreamHeaderTimeout = 60 * time.Second
+
+// upstreamTransport 是所有上游请求共用的连接池。
+var upstreamTransport = func() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ResponseHeaderTimeout = upstreamHeaderTimeout
+	return transport
+}()
+
+// newUpstreamClient 创建上游请求使用的 http.Client。流式请求传入 0 表示不限制总时长，
+// 此时只靠响应头超时与客户端断开时取消的请求 context 结束请求。
+func newUpstreamClient(timeout time.Duration) *http.Client {
+	return &http.Client{Transport: upstreamTransport, Timeout: timeout}
+}
+
+// upstreamTimeoutFor 返回上游请求的总超时：非流式请求为 upstreamTimeout，避免上游缓慢输出时
+// 一直占用请求与账号；流式请求返回 0，即不限制总时长。
+func upstreamTimeoutFor(stream bool) time.Duration {
+	if stream {
+		return 0
+	}
+	return upstreamTimeout
+}
+
+// 上游请求被取消时所处的阶段。
+const (
+	stagePrepare = "prepare" // 获取 nonce、上传文件等准备阶段
+	stageRequest = "request" // 等待上游响应
+	stageStream  = "stream"  // 读取上游事件流
+)
+
+// clientCanceled 报告 ctx 是否已因客户端断开而取消，是则记录日志与指标。
+// 返回 true 时调用方应直接返回，不再写入响应。
+func clientCanceled(ctx context.Context, endpoint, stage string) bool {
+	if ctx.Err() == nil {
+		return false
+	}
+	fmt.Printf("客户端已断开，取消上游请求: endpoint=%s, stage=%s\n", endpoint, stage)
+	metrics.RecordCancellation(endpoint, stage)
+	return true
+}
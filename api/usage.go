@@ -0,0 +1,43 @@
+package handler
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+
+	"you2api/metrics"
+)
+
+// keyLabel 返回用于指标标签的 DS token 摘要，避免在指标中暴露原始 token。
+func keyLabel(dsToken string) string {
+	sum := sha256.Sum256([]byte(dsToken))
+	return "ds-" + hex.EncodeToString(sum[:])[:8]
+}
+
+// directUsageLabel 是未使用本地 API key 的请求在 token 指标中的 key 与 account 标签。
+// 客户端直接提供的 DS token 数量不受限制，不能各自成为一个标签值。
+const directUsageLabel = "direct"
+
+// recordUsage 将一次请求的 token 用量按客户端密钥、上游账号与 OpenAI 模型名称计入指标，
+// 并计入 API key 与账号的每日 token 限额。key 标签只使用已配置 API key 的标签，
+// 其他请求统一为 directUsageLabel；直接使用 DS token 时 account 标签同样为 directUsageLabel。
+func recordUsage(account *upstreamAccount, model string, promptTokens, completionTokens int) {
+	key := account.label()
+	if key == "" {
+		key = directUsageLabel
+	}
+	name := directUsageLabel
+	if account.lease != nil {
+		name = account.name()
+	}
+	metrics.RecordTokenUsage(key, name, reverseMapModelName(mapModelName(model)), promptTokens, completionTokens)
+	account.addUsage(promptTokens + completionTokens)
+}
+
+// newTokenCount 根据 prompt 与 completion token 数构建 TokenCount。
+func newTokenCount(promptTokens, completionTokens int) TokenCount {
+	return TokenCount{
+		PromptTokens:     promptTokens,
+		CompletionTokens: completionTokens,
+		TotalTokens:      promptTokens + completionTokens,
+	}
+}
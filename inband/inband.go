@@ -0,0 +1,130 @@
+// Package inband 识别上游以 HTTP 200 返回、但内容实际上是额度用尽、登录失效提示或空回答的响应。
+// You.com 有时把这类提示当作普通的 youChatToken 文本输出，客户端会误以为是正常回答。
+package inband
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Kind 是识别出的问题类型。
+type Kind string
+
+const (
+	KindQuota Kind = "quota" // 额度用尽或被限流
+	KindAuth  Kind = "auth"  // 登录失效或 token 无效
+	KindEmpty Kind = "empty" // 没有任何回答内容
+)
+
+// DefaultMaxChars 是默认只检查的回答长度（字符数），更长的回答视为正常回答，避免误判。
+const DefaultMaxChars = 300
+
+// 默认的识别规则（不区分大小写），只匹配 You.com 自己的额度与登录提示，且必须匹配完整回答，
+// 避免把提到 401、429、会话过期等字眼的正常回答误判为错误。
+var (
+	DefaultQuotaPattern = `you(?:'ve| have) (?:reached|hit|used up|exceeded) (?:your|the) [\w ]*?(?:limit|quota)[^.!\n]*[.!]?` +
+		`(?:\s*(?:please )?upgrade to (?:you\.com )?(?:you)?pro[^.!\n]*[.!]?)?`
+	DefaultAuthPattern = `(?:please )?(?:log ?in|sign ?in)(?: again)? to (?:continue|use you\.com)[^.!\n]*[.!]?` +
+		`|your session has expired[^.!\n]*[.!]?(?:\s*please (?:log ?in|sign ?in)(?: again)?[^.!\n]*[.!]?)?`
+)
+
+// Detector 检查完整的短回答是否为额度、鉴权提示或空回答。
+type Detector struct {
+	MaxChars int            // 只检查不超过该长度的回答
+	Quota    *regexp.Regexp // 须匹配完整回答（见 Compile），为 nil 时不检查额度提示
+	Auth     *regexp.Regexp // 须匹配完整回答（见 Compile），为 nil 时不检查鉴权提示
+	Empty    bool           // 是否把空回答视为错误
+}
+
+// Compile 编译不区分大小写、必须匹配完整回答的识别规则。
+func Compile(pattern string) (*regexp.Regexp, error) {
+	return regexp.Compile(anchored(pattern))
+}
+
+func anchored(pattern string) string {
+	return `(?i)^(?:` + pattern + `)$`
+}
+
+// NewDefault 返回使用默认规则的 Detector。
+func NewDefault() *Detector {
+	return &Detector{
+		MaxChars: DefaultMaxChars,
+		Quota:    regexp.MustCompile(anchored(DefaultQuotaPattern)),
+		Auth:     regexp.MustCompile(anchored(DefaultAuthPattern)),
+		Empty:    true,
+	}
+}
+
+// FromEnv 从环境变量读取 Detector，未设置的项使用默认值；INBAND_DETECTION=false 时返回 nil：
+//
+//	INBAND_DETECTION      是否启用，默认 true
+//	INBAND_MAX_CHARS      只检查不超过该长度的回答，默认 300
+//	INBAND_QUOTA_PATTERN  额度提示的正则表达式（不区分大小写，须匹配完整回答），为 "-" 时不检查
+//	INBAND_AUTH_PATTERN   鉴权提示的正则表达式（不区分大小写，须匹配完整回答），为 "-" 时不检查
+//	INBAND_EMPTY          是否把空回答视为错误，默认 true
+func FromEnv() (*Detector, error) {
+	if value := os.Getenv("INBAND_DETECTION"); value != "" {
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("INBAND_DETECTION: %w", err)
+		}
+		if !enabled {
+			return nil, nil
+		}
+	}
+
+	d := NewDefault()
+	if value := os.Getenv("INBAND_MAX_CHARS"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("INBAND_MAX_CHARS: invalid value %q", value)
+		}
+		d.MaxChars = n
+	}
+	for envName, target := range map[string]**regexp.Regexp{
+		"INBAND_QUOTA_PATTERN": &d.Quota,
+		"INBAND_AUTH_PATTERN":  &d.Auth,
+	} {
+		value := os.Getenv(envName)
+		switch value {
+		case "":
+		case "-":
+			*target = nil
+		default:
+			pattern, err := Compile(value)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", envName, err)
+			}
+			*target = pattern
+		}
+	}
+	if value := os.Getenv("INBAND_EMPTY"); value != "" {
+		empty, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("INBAND_EMPTY: %w", err)
+		}
+		d.Empty = empty
+	}
+	return d, nil
+}
+
+// Detect 检查完整的回答文本，返回识别出的问题类型。超过 MaxChars 的回答不检查，
+// 只有整个回答都是额度或鉴权提示时才会被识别。
+func (d *Detector) Detect(text string) (Kind, bool) {
+	text = strings.TrimSpace(text)
+	switch {
+	case text == "":
+		return KindEmpty, d.Empty
+	case utf8.RuneCountInString(text) > d.MaxChars:
+		return "", false
+	case d.Quota != nil && d.Quota.MatchString(text):
+		return KindQuota, true
+	case d.Auth != nil && d.Auth.MatchString(text):
+		return KindAuth, true
+	}
+	return "", false
+}
@@ -0,0 +1,66 @@
+package inband
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDetect(t *testing.T) {
+	d := NewDefault()
+	tests := []struct {
+		text string
+		want Kind
+		ok   bool
+	}{
+		{"", KindEmpty, true},
+		{"  \n", KindEmpty, true},
+		{"You've reached your daily limit. Upgrade to YouPro for unlimited queries.", KindQuota, true},
+		{"You have exceeded the usage quota.", KindQuota, true},
+		{"Please log in to continue.", KindAuth, true},
+		{"Your session has expired. Please sign in again.", KindAuth, true},
+		{"Hello! How can I help you today?", "", false},
+		// 正常回答中提到额度、状态码或会话过期不应被识别
+		{"In Kubernetes you have reached the limit when " + strings.Repeat("pods are pending. ", 30), "", false},
+		{"HTTP 401 means Unauthorized.", "", false},
+		{"A 429 status means Too Many Requests.", "", false},
+		{"The user sees an error because the session has expired and the cookie is gone.", "", false},
+		{"Your session has expired. Refresh the JWT with the refresh token endpoint.", "", false},
+		{"Show a banner saying \"Please log in to continue.\" when the token is invalid.", "", false},
+		{"You've reached your daily limit. Here is how rate limiting works in nginx.", "", false},
+		{"Invalid token", "", false},
+	}
+	for _, tt := range tests {
+		if got, ok := d.Detect(tt.text); got != tt.want || ok != tt.ok {
+			t.Errorf("Detect(%.40q) = %q, %v, want %q, %v", tt.text, got, ok, tt.want, tt.ok)
+		}
+	}
+
+	d.Empty = false
+	d.Quota, _ = Compile("custom limit hit")
+	if _, ok := d.Detect(""); ok {
+		t.Errorf("Detect(empty) with Empty disabled should not match")
+	}
+	if got, ok := d.Detect("Custom LIMIT hit"); got != KindQuota || !ok {
+		t.Errorf("Detect() with custom pattern = %q, %v", got, ok)
+	}
+	if _, ok := d.Detect("The custom limit hit count is 3"); ok {
+		t.Errorf("Detect() with custom pattern matched part of the answer")
+	}
+}
+
+func TestFromEnv(t *testing.T) {
+	t.Setenv("INBAND_MAX_CHARS", "50")
+	t.Setenv("INBAND_AUTH_PATTERN", "-")
+	d, err := FromEnv()
+	if err != nil || d == nil {
+		t.Fatalf("FromEnv() = %v, %v", d, err)
+	}
+	if d.MaxChars != 50 || d.Auth != nil || d.Quota == nil {
+		t.Errorf("FromEnv() = %+v", d)
+	}
+
+	t.Setenv("INBAND_DETECTION", "false")
+	if d, err := FromEnv(); d != nil || err != nil {
+		t.Errorf("FromEnv() with detection disabled = %v, %v, want nil", d, err)
+	}
+}
@@ -0,0 +1,125 @@
+// Package keys 管理本地签发的 API key。每个 key 对应账号池中的一个账号或账号分组，
+// 可以限制允许使用的模型并设置过期时间，客户端因此不需要接触原始的 DS token。
+package keys
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"you2api/ratelimit"
+)
+
+// 查找 key 失败时返回的错误。
+var (
+	ErrInvalidKey = errors.New("invalid API key")
+	ErrExpiredKey = errors.New("API key has expired")
+)
+
+// Key 是一个本地 API key。
+type Key struct {
+	Key       string     `json:"key"`
+	Label     string     `json:"label"`                // 用于日志与指标，默认为 key-N
+	Account   string     `json:"account,omitempty"`    // 只使用账号池中该名称的账号
+	Group     string     `json:"group,omitempty"`      // 只使用账号池中该分组的账号
+	Models    []string   `json:"models,omitempty"`     // 允许的模型（OpenAI 名称、You.com 模型 ID 或 Agent ID），为空时不限
+	ExpiresAt *time.Time `json:"expires_at,omitempty"` // 为空时不过期
+
+	Limits ratelimit.Limits `json:"limits"` // 未设置任何限额时使用 Config.DefaultLimits
+}
+
+// AllowsModel 报告 key 是否允许使用 names 中的任意一个模型名称。
+func (k *Key) AllowsModel(names ...string) bool {
+	if len(k.Models) == 0 {
+		return true
+	}
+	for _, allowed := range k.Models {
+		for _, name := range names {
+			if name != "" && allowed == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Config 定义了 API key 配置文件的结构。
+type Config struct {
+	DefaultLimits ratelimit.Limits `json:"default_limits"`
+	Keys          []Key            `json:"keys"`
+}
+
+// ConfigFromEnv 从 API_KEYS_FILE 指定的 JSON 文件读取配置，未设置时返回 ok=false。
+func ConfigFromEnv() (Config, bool, error) {
+	var cfg Config
+	path := os.Getenv("API_KEYS_FILE")
+	if path == "" {
+		return cfg, false, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, false, fmt.Errorf("读取 API key 配置失败: %w", err)
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return cfg, false, fmt.Errorf("解析 API key 配置失败: %w", err)
+	}
+	return cfg, true, nil
+}
+
+// Store 是只读的 API key 集合，可以并发使用。
+type Store struct {
+	keys map[[sha256.Size]byte]*Key // 按 key 的摘要索引，查找耗时与 key 内容无关
+	now  func() time.Time
+}
+
+// New 根据配置创建 Store，key 不能为空或重复，Account 与 Group 最多设置一个。
+func New(cfg Config) (*Store, error) {
+	s := &Store{keys: make(map[[sha256.Size]byte]*Key), now: time.Now}
+	for i := range cfg.Keys {
+		key := cfg.Keys[i]
+		key.Key = strings.TrimSpace(key.Key)
+		if key.Key == "" {
+			return nil, fmt.Errorf("API key %d is empty", i+1)
+		}
+		if key.Label == "" {
+			key.Label = fmt.Sprintf("key-%d", i+1)
+		}
+		if !key.Limits.Enabled() {
+			key.Limits = cfg.DefaultLimits
+		}
+		if key.Account != "" && key.Group != "" {
+			return nil, fmt.Errorf("API key %q sets both account and group", key.Label)
+		}
+		sum := sha256.Sum256([]byte(key.Key))
+		if _, exists := s.keys[sum]; exists {
+			return nil, fmt.Errorf("duplicate API key %q", key.Label)
+		}
+		s.keys[sum] = &key
+	}
+	return s, nil
+}
+
+// Keys 返回所有 key，用于启动时校验配置。
+func (s *Store) Keys() []*Key {
+	keys := make([]*Key, 0, len(s.keys))
+	for _, key := range s.keys {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// Lookup 查找 key，不存在时返回 ErrInvalidKey，已过期时返回 ErrExpiredKey。
+func (s *Store) Lookup(key string) (*Key, error) {
+	found, ok := s.keys[sha256.Sum256([]byte(key))]
+	if !ok {
+		return nil, ErrInvalidKey
+	}
+	if found.ExpiresAt != nil && !s.now().Before(*found.ExpiresAt) {
+		return nil, ErrExpiredKey
+	}
+	return found, nil
+}
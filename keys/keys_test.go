@@ -0,0 +1,51 @@
+package keys
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestLookup(t *testing.T) {
+	expiry := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	store, err := New(Config{Keys: []Key{
+		{Key: "sk-alice", Label: "alice", Group: "team", Models: []string{"gpt-4o", "claude_3_5_sonnet"}},
+		{Key: "sk-bob", Account: "main", ExpiresAt: &expiry},
+	}})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	store.now = func() time.Time { return expiry.Add(-time.Second) }
+
+	alice, err := store.Lookup("sk-alice")
+	if err != nil || alice.Label != "alice" || alice.Group != "team" {
+		t.Fatalf("Lookup(sk-alice) = %+v, %v", alice, err)
+	}
+	if !alice.AllowsModel("gpt-4o") || !alice.AllowsModel("claude-3.5-sonnet", "claude_3_5_sonnet") || alice.AllowsModel("o1", "openai_o1") {
+		t.Errorf("AllowsModel() does not match the allowlist %v", alice.Models)
+	}
+
+	bob, err := store.Lookup("sk-bob")
+	if err != nil || bob.Label != "key-2" || !bob.AllowsModel("anything") {
+		t.Fatalf("Lookup(sk-bob) = %+v, %v", bob, err)
+	}
+	store.now = func() time.Time { return expiry }
+	if _, err := store.Lookup("sk-bob"); !errors.Is(err, ErrExpiredKey) {
+		t.Errorf("Lookup(expired) error = %v, want ErrExpiredKey", err)
+	}
+	if _, err := store.Lookup("sk-unknown"); !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("Lookup(unknown) error = %v, want ErrInvalidKey", err)
+	}
+}
+
+func TestNewInvalidConfig(t *testing.T) {
+	for _, cfg := range []Config{
+		{Keys: []Key{{Key: " "}}},
+		{Keys: []Key{{Key: "sk-a"}, {Key: "sk-a"}}},
+		{Keys: []Key{{Key: "sk-a", Account: "main", Group: "team"}}},
+	} {
+		if _, err := New(cfg); err == nil {
+			t.Errorf("New(%+v) error = nil, want error", cfg)
+		}
+	}
+}
@@ -12,8 +12,38 @@ var (
 		},
 		[]string{"method", "endpoint", "status"},
 	)
+
+	TokenCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "you2api_tokens_total",
+			Help: "按密钥、上游账号与模型统计的估算 token 用量",
+		},
+		[]string{"key", "account", "model", "type"},
+	)
+
+	CancellationCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "you2api_upstream_cancellations_total",
+			Help: "客户端断开导致取消的上游请求数",
+		},
+		[]string{"endpoint", "stage"},
+	)
 )
 
 func Init() {
 	prometheus.MustRegister(RequestCounter)
-} 
\ No newline at end of file
+	prometheus.MustRegister(TokenCounter)
+	prometheus.MustRegister(CancellationCounter)
+}
+
+// RecordTokenUsage 记录一次请求的 prompt 与 completion token 用量，key 为 API key 的标签，
+// account 为实际使用的上游账号。标签值须来自配置，不能是客户端提供的任意凭据。
+func RecordTokenUsage(key, account, model string, promptTokens, completionTokens int) {
+	TokenCounter.WithLabelValues(key, account, model, "prompt").Add(float64(promptTokens))
+	TokenCounter.WithLabelValues(key, account, model, "completion").Add(float64(completionTokens))
+}
+
+// RecordCancellation 记录一次因客户端断开而取消的上游请求，stage 为取消时所处的阶段。
+func RecordCancellation(endpoint, stage string) {
+	CancellationCounter.WithLabelValues(endpoint, stage).Inc()
+}
@@ -0,0 +1,140 @@
+package pool
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"you2api/ratelimit"
+)
+
+// AccountConfig 定义了账号池中的一个账号。
+type AccountConfig struct {
+	Name   string `json:"name"`   // 用于日志、指标与状态报告，默认为 account-N
+	Token  string `json:"token"`  // You.com 的 DS cookie
+	Weight int    `json:"weight"` // 仅用于 weighted 策略，默认为 1
+	Group  string `json:"group"`  // 账号分组，本地 API key 可以限定只使用某个分组
+
+	Limits ratelimit.Limits `json:"limits"` // 未设置任何限额时使用 Config.DefaultLimits
+}
+
+// Config 定义了账号池配置，可以来自 JSON 文件或环境变量。
+type Config struct {
+	Strategy      Strategy         `json:"strategy"`
+	Cooldown      Duration         `json:"cooldown"`       // 401/403/429 后的冷却时间
+	QuotaCooldown Duration         `json:"quota_cooldown"` // 额度用尽后的冷却时间
+	MaxFailures   int              `json:"max_failures"`   // 连续鉴权失败多少次后移除账号
+	DefaultLimits ratelimit.Limits `json:"default_limits"` // 每个账号的默认限额
+	Accounts      []AccountConfig  `json:"accounts"`
+}
+
+// Duration 是 JSON 中以字符串（如 "90s"、"1h"）或秒数表示的时长。
+type Duration struct {
+	time.Duration
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var seconds float64
+	if err := json.Unmarshal(data, &seconds); err == nil {
+		d.Duration = time.Duration(seconds * float64(time.Second))
+		return nil
+	}
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		return fmt.Errorf("invalid duration %s", data)
+	}
+	parsed, err := time.ParseDuration(text)
+	if err != nil {
+		return err
+	}
+	d.Duration = parsed
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.Duration.String())
+}
+
+// ConfigFromEnv 从环境变量读取账号池配置，没有配置任何账号时返回 ok=false：
+//
+//	DS_TOKEN_POOL_FILE      JSON 配置文件路径（格式见 Config）
+//	DS_TOKENS               逗号分隔的 token，可用 name=token 命名、token:weight 指定权重
+//	DS_TOKEN_STRATEGY       round_robin、least_in_flight 或 weighted
+//	DS_TOKEN_COOLDOWN       如 60s
+//	DS_TOKEN_QUOTA_COOLDOWN 如 1h
+//	DS_TOKEN_MAX_FAILURES   如 3
+//
+// 文件与 DS_TOKENS 中的账号会合并，环境变量中的策略与时长覆盖文件中的值。
+func ConfigFromEnv() (Config, bool, error) {
+	var cfg Config
+	if path := os.Getenv("DS_TOKEN_POOL_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return cfg, false, fmt.Errorf("读取账号池配置失败: %w", err)
+		}
+		if err := json.Unmarshal(data, &cfg); err != nil {
+			return cfg, false, fmt.Errorf("解析账号池配置失败: %w", err)
+		}
+	}
+
+	accounts, err := ParseTokens(os.Getenv("DS_TOKENS"))
+	if err != nil {
+		return cfg, false, err
+	}
+	cfg.Accounts = append(cfg.Accounts, accounts...)
+
+	if value := os.Getenv("DS_TOKEN_STRATEGY"); value != "" {
+		cfg.Strategy = Strategy(strings.ToLower(strings.TrimSpace(value)))
+	}
+	for envName, target := range map[string]*Duration{
+		"DS_TOKEN_COOLDOWN":       &cfg.Cooldown,
+		"DS_TOKEN_QUOTA_COOLDOWN": &cfg.QuotaCooldown,
+	} {
+		if value := os.Getenv(envName); value != "" {
+			parsed, err := time.ParseDuration(value)
+			if err != nil {
+				return cfg, false, fmt.Errorf("%s: %w", envName, err)
+			}
+			target.Duration = parsed
+		}
+	}
+	if value := os.Getenv("DS_TOKEN_MAX_FAILURES"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return cfg, false, fmt.Errorf("DS_TOKEN_MAX_FAILURES: %w", err)
+		}
+		cfg.MaxFailures = n
+	}
+	return cfg, len(cfg.Accounts) > 0, nil
+}
+
+// ParseTokens 解析逗号分隔的账号列表，每项为 token、name=token，均可追加 :weight。
+func ParseTokens(value string) ([]AccountConfig, error) {
+	var accounts []AccountConfig
+	for i, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		var account AccountConfig
+		if name, token, ok := strings.Cut(entry, "="); ok {
+			account.Name = strings.TrimSpace(name)
+			entry = strings.TrimSpace(token)
+		}
+		if token, weight, ok := strings.Cut(entry, ":"); ok {
+			n, err := strconv.Atoi(strings.TrimSpace(weight))
+			if err != nil || n <= 0 {
+				// 只报告序号，避免把 token 写进日志
+				return nil, fmt.Errorf("invalid weight in DS token entry #%d", i+1)
+			}
+			account.Weight = n
+			entry = strings.TrimSpace(token)
+		}
+		account.Token = entry
+		accounts = append(accounts, account)
+	}
+	return accounts, nil
+}
@@ -0,0 +1,376 @@
+// Package pool 管理服务端配置的 You.com DS token 账号池：按策略选择账号、
+// 在鉴权失败或限流后冷却账号、移除失效账号并汇报各账号状态。
+package pool
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"you2api/ratelimit"
+)
+
+// Strategy 是选择账号的策略。
+type Strategy string
+
+const (
+	RoundRobin    Strategy = "round_robin"     // 依次轮换
+	LeastInFlight Strategy = "least_in_flight" // 选择进行中请求最少的账号
+	Weighted      Strategy = "weighted"        // 按权重平滑轮换
+)
+
+// 默认的冷却时间与失效阈值。
+const (
+	DefaultCooldown      = time.Minute
+	DefaultQuotaCooldown = time.Hour
+	DefaultMaxFailures   = 3
+)
+
+// ErrNoAccounts 表示账号池中没有可用账号（全部失效或未配置）。
+var ErrNoAccounts = errors.New("no available DS token accounts")
+
+// CoolingDownError 表示所有账号都在冷却中，RetryAfter 后最早的账号恢复可用。
+type CoolingDownError struct {
+	RetryAfter time.Duration
+}
+
+func (e *CoolingDownError) Error() string {
+	return fmt.Sprintf("all DS token accounts are cooling down, retry after %s", e.RetryAfter.Round(time.Second))
+}
+
+// Reason 是请求失败后对账号的处理原因。
+type Reason string
+
+const (
+	ReasonAuth      Reason = "auth"       // 401/403：token 无效或过期，连续多次后移除
+	ReasonRateLimit Reason = "rate_limit" // 429：短暂冷却
+	ReasonQuota     Reason = "quota"      // 额度用尽：长时间冷却
+)
+
+// quotaPatterns 是上游错误内容中表示额度用尽的关键字（小写）。
+var quotaPatterns = []string{"quota", "limit reached", "limit exceeded", "out of credits", "upgrade your plan"}
+
+// Classify 根据上游的状态码与响应内容判断是否需要冷却或移除账号。
+func Classify(statusCode int, body string) (Reason, bool) {
+	lower := strings.ToLower(body)
+	for _, pattern := range quotaPatterns {
+		if strings.Contains(lower, pattern) {
+			return ReasonQuota, true
+		}
+	}
+	switch statusCode {
+	case 401, 403:
+		return ReasonAuth, true
+	case 429:
+		return ReasonRateLimit, true
+	}
+	return "", false
+}
+
+// account 是账号池中的一个账号及其运行状态。
+type account struct {
+	name   string
+	token  string
+	weight int
+	group  string
+	limits ratelimit.Limits
+
+	inFlight      int
+	current       int // 平滑加权轮换的当前权重
+	cooldownUntil time.Time
+	failures      int // 连续鉴权失败次数
+	dead          bool
+	requests      int64
+	errors        int64
+	lastReason    Reason
+	lastUsed      time.Time
+}
+
+// Pool 是并发安全的账号池。
+type Pool struct {
+	mu            sync.Mutex
+	strategy      Strategy
+	cooldown      time.Duration
+	quotaCooldown time.Duration
+	maxFailures   int
+	accounts      []*account
+	next          int
+	now           func() time.Time
+}
+
+// New 根据配置创建账号池，配置中至少需要一个账号。
+func New(cfg Config) (*Pool, error) {
+	p := &Pool{
+		strategy:      cfg.Strategy,
+		cooldown:      cfg.Cooldown.Duration,
+		quotaCooldown: cfg.QuotaCooldown.Duration,
+		maxFailures:   cfg.MaxFailures,
+		now:           time.Now,
+	}
+	if p.strategy == "" {
+		p.strategy = RoundRobin
+	}
+	switch p.strategy {
+	case RoundRobin, LeastInFlight, Weighted:
+	default:
+		return nil, fmt.Errorf("unknown account pool strategy %q", p.strategy)
+	}
+	if p.cooldown <= 0 {
+		p.cooldown = DefaultCooldown
+	}
+	if p.quotaCooldown <= 0 {
+		p.quotaCooldown = DefaultQuotaCooldown
+	}
+	if p.maxFailures <= 0 {
+		p.maxFailures = DefaultMaxFailures
+	}
+
+	names := make(map[string]bool)
+	for i, cfgAccount := range cfg.Accounts {
+		token := strings.TrimSpace(cfgAccount.Token)
+		if token == "" {
+			return nil, fmt.Errorf("account %d has no token", i+1)
+		}
+		name := cfgAccount.Name
+		if name == "" {
+			name = fmt.Sprintf("account-%d", i+1)
+		}
+		if names[name] {
+			return nil, fmt.Errorf("duplicate account name %q", name)
+		}
+		names[name] = true
+		weight := cfgAccount.Weight
+		if weight <= 0 {
+			weight = 1
+		}
+		limits := cfgAccount.Limits
+		if !limits.Enabled() {
+			limits = cfg.DefaultLimits
+		}
+		p.accounts = append(p.accounts, &account{name: name, token: token, weight: weight, group: cfgAccount.Group, limits: limits})
+	}
+	if len(p.accounts) == 0 {
+		return nil, ErrNoAccounts
+	}
+	return p, nil
+}
+
+// Strategy 返回账号池使用的选择策略。
+func (p *Pool) Strategy() Strategy {
+	return p.strategy
+}
+
+// Selector 限定 AcquireSelected 可以选择的账号，零值表示不限。
+type Selector struct {
+	Account   string   // 只选择该名称的账号
+	Group     string   // 只选择该分组中的账号
+	Preferred string   // 优先选择的账号，不可用时按策略选择其他账号
+	Exclude   []string // 不选择这些账号（例如已超出限额）
+}
+
+// matches 报告账号是否满足 Account、Group 与 Exclude 限制。
+func (s Selector) matches(a *account) bool {
+	return (s.Account == "" || a.name == s.Account) && (s.Group == "" || a.group == s.Group) &&
+		!slices.Contains(s.Exclude, a.name)
+}
+
+// Acquire 按策略选择一个可用账号并返回租约，使用完毕后必须调用 Lease.Release。
+// 所有账号都在冷却时返回 *CoolingDownError，全部失效时返回 ErrNoAccounts。
+func (p *Pool) Acquire() (*Lease, error) {
+	return p.AcquireSelected(Selector{})
+}
+
+// AcquirePreferred 与 Acquire 相同，但优先选择名为 name 的账号（例如续接同一会话），
+// 该账号不可用时按策略选择其他账号。
+func (p *Pool) AcquirePreferred(name string) (*Lease, error) {
+	return p.AcquireSelected(Selector{Preferred: name})
+}
+
+// AcquireSelected 与 Acquire 相同，但只在满足 sel 的账号中选择。
+func (p *Pool) AcquireSelected(sel Selector) (*Lease, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := p.now()
+	name := sel.Preferred
+	var available []int
+	var earliest time.Time
+	for i, a := range p.accounts {
+		if a.dead || !sel.matches(a) {
+			continue
+		}
+		if now.Before(a.cooldownUntil) {
+			if earliest.IsZero() || a.cooldownUntil.Before(earliest) {
+				earliest = a.cooldownUntil
+			}
+			continue
+		}
+		if name != "" && a.name == name {
+			return p.lease(i, now), nil
+		}
+		available = append(available, i)
+	}
+	if len(available) == 0 {
+		if earliest.IsZero() {
+			return nil, ErrNoAccounts
+		}
+		return nil, &CoolingDownError{RetryAfter: earliest.Sub(now)}
+	}
+	return p.lease(p.pick(available), now), nil
+}
+
+// pick 按策略从可用账号下标中选择一个，调用方需持有锁。
+func (p *Pool) pick(available []int) int {
+	// 按从 next 开始的轮换顺序排列，保证平局时也会轮换
+	n := len(p.accounts)
+	sort.Slice(available, func(i, j int) bool {
+		return (available[i]-p.next+n)%n < (available[j]-p.next+n)%n
+	})
+
+	chosen := available[0]
+	switch p.strategy {
+	case LeastInFlight:
+		for _, i := range available[1:] {
+			if p.accounts[i].inFlight < p.accounts[chosen].inFlight {
+				chosen = i
+			}
+		}
+	case Weighted:
+		total := 0
+		for _, i := range available {
+			a := p.accounts[i]
+			a.current += a.weight
+			total += a.weight
+			if a.current > p.accounts[chosen].current {
+				chosen = i
+			}
+		}
+		p.accounts[chosen].current -= total
+	}
+	p.next = (chosen + 1) % n
+	return chosen
+}
+
+// lease 为下标为 i 的账号创建租约，调用方需持有锁。
+func (p *Pool) lease(i int, now time.Time) *Lease {
+	a := p.accounts[i]
+	a.inFlight++
+	a.requests++
+	a.lastUsed = now
+	return &Lease{pool: p, account: a}
+}
+
+// Lease 是一次请求对账号的占用。
+type Lease struct {
+	pool     *Pool
+	account  *account
+	once     sync.Once
+	reported bool // 是否调用过 Fail，由 pool.mu 保护
+}
+
+// Name 返回账号名称。
+func (l *Lease) Name() string {
+	return l.account.name
+}
+
+// Token 返回账号的 DS token。
+func (l *Lease) Token() string {
+	return l.account.token
+}
+
+// Limits 返回账号的限额。
+func (l *Lease) Limits() ratelimit.Limits {
+	return l.account.limits
+}
+
+// Fail 报告请求因 reason 失败：冷却账号，连续鉴权失败达到阈值时移除账号。
+func (l *Lease) Fail(reason Reason) {
+	p := l.pool
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	l.reported = true
+	a := l.account
+	a.errors++
+	a.lastReason = reason
+	cooldown := p.cooldown
+	switch reason {
+	case ReasonAuth:
+		a.failures++
+		if a.failures >= p.maxFailures {
+			a.dead = true
+			fmt.Printf("账号 %s 连续 %d 次鉴权失败，已移出账号池\n", a.name, a.failures)
+			return
+		}
+	case ReasonQuota:
+		cooldown = p.quotaCooldown
+	}
+	a.cooldownUntil = p.now().Add(cooldown)
+	fmt.Printf("账号 %s 因 %s 冷却 %s\n", a.name, reason, cooldown)
+}
+
+// Release 结束占用，可重复调用。没有调用过 Fail 时视为成功，清零连续鉴权失败次数。
+func (l *Lease) Release() {
+	l.once.Do(func() {
+		p := l.pool
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		l.account.inFlight--
+		if !l.reported {
+			l.account.failures = 0
+		}
+	})
+}
+
+// AccountStatus 是账号的状态报告，不包含 token。
+type AccountStatus struct {
+	Name          string     `json:"name"`
+	State         string     `json:"state"` // active、cooling_down 或 dead
+	Weight        int        `json:"weight"`
+	Group         string     `json:"group,omitempty"`
+	InFlight      int        `json:"in_flight"`
+	Requests      int64      `json:"requests"`
+	Errors        int64      `json:"errors"`
+	LastError     string     `json:"last_error,omitempty"`
+	CooldownUntil *time.Time `json:"cooldown_until,omitempty"`
+	LastUsed      *time.Time `json:"last_used,omitempty"`
+}
+
+// Status 返回所有账号（包括已移除的账号）的当前状态。
+func (p *Pool) Status() []AccountStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := p.now()
+	statuses := make([]AccountStatus, 0, len(p.accounts))
+	for _, a := range p.accounts {
+		status := AccountStatus{
+			Name:      a.name,
+			State:     "active",
+			Weight:    a.weight,
+			Group:     a.group,
+			InFlight:  a.inFlight,
+			Requests:  a.requests,
+			Errors:    a.errors,
+			LastError: string(a.lastReason),
+		}
+		switch {
+		case a.dead:
+			status.State = "dead"
+		case now.Before(a.cooldownUntil):
+			status.State = "cooling_down"
+			until := a.cooldownUntil
+			status.CooldownUntil = &until
+		}
+		if !a.lastUsed.IsZero() {
+			lastUsed := a.lastUsed
+			status.LastUsed = &lastUsed
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
@@ -0,0 +1,163 @@
+package pool
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestPool(t *testing.T, strategy Strategy, accounts ...AccountConfig) (*Pool, *time.Time) {
+	t.Helper()
+	p, err := New(Config{Strategy: strategy, Accounts: accounts})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	p.now = func() time.Time { return now }
+	return p, &now
+}
+
+// acquireNames 连续获取并立即释放 n 个租约，返回选中的账号名称。
+func acquireNames(t *testing.T, p *Pool, n int) []string {
+	t.Helper()
+	var names []string
+	for i := 0; i < n; i++ {
+		lease, err := p.Acquire()
+		if err != nil {
+			t.Fatalf("Acquire() error = %v", err)
+		}
+		names = append(names, lease.Name())
+		lease.Release()
+	}
+	return names
+}
+
+func TestStrategies(t *testing.T) {
+	accounts := []AccountConfig{{Name: "a", Token: "ta", Weight: 3}, {Name: "b", Token: "tb"}, {Name: "c", Token: "tc"}}
+
+	p, _ := newTestPool(t, RoundRobin, accounts...)
+	if got, want := acquireNames(t, p, 4), []string{"a", "b", "c", "a"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("round_robin = %v, want %v", got, want)
+	}
+
+	p, _ = newTestPool(t, Weighted, accounts...)
+	if got, want := acquireNames(t, p, 5), []string{"a", "b", "a", "c", "a"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("weighted = %v, want %v", got, want)
+	}
+
+	p, _ = newTestPool(t, LeastInFlight, accounts...)
+	first, _ := p.Acquire()
+	second, _ := p.Acquire()
+	third, _ := p.Acquire()
+	second.Release()
+	if lease, _ := p.Acquire(); lease.Name() != second.Name() {
+		t.Errorf("least_in_flight picked %s, want %s", lease.Name(), second.Name())
+	}
+	first.Release()
+	third.Release()
+}
+
+func TestCooldownAndRemoval(t *testing.T) {
+	p, now := newTestPool(t, RoundRobin, AccountConfig{Name: "a", Token: "ta"}, AccountConfig{Name: "b", Token: "tb"})
+	p.maxFailures = 2
+
+	lease, _ := p.Acquire()
+	lease.Fail(ReasonRateLimit)
+	lease.Release()
+	if got := acquireNames(t, p, 2); !reflect.DeepEqual(got, []string{"b", "b"}) {
+		t.Errorf("during cooldown = %v, want only b", got)
+	}
+
+	// b 连续两次鉴权失败后被移除
+	for i := 0; i < 2; i++ {
+		*now = now.Add(DefaultCooldown)
+		lease, _ := p.AcquirePreferred("b")
+		lease.Fail(ReasonAuth)
+		lease.Release()
+	}
+
+	lease, _ = p.Acquire()
+	lease.Fail(ReasonQuota)
+	lease.Release()
+	var coolingDown *CoolingDownError
+	if _, err := p.Acquire(); !errors.As(err, &coolingDown) || coolingDown.RetryAfter != DefaultQuotaCooldown {
+		t.Fatalf("Acquire() error = %v, want CoolingDownError after %s", err, DefaultQuotaCooldown)
+	}
+
+	states := map[string]string{}
+	for _, status := range p.Status() {
+		states[status.Name] = status.State
+	}
+	if want := map[string]string{"a": "cooling_down", "b": "dead"}; !reflect.DeepEqual(states, want) {
+		t.Errorf("Status() states = %v, want %v", states, want)
+	}
+
+	*now = now.Add(DefaultQuotaCooldown)
+	if got := acquireNames(t, p, 2); !reflect.DeepEqual(got, []string{"a", "a"}) {
+		t.Errorf("after cooldown = %v, want only a", got)
+	}
+}
+
+func TestAcquireSelected(t *testing.T) {
+	p, _ := newTestPool(t, RoundRobin,
+		AccountConfig{Name: "a", Token: "ta", Group: "team"},
+		AccountConfig{Name: "b", Token: "tb"},
+		AccountConfig{Name: "c", Token: "tc", Group: "team"})
+
+	for _, want := range []string{"a", "c", "a"} {
+		lease, err := p.AcquireSelected(Selector{Group: "team"})
+		if err != nil || lease.Name() != want {
+			t.Fatalf("AcquireSelected(team) = %v, %v, want %s", lease, err, want)
+		}
+		lease.Release()
+	}
+	if lease, err := p.AcquireSelected(Selector{Group: "team", Preferred: "b"}); err != nil || lease.Name() == "b" {
+		t.Errorf("preferred account outside the group was selected")
+	}
+	if lease, err := p.AcquireSelected(Selector{Group: "team", Exclude: []string{"a"}}); err != nil || lease.Name() != "c" {
+		t.Errorf("AcquireSelected(exclude a) = %v, %v, want c", lease, err)
+	}
+	if _, err := p.AcquireSelected(Selector{Account: "missing"}); !errors.Is(err, ErrNoAccounts) {
+		t.Errorf("AcquireSelected(missing) error = %v, want ErrNoAccounts", err)
+	}
+}
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		status int
+		body   string
+		want   Reason
+		ok     bool
+	}{
+		{401, "", ReasonAuth, true},
+		{403, "forbidden", ReasonAuth, true},
+		{429, "", ReasonRateLimit, true},
+		{402, "You have exceeded your daily quota", ReasonQuota, true},
+		{500, "internal error", "", false},
+	}
+	for _, tt := range tests {
+		if got, ok := Classify(tt.status, tt.body); got != tt.want || ok != tt.ok {
+			t.Errorf("Classify(%d, %q) = %q, %v, want %q, %v", tt.status, tt.body, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestParseTokens(t *testing.T) {
+	accounts, err := ParseTokens("t1, main=t2:3,,t3:2")
+	if err != nil {
+		t.Fatalf("ParseTokens() error = %v", err)
+	}
+	want := []AccountConfig{{Token: "t1"}, {Name: "main", Token: "t2", Weight: 3}, {Token: "t3", Weight: 2}}
+	if !reflect.DeepEqual(accounts, want) {
+		t.Errorf("ParseTokens() = %+v, want %+v", accounts, want)
+	}
+	_, err = ParseTokens("t1,, main=secret-token:x")
+	if err == nil {
+		t.Fatalf("ParseTokens() with invalid weight should fail")
+	}
+	if !strings.Contains(err.Error(), "#3") || strings.Contains(err.Error(), "secret-token") {
+		t.Errorf("ParseTokens() error = %q, want the entry index without the token", err)
+	}
+}
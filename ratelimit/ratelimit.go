@@ -0,0 +1,179 @@
+// Package ratelimit 按 API key 或上游账号限制每分钟请求数、并发流数与每日估算 token 数。
+package ratelimit
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Limits 是一个 API key 或账号的限额，0 表示不限。
+type Limits struct {
+	RequestsPerMinute int `json:"requests_per_minute"`
+	ConcurrentStreams int `json:"concurrent_streams"`
+	TokensPerDay      int `json:"tokens_per_day"` // 按 prompt 与输出估算的 token 数，UTC 零点重置
+}
+
+// Enabled 报告是否设置了任何限额。
+func (l Limits) Enabled() bool {
+	return l.RequestsPerMinute > 0 || l.ConcurrentStreams > 0 || l.TokensPerDay > 0
+}
+
+// Kind 是超出的限额类型。
+type Kind string
+
+const (
+	KindRequests Kind = "requests"
+	KindStreams  Kind = "streams"
+	KindTokens   Kind = "tokens"
+)
+
+// streamRetryAfter 是并发流超限时建议的重试间隔，流结束的时间无法预知。
+const streamRetryAfter = time.Second
+
+// Status 是一次检查后的剩余额度，用于生成 x-ratelimit-* 响应头部。
+type Status struct {
+	Limits            Limits
+	RemainingRequests int
+	ResetRequests     time.Duration // 最早的一次请求移出一分钟窗口的时间
+	RemainingTokens   int
+	ResetTokens       time.Duration // 距离下一个 UTC 零点的时间
+}
+
+// LimitError 表示请求超出了限额。
+type LimitError struct {
+	Scope      string // 限额所属的对象，如 key 或 account
+	Kind       Kind
+	Limit      int
+	RetryAfter time.Duration
+	Status     Status
+}
+
+func (e *LimitError) Error() string {
+	switch e.Kind {
+	case KindStreams:
+		return fmt.Sprintf("Rate limit reached for concurrent streams on %s: limit %d. Please try again in %s.", e.Scope, e.Limit, e.RetryAfter.Round(time.Millisecond))
+	case KindTokens:
+		return fmt.Sprintf("Rate limit reached for tokens per day (TPD) on %s: limit %d. Please try again in %s.", e.Scope, e.Limit, e.RetryAfter.Round(time.Second))
+	}
+	return fmt.Sprintf("Rate limit reached for requests per minute (RPM) on %s: limit %d. Please try again in %s.", e.Scope, e.Limit, e.RetryAfter.Round(time.Millisecond))
+}
+
+// bucket 是一个 API key 或账号的用量。
+type bucket struct {
+	requests []time.Time // 最近一分钟内的请求时间，按时间排序
+	streams  int
+	day      time.Time // tokens 所属的 UTC 日期
+	tokens   int
+}
+
+// Limiter 记录各 API key 或账号的用量，可以并发使用。
+type Limiter struct {
+	mu      sync.Mutex
+	scope   string
+	buckets map[string]*bucket
+	now     func() time.Time
+}
+
+// New 创建 Limiter，scope 用于错误信息（如 "key"、"account"）。
+func New(scope string) *Limiter {
+	return &Limiter{scope: scope, buckets: make(map[string]*bucket), now: time.Now}
+}
+
+// Allow 按 limits 检查 id 是否还能发起一次请求（stream 为 true 时同时占用一个并发流），
+// 允许时记录本次请求并返回 Permit，请求结束后必须调用 Permit.Release；超限时返回 *LimitError。
+func (l *Limiter) Allow(id string, limits Limits, stream bool) (*Permit, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	b, ok := l.buckets[id]
+	if !ok {
+		b = &bucket{}
+		l.buckets[id] = b
+	}
+	l.refresh(b, now)
+
+	status := l.status(b, limits, now)
+	limitErr := &LimitError{Scope: l.scope, Status: status}
+	switch {
+	case limits.RequestsPerMinute > 0 && len(b.requests) >= limits.RequestsPerMinute:
+		limitErr.Kind, limitErr.Limit, limitErr.RetryAfter = KindRequests, limits.RequestsPerMinute, status.ResetRequests
+	case stream && limits.ConcurrentStreams > 0 && b.streams >= limits.ConcurrentStreams:
+		limitErr.Kind, limitErr.Limit, limitErr.RetryAfter = KindStreams, limits.ConcurrentStreams, streamRetryAfter
+	case limits.TokensPerDay > 0 && b.tokens >= limits.TokensPerDay:
+		limitErr.Kind, limitErr.Limit, limitErr.RetryAfter = KindTokens, limits.TokensPerDay, status.ResetTokens
+	default:
+		b.requests = append(b.requests, now)
+		if stream {
+			b.streams++
+		}
+		return &Permit{limiter: l, bucket: b, stream: stream, status: l.status(b, limits, now)}, nil
+	}
+	return nil, limitErr
+}
+
+// refresh 移除一分钟之前的请求，并在日期变化时清零 token 用量，调用方需持有锁。
+func (l *Limiter) refresh(b *bucket, now time.Time) {
+	cutoff := now.Add(-time.Minute)
+	i := 0
+	for i < len(b.requests) && !b.requests[i].After(cutoff) {
+		i++
+	}
+	b.requests = b.requests[i:]
+
+	day := now.UTC().Truncate(24 * time.Hour)
+	if !b.day.Equal(day) {
+		b.day = day
+		b.tokens = 0
+	}
+}
+
+// status 计算剩余额度，调用方需持有锁。
+func (l *Limiter) status(b *bucket, limits Limits, now time.Time) Status {
+	status := Status{Limits: limits, ResetTokens: b.day.Add(24 * time.Hour).Sub(now)}
+	if limits.RequestsPerMinute > 0 {
+		status.RemainingRequests = max(limits.RequestsPerMinute-len(b.requests), 0)
+		if len(b.requests) > 0 {
+			status.ResetRequests = b.requests[0].Add(time.Minute).Sub(now)
+		}
+	}
+	if limits.TokensPerDay > 0 {
+		status.RemainingTokens = max(limits.TokensPerDay-b.tokens, 0)
+	}
+	return status
+}
+
+// Permit 是一次被允许的请求。
+type Permit struct {
+	limiter *Limiter
+	bucket  *bucket
+	stream  bool
+	once    sync.Once
+	status  Status
+}
+
+// Status 返回允许本次请求后的剩余额度。
+func (p *Permit) Status() Status {
+	return p.status
+}
+
+// AddTokens 将本次请求的估算 token 数计入当日用量。
+func (p *Permit) AddTokens(n int) {
+	p.limiter.mu.Lock()
+	defer p.limiter.mu.Unlock()
+	p.limiter.refresh(p.bucket, p.limiter.now())
+	p.bucket.tokens += n
+}
+
+// Release 结束请求并释放占用的并发流，可重复调用。
+func (p *Permit) Release() {
+	p.once.Do(func() {
+		if !p.stream {
+			return
+		}
+		p.limiter.mu.Lock()
+		defer p.limiter.mu.Unlock()
+		p.bucket.streams--
+	})
+}
@@ -0,0 +1,92 @@
+package ratelimit
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func newTestLimiter() (*Limiter, *time.Time) {
+	l := New("key")
+	now := time.Date(2025, 1, 1, 23, 59, 0, 0, time.UTC)
+	l.now = func() time.Time { return now }
+	return l, &now
+}
+
+func TestRequestsPerMinute(t *testing.T) {
+	l, now := newTestLimiter()
+	limits := Limits{RequestsPerMinute: 2}
+
+	first, err := l.Allow("a", limits, false)
+	if err != nil || first.Status().RemainingRequests != 1 {
+		t.Fatalf("first Allow() = %+v, %v", first, err)
+	}
+	*now = now.Add(10 * time.Second)
+	if _, err := l.Allow("a", limits, false); err != nil {
+		t.Fatalf("second Allow() error = %v", err)
+	}
+	// 其他 id 不受影响
+	if _, err := l.Allow("b", limits, false); err != nil {
+		t.Fatalf("Allow(b) error = %v", err)
+	}
+
+	var limitErr *LimitError
+	if _, err := l.Allow("a", limits, false); !errors.As(err, &limitErr) || limitErr.Kind != KindRequests {
+		t.Fatalf("third Allow() error = %v, want requests limit", err)
+	}
+	if limitErr.RetryAfter != 50*time.Second || limitErr.Status.RemainingRequests != 0 {
+		t.Errorf("LimitError = %+v, want retry after 50s", limitErr)
+	}
+
+	*now = now.Add(50 * time.Second)
+	if _, err := l.Allow("a", limits, false); err != nil {
+		t.Errorf("Allow() after window error = %v", err)
+	}
+}
+
+func TestConcurrentStreams(t *testing.T) {
+	l, _ := newTestLimiter()
+	limits := Limits{ConcurrentStreams: 1}
+
+	permit, err := l.Allow("a", limits, true)
+	if err != nil {
+		t.Fatalf("Allow() error = %v", err)
+	}
+	// 非流式请求不占用并发流
+	if _, err := l.Allow("a", limits, false); err != nil {
+		t.Fatalf("non-streaming Allow() error = %v", err)
+	}
+	var limitErr *LimitError
+	if _, err := l.Allow("a", limits, true); !errors.As(err, &limitErr) || limitErr.Kind != KindStreams {
+		t.Fatalf("Allow() error = %v, want streams limit", err)
+	}
+	permit.Release()
+	permit.Release()
+	if _, err := l.Allow("a", limits, true); err != nil {
+		t.Errorf("Allow() after Release error = %v", err)
+	}
+}
+
+func TestTokensPerDay(t *testing.T) {
+	l, now := newTestLimiter()
+	limits := Limits{TokensPerDay: 100}
+
+	permit, err := l.Allow("a", limits, false)
+	if err != nil {
+		t.Fatalf("Allow() error = %v", err)
+	}
+	permit.AddTokens(120)
+	var limitErr *LimitError
+	if _, err := l.Allow("a", limits, false); !errors.As(err, &limitErr) || limitErr.Kind != KindTokens {
+		t.Fatalf("Allow() error = %v, want tokens limit", err)
+	}
+	if limitErr.RetryAfter != time.Minute {
+		t.Errorf("RetryAfter = %s, want time until UTC midnight", limitErr.RetryAfter)
+	}
+
+	*now = now.Add(time.Minute)
+	permit, err = l.Allow("a", limits, false)
+	if err != nil || permit.Status().RemainingTokens != 100 {
+		t.Errorf("Allow() on the next day = %+v, %v", permit, err)
+	}
+}
@@ -0,0 +1,154 @@
+// Package retry 定义上游请求失败后的重试策略：退避时间、最大尝试次数、可重试的错误，
+// 以及每个模型的回退链（例如 claude-3-7-sonnet → claude-3.5-sonnet → gpt-4o）。
+package retry
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"you2api/youclient"
+)
+
+// 默认的退避时间与可重试状态码。默认只尝试一次，即不重试。
+const (
+	DefaultMaxAttempts    = 1
+	DefaultInitialBackoff = 500 * time.Millisecond
+	DefaultMaxBackoff     = 5 * time.Second
+)
+
+// DefaultRetryableStatuses 是默认可以重试的上游状态码。
+var DefaultRetryableStatuses = []int{401, 403, 429, 500, 502, 503, 504}
+
+// Policy 是重试与模型回退策略。
+type Policy struct {
+	MaxAttempts        int           // 每个模型的最大尝试次数（包括第一次）
+	InitialBackoff     time.Duration // 第一次重试前的等待时间，之后每次翻倍
+	MaxBackoff         time.Duration // 等待时间上限
+	RetryableStatuses  []int         // 可以重试的上游状态码
+	RetryNetworkErrors bool          // 是否重试连接失败、超时等没有状态码的错误
+	Fallbacks          map[string][]string
+}
+
+// DefaultPolicy 返回不重试、没有回退链的默认策略。
+func DefaultPolicy() Policy {
+	return Policy{
+		MaxAttempts:        DefaultMaxAttempts,
+		InitialBackoff:     DefaultInitialBackoff,
+		MaxBackoff:         DefaultMaxBackoff,
+		RetryableStatuses:  DefaultRetryableStatuses,
+		RetryNetworkErrors: true,
+	}
+}
+
+// PolicyFromEnv 从环境变量读取策略，未设置的项使用默认值：
+//
+//	RETRY_MAX_ATTEMPTS    每个模型的最大尝试次数，如 3
+//	RETRY_BACKOFF         第一次重试前的等待时间，如 500ms
+//	RETRY_MAX_BACKOFF     等待时间上限，如 5s
+//	RETRY_STATUSES        逗号分隔的可重试状态码，如 429,500,502,503,504
+//	RETRY_NETWORK_ERRORS  是否重试网络错误，true 或 false
+//	MODEL_FALLBACKS       分号分隔的回退链，如 claude-3-7-sonnet=claude-3.5-sonnet,gpt-4o;o1=gpt-4o
+func PolicyFromEnv() (Policy, error) {
+	p := DefaultPolicy()
+	if value := os.Getenv("RETRY_MAX_ATTEMPTS"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			return p, fmt.Errorf("RETRY_MAX_ATTEMPTS: invalid value %q", value)
+		}
+		p.MaxAttempts = n
+	}
+	for envName, target := range map[string]*time.Duration{
+		"RETRY_BACKOFF":     &p.InitialBackoff,
+		"RETRY_MAX_BACKOFF": &p.MaxBackoff,
+	} {
+		if value := os.Getenv(envName); value != "" {
+			d, err := time.ParseDuration(value)
+			if err != nil {
+				return p, fmt.Errorf("%s: %w", envName, err)
+			}
+			*target = d
+		}
+	}
+	if value := os.Getenv("RETRY_STATUSES"); value != "" {
+		p.RetryableStatuses = nil
+		for _, field := range strings.Split(value, ",") {
+			code, err := strconv.Atoi(strings.TrimSpace(field))
+			if err != nil {
+				return p, fmt.Errorf("RETRY_STATUSES: invalid status %q", field)
+			}
+			p.RetryableStatuses = append(p.RetryableStatuses, code)
+		}
+	}
+	if value := os.Getenv("RETRY_NETWORK_ERRORS"); value != "" {
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			return p, fmt.Errorf("RETRY_NETWORK_ERRORS: %w", err)
+		}
+		p.RetryNetworkErrors = enabled
+	}
+	fallbacks, err := ParseFallbacks(os.Getenv("MODEL_FALLBACKS"))
+	if err != nil {
+		return p, err
+	}
+	p.Fallbacks = fallbacks
+	return p, nil
+}
+
+// ParseFallbacks 解析分号分隔的回退链，每项为 model=fallback1,fallback2。
+func ParseFallbacks(value string) (map[string][]string, error) {
+	fallbacks := make(map[string][]string)
+	for _, entry := range strings.Split(value, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		model, chain, ok := strings.Cut(entry, "=")
+		model = strings.TrimSpace(model)
+		if !ok || model == "" {
+			return nil, fmt.Errorf("MODEL_FALLBACKS: invalid entry %q", entry)
+		}
+		for _, fallback := range strings.Split(chain, ",") {
+			if fallback = strings.TrimSpace(fallback); fallback != "" && fallback != model {
+				fallbacks[model] = append(fallbacks[model], fallback)
+			}
+		}
+	}
+	return fallbacks, nil
+}
+
+// Models 返回请求 model 时依次尝试的模型：model 本身及其回退链。
+func (p Policy) Models(model string) []string {
+	return append([]string{model}, p.Fallbacks[model]...)
+}
+
+// Backoff 返回第 retry 次重试（从 1 开始）前的等待时间。
+func (p Policy) Backoff(retry int) time.Duration {
+	d := p.InitialBackoff
+	for i := 1; i < retry && d < p.MaxBackoff; i++ {
+		d *= 2
+	}
+	return min(d, p.MaxBackoff)
+}
+
+// statusCoder 由没有 HTTP 状态码、但有等效状态码的错误实现，例如从回答内容中识别出的额度用尽。
+type statusCoder interface {
+	StatusCode() int
+}
+
+// Retryable 报告上游错误是否可以重试或回退到其他模型。
+func (p Policy) Retryable(err error) bool {
+	var statusErr *youclient.StatusError
+	var coder statusCoder
+	switch {
+	case errors.As(err, &statusErr):
+		return slices.Contains(p.RetryableStatuses, statusErr.StatusCode)
+	case errors.As(err, &coder):
+		return slices.Contains(p.RetryableStatuses, coder.StatusCode())
+	}
+	return p.RetryNetworkErrors
+}
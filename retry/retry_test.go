@@ -0,0 +1,61 @@
+package retry
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"you2api/youclient"
+)
+
+func TestBackoff(t *testing.T) {
+	p := Policy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second}
+	var got []time.Duration
+	for retry := 1; retry <= 5; retry++ {
+		got = append(got, p.Backoff(retry))
+	}
+	want := []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 800 * time.Millisecond, time.Second}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Backoff() = %v, want %v", got, want)
+	}
+}
+
+func TestRetryable(t *testing.T) {
+	p := DefaultPolicy()
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{&youclient.StatusError{StatusCode: 429}, true},
+		{&youclient.StatusError{StatusCode: 502}, true},
+		{&youclient.StatusError{StatusCode: 400}, false},
+		{errors.New("connection reset"), true},
+	}
+	for _, tt := range tests {
+		if got := p.Retryable(tt.err); got != tt.want {
+			t.Errorf("Retryable(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+	p.RetryNetworkErrors = false
+	if p.Retryable(errors.New("connection reset")) {
+		t.Errorf("Retryable(network error) = true with RetryNetworkErrors disabled")
+	}
+}
+
+func TestParseFallbacks(t *testing.T) {
+	fallbacks, err := ParseFallbacks(" claude-3-7-sonnet = claude-3.5-sonnet, gpt-4o ;o1=gpt-4o,o1;")
+	if err != nil {
+		t.Fatalf("ParseFallbacks() error = %v", err)
+	}
+	p := Policy{Fallbacks: fallbacks}
+	if got, want := p.Models("claude-3-7-sonnet"), []string{"claude-3-7-sonnet", "claude-3.5-sonnet", "gpt-4o"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Models() = %v, want %v", got, want)
+	}
+	if got, want := p.Models("o1"), []string{"o1", "gpt-4o"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Models() = %v, want %v", got, want)
+	}
+	if _, err := ParseFallbacks("gpt-4o"); err == nil {
+		t.Errorf("ParseFallbacks() without = should fail")
+	}
+}
@@ -7,7 +7,10 @@ import (
 
 	api "you2api/api" // 请替换为您的实际项目名
 	config "you2api/config"
+	metrics "you2api/metrics"
 	proxy "you2api/proxy"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 func main() {
@@ -34,6 +37,10 @@ func run() error {
 		http.Handle("/proxy/", http.StripPrefix("/proxy", proxy))
 	}
 
+	// 注册 Prometheus 指标
+	metrics.Init()
+	http.Handle("/metrics", promhttp.Handler())
+
 	// 注册API处理器到根路径
 	http.HandleFunc("/", api.Handler)
 
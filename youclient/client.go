@@ -0,0 +1,165 @@
+// Package youclient 是 You.com 网页接口（streamingSearch、get_nonce、upload）的类型化客户端。
+package youclient
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// DefaultBaseURL 是 You.com 的默认地址。
+const DefaultBaseURL = "https://you.com"
+
+// DefaultHeaders 是模拟浏览器请求时使用的默认请求头。
+var DefaultHeaders = http.Header{
+	"sec-ch-ua-platform":         {"Windows"},
+	"Cache-Control":              {"no-cache"},
+	"sec-ch-ua":                  {`"Not(A:Brand";v="99", "Microsoft Edge";v="133", "Chromium";v="133"`},
+	"sec-ch-ua-bitness":          {"64"},
+	"sec-ch-ua-model":            {""},
+	"sec-ch-ua-mobile":           {"?0"},
+	"sec-ch-ua-arch":             {"x86"},
+	"sec-ch-ua-full-version":     {"133.0.3065.39"},
+	"User-Agent":                 {"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/133.0.0.0 Safari/537.36 Edg/133.0.0.0"},
+	"sec-ch-ua-platform-version": {"19.0.0"},
+	"Sec-Fetch-Site":             {"same-origin"},
+	"Sec-Fetch-Mode":             {"cors"},
+	"Sec-Fetch-Dest":             {"empty"},
+}
+
+// DefaultCookies 返回携带 DS token 的默认 Cookie。
+func DefaultCookies(dsToken string) map[string]string {
+	return map[string]string{
+		"guest_has_seen_legal_disclaimer": "true",
+		"youchat_personalization":         "true",
+		"DS":                              dsToken,                // 关键的 DS token
+		"you_subscription":                "youpro_standard_year", // 示例订阅信息
+		"youpro_subscription":             "true",
+		"ai_model":                        "deepseek_r1", // 示例 AI 模型
+		"youchat_smart_learn":             "true",
+	}
+}
+
+// Client 是 You.com 客户端。零值不可用，请使用 New 创建。
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+	headers    http.Header
+	cookies    map[string]string
+}
+
+// Option 配置 Client。
+type Option func(*Client)
+
+// WithBaseURL 设置上游地址，例如测试中使用的本地替身服务器。
+func WithBaseURL(baseURL string) Option {
+	return func(c *Client) {
+		c.baseURL = strings.TrimSuffix(baseURL, "/")
+	}
+}
+
+// WithHTTPClient 设置发送请求使用的 http.Client。
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+// WithTransport 设置发送请求使用的 http.RoundTripper（例如代理）。
+func WithTransport(transport http.RoundTripper) Option {
+	return func(c *Client) {
+		httpClient := *c.httpClient
+		httpClient.Transport = transport
+		c.httpClient = &httpClient
+	}
+}
+
+// WithHeaders 添加或覆盖请求头。
+func WithHeaders(headers http.Header) Option {
+	return func(c *Client) {
+		for name, values := range headers {
+			c.headers[name] = append([]string(nil), values...)
+		}
+	}
+}
+
+// WithCookies 添加或覆盖 Cookie。
+func WithCookies(cookies map[string]string) Option {
+	return func(c *Client) {
+		for name, value := range cookies {
+			c.cookies[name] = value
+		}
+	}
+}
+
+// New 创建使用 dsToken 的客户端，默认带有浏览器请求头与 DefaultCookies。
+func New(dsToken string, opts ...Option) *Client {
+	c := &Client{
+		baseURL:    DefaultBaseURL,
+		httpClient: &http.Client{},
+		headers:    DefaultHeaders.Clone(),
+		cookies:    DefaultCookies(dsToken),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// BaseURL 返回客户端使用的上游地址。
+func (c *Client) BaseURL() string {
+	return c.baseURL
+}
+
+// newRequest 创建带有客户端请求头与 Cookie 的请求。
+func (c *Client) newRequest(ctx context.Context, method, path string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header = c.headers.Clone()
+	req.Header.Set("Cookie", c.cookieHeader())
+	return req, nil
+}
+
+// cookieHeader 按名称排序拼接 Cookie，保证请求稳定可比较。
+func (c *Client) cookieHeader() string {
+	names := make([]string, 0, len(c.cookies))
+	for name := range c.cookies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	cookies := make([]string, 0, len(names))
+	for _, name := range names {
+		cookies = append(cookies, fmt.Sprintf("%s=%s", name, c.cookies[name]))
+	}
+	return strings.Join(cookies, ";")
+}
+
+// StatusError 表示上游返回了非 200 状态码。
+type StatusError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("API returned status %d", e.StatusCode)
+}
+
+// do 发送请求，状态码不是 200 时读取响应内容并返回 *StatusError。
+func (c *Client) do(req *http.Request) (*http.Response, error) {
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		return nil, &StatusError{StatusCode: resp.StatusCode, Body: string(body)}
+	}
+	return resp, nil
+}
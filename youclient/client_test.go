@@ -0,0 +1,192 @@
+package youclient
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestStreamingSearch(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/streamingSearch" {
+			t.Errorf("path = %q, want /api/streamingSearch", r.URL.Path)
+		}
+		q := r.URL.Query()
+		if q.Get("q") != "hello" || q.Get("selectedAiModel") != "gpt_4o" || q.Get("chat") != `[{"question":"q1","answer":"a1"}]` {
+			t.Errorf("unexpected query %v", q)
+		}
+		if q.Get("pastChatLength") != "1" || q.Get("extra") != "1" {
+			t.Errorf("unexpected query %v", q)
+		}
+		if cookie := r.Header.Get("Cookie"); !strings.Contains(cookie, "DS=token") || !strings.Contains(cookie, "extra=1") {
+			t.Errorf("Cookie = %q, want DS and extra cookies", cookie)
+		}
+		if r.Header.Get("X-Test") != "yes" {
+			t.Errorf("X-Test header = %q, want yes", r.Header.Get("X-Test"))
+		}
+		io.WriteString(w, "event: youChatToken\ndata: {\"youChatToken\": \"Hi\"}\n\n"+
+			"event: thirdPartySearchResults\ndata: {\"search\": {\"third_party_search_results\": [{\"url\": \"https://a.example\", \"name\": \"A\"}, {\"name\": \"no url\"}]}}\n\n"+
+			"event: done\ndata: I'm done\n\n")
+	}))
+	defer server.Close()
+
+	client := New("token",
+		WithBaseURL(server.URL+"/"),
+		WithHeaders(http.Header{"X-Test": {"yes"}}),
+		WithCookies(map[string]string{"extra": "1"}),
+	)
+	stream, err := client.StreamingSearch(context.Background(), &StreamingSearchRequest{
+		Query:           "hello",
+		Chat:            []ChatEntry{{Question: "q1", Answer: "a1"}},
+		SelectedAIModel: "gpt_4o",
+		Extra:           map[string][]string{"extra": {"1"}},
+	})
+	if err != nil {
+		t.Fatalf("StreamingSearch() error = %v", err)
+	}
+	defer stream.Close()
+
+	var events []Event
+	for stream.Next() {
+		events = append(events, stream.Event())
+	}
+	if err := stream.Err(); err != nil {
+		t.Fatalf("Err() = %v", err)
+	}
+	if len(events) != 3 {
+		t.Fatalf("got %d events, want 3: %+v", len(events), events)
+	}
+	if token, ok := events[0].Token(); !ok || token != "Hi" {
+		t.Errorf("Token() = %q, %v, want Hi", token, ok)
+	}
+	if results := events[1].SearchResults(); len(results) != 1 || results[0].URL != "https://a.example" {
+		t.Errorf("SearchResults() = %+v, want one result", results)
+	}
+	if _, ok := events[2].Token(); ok {
+		t.Errorf("Token() on %q event should not succeed", events[2].Name)
+	}
+}
+
+func TestStreamingSearchStatusError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "rate limited", http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	_, err := New("token", WithBaseURL(server.URL)).StreamingSearch(context.Background(), &StreamingSearchRequest{Query: "hello"})
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) {
+		t.Fatalf("StreamingSearch() error = %v, want *StatusError", err)
+	}
+	if statusErr.StatusCode != http.StatusTooManyRequests || !strings.Contains(statusErr.Body, "rate limited") {
+		t.Errorf("StatusError = %+v", statusErr)
+	}
+}
+
+func TestStreamContextCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	body := "event: youChatToken\ndata: {\"youChatToken\": \"a\"}\n\nevent: youChatToken\ndata: {\"youChatToken\": \"b\"}\n\n"
+	stream := NewStream(ctx, io.NopCloser(strings.NewReader(body)))
+
+	if !stream.Next() {
+		t.Fatalf("Next() = false, want first event")
+	}
+	cancel()
+	if stream.Next() {
+		t.Errorf("Next() after cancel = true, want false")
+	}
+	if err := stream.Err(); !errors.Is(err, context.Canceled) {
+		t.Errorf("Err() = %v, want context.Canceled", err)
+	}
+}
+
+func TestStreamCancelClosesBody(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	reader, writer := io.Pipe()
+	stream := NewStream(ctx, reader)
+	defer stream.Close()
+
+	go func() {
+		io.WriteString(writer, "event: youChatToken\ndata: {\"youChatToken\": \"a\"}\n")
+		// 不再写入，模拟上游长时间没有输出
+	}()
+	if !stream.Next() {
+		t.Fatalf("Next() = false, want first event")
+	}
+
+	done := make(chan bool)
+	go func() { done <- stream.Next() }()
+	cancel()
+	if <-done {
+		t.Errorf("blocked Next() after cancel = true, want false")
+	}
+	if err := stream.Err(); !errors.Is(err, context.Canceled) {
+		t.Errorf("Err() = %v, want context.Canceled", err)
+	}
+	if _, err := writer.Write([]byte("x")); !errors.Is(err, io.ErrClosedPipe) {
+		t.Errorf("body not closed after cancel, write error = %v", err)
+	}
+}
+
+func TestUpload(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/get_nonce":
+			io.WriteString(w, " nonce-1\n")
+		case "/api/upload":
+			file, header, err := r.FormFile("file")
+			if err != nil {
+				t.Fatalf("FormFile() error = %v", err)
+			}
+			data, _ := io.ReadAll(file)
+			if header.Filename != "a.txt" || string(data) != "hello" {
+				t.Errorf("uploaded %q = %q", header.Filename, data)
+			}
+			io.WriteString(w, `{"filename": "stored.txt", "user_filename": "a.txt"}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	client := New("token", WithBaseURL(server.URL))
+	nonce, err := client.GetNonce(context.Background())
+	if err != nil || nonce.Uuid != "nonce-1" {
+		t.Fatalf("GetNonce() = %+v, %v, want nonce-1", nonce, err)
+	}
+
+	uploadResp, err := client.Upload(context.Background(), &UploadRequest{Filename: "a.txt", Data: []byte("hello")})
+	if err != nil {
+		t.Fatalf("Upload() error = %v", err)
+	}
+	want := Source{SourceType: "user_file", Filename: "stored.txt", UserFilename: "a.txt", SizeBytes: 5}
+	if source := uploadResp.Source(5); source != want {
+		t.Errorf("Source() = %+v, want %+v", source, want)
+	}
+}
+
+func TestStreamUnread(t *testing.T) {
+	body := "event: youChatToken\ndata: {\"youChatToken\": \"a\"}\n\nevent: youChatToken\ndata: {\"youChatToken\": \"b\"}\n\n"
+	stream := NewStream(context.Background(), io.NopCloser(strings.NewReader(body)))
+	defer stream.Close()
+
+	// 读到结尾后放回全部事件，仍然可以完整读取一遍
+	var peeked []Event
+	for stream.Next() {
+		peeked = append(peeked, stream.Event())
+	}
+	stream.Unread(peeked...)
+
+	var tokens []string
+	for stream.Next() {
+		token, _ := stream.Event().Token()
+		tokens = append(tokens, token)
+	}
+	if err := stream.Err(); err != nil || strings.Join(tokens, "") != "ab" {
+		t.Errorf("tokens after Unread = %v, %v, want [a b]", tokens, err)
+	}
+}
@@ -0,0 +1,146 @@
+package youclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ChatEntry 是聊天历史中的一问一答。
+type ChatEntry struct {
+	Question string `json:"question"`
+	Answer   string `json:"answer"`
+}
+
+// Source 是通过 upload 上传后可以在查询中引用的文件。
+type Source struct {
+	SourceType   string `json:"source_type"` // 上传的文件为 user_file
+	Filename     string `json:"filename"`
+	UserFilename string `json:"user_filename"`
+	SizeBytes    int    `json:"size_bytes"`
+}
+
+// StreamingSearchRequest 定义了 /api/streamingSearch 的查询参数。
+type StreamingSearchRequest struct {
+	Query      string
+	Page       int    // 默认为 1
+	Count      int    // 搜索结果数量，默认为 10
+	SafeSearch string // Off、Moderate 或 Strict
+	Market     string // 例如 zh-HK
+
+	ChatID             string // 为空时自动生成
+	ConversationTurnID string // 为空时自动生成
+	Chat               []ChatEntry
+	Sources            []Source
+
+	SelectedAIModel  string // 仅在 custom 模式下使用
+	SelectedChatMode string
+
+	// Extra 中的参数原样追加到查询中，用于未建模的开关。
+	Extra url.Values
+}
+
+// query 构建请求的查询参数。
+func (r *StreamingSearchRequest) query() (url.Values, error) {
+	chatID := r.ChatID
+	if chatID == "" {
+		chatID = uuid.New().String()
+	}
+	turnID := r.ConversationTurnID
+	if turnID == "" {
+		turnID = uuid.New().String()
+	}
+	page := r.Page
+	if page == 0 {
+		page = 1
+	}
+	count := r.Count
+	if count == 0 {
+		count = 10
+	}
+
+	chat := r.Chat
+	if chat == nil {
+		chat = []ChatEntry{}
+	}
+	chatJSON, err := json.Marshal(chat)
+	if err != nil {
+		return nil, err
+	}
+
+	q := url.Values{}
+	q.Set("q", r.Query)
+	q.Set("page", strconv.Itoa(page))
+	q.Set("count", strconv.Itoa(count))
+	if r.SafeSearch != "" {
+		q.Set("safeSearch", r.SafeSearch)
+	}
+	if r.Market != "" {
+		q.Set("mkt", r.Market)
+	}
+	q.Set("domain", "youchat")
+	q.Set("queryTraceId", chatID)
+	q.Set("chatId", chatID)
+	q.Set("conversationTurnId", turnID)
+	q.Set("pastChatLength", strconv.Itoa(len(chat)))
+	q.Set("traceId", fmt.Sprintf("%s|%s|%s", chatID, turnID, time.Now().Format(time.RFC3339)))
+	if r.SelectedChatMode != "" {
+		q.Set("selectedChatMode", r.SelectedChatMode)
+	}
+	if r.SelectedAIModel != "" {
+		q.Set("selectedAiModel", r.SelectedAIModel)
+	}
+	if len(r.Sources) > 0 {
+		sourcesJSON, err := json.Marshal(r.Sources)
+		if err != nil {
+			return nil, err
+		}
+		q.Set("sources", string(sourcesJSON))
+	}
+	q.Set("chat", string(chatJSON))
+	for name, values := range r.Extra {
+		for _, value := range values {
+			q.Add(name, value)
+		}
+	}
+	return q, nil
+}
+
+// NewStreamingSearchRequest 创建 streamingSearch 的 HTTP 请求，可以稍后通过 Do 发送。
+func (c *Client) NewStreamingSearchRequest(ctx context.Context, r *StreamingSearchRequest) (*http.Request, error) {
+	q, err := r.query()
+	if err != nil {
+		return nil, err
+	}
+	req, err := c.newRequest(ctx, http.MethodGet, "/api/streamingSearch?"+q.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	return req, nil
+}
+
+// Do 发送由 NewStreamingSearchRequest 创建的请求并返回事件流，
+// 状态码不是 200 时返回 *StatusError。
+func (c *Client) Do(req *http.Request) (*Stream, error) {
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	return NewStream(req.Context(), resp.Body), nil
+}
+
+// StreamingSearch 发送 streamingSearch 请求并返回事件流。
+func (c *Client) StreamingSearch(ctx context.Context, r *StreamingSearchRequest) (*Stream, error) {
+	req, err := c.NewStreamingSearchRequest(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+	return c.Do(req)
+}
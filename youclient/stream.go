@@ -0,0 +1,172 @@
+package youclient
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"strings"
+)
+
+// streamingSearch 响应中的事件名称。
+const (
+	EventToken         = "youChatToken"            // 回答的一个 token
+	EventSearchResults = "thirdPartySearchResults" // 网页搜索结果
+)
+
+// Event 是 streamingSearch 响应中的一个 SSE 事件，Data 为去掉 "data: " 前缀的数据行。
+type Event struct {
+	Name string
+	Data string
+}
+
+// Token 返回 youChatToken 事件携带的 token。
+func (e Event) Token() (string, bool) {
+	if e.Name != EventToken {
+		return "", false
+	}
+	var token struct {
+		YouChatToken string `json:"youChatToken"`
+	}
+	if err := json.Unmarshal([]byte(e.Data), &token); err != nil {
+		return "", false
+	}
+	return token.YouChatToken, true
+}
+
+// SearchResult 是 thirdPartySearchResults 事件中的单条搜索结果。
+type SearchResult struct {
+	URL        string `json:"url"`
+	Name       string `json:"name"`
+	Snippet    string `json:"snippet"`
+	DisplayURL string `json:"displayUrl,omitempty"`
+	PageAge    string `json:"page_age,omitempty"`
+}
+
+// SearchResults 返回 thirdPartySearchResults 事件中带有 URL 的搜索结果，
+// 结果位于 search.third_party_search_results 中。
+func (e Event) SearchResults() []SearchResult {
+	if e.Name != EventSearchResults {
+		return nil
+	}
+	var payload struct {
+		Search struct {
+			Results []SearchResult `json:"third_party_search_results"`
+		} `json:"search"`
+		Results []SearchResult `json:"third_party_search_results"`
+	}
+	if err := json.Unmarshal([]byte(e.Data), &payload); err != nil {
+		return nil
+	}
+	results := payload.Search.Results
+	if len(results) == 0 {
+		results = payload.Results
+	}
+
+	var valid []SearchResult
+	for _, result := range results {
+		if result.URL != "" {
+			valid = append(valid, result)
+		}
+	}
+	return valid
+}
+
+// Stream 逐个读取 streamingSearch 响应中的事件：
+//
+//	for stream.Next() {
+//		event := stream.Event()
+//	}
+//	if err := stream.Err(); err != nil { ... }
+//
+// 使用完毕后必须调用 Close。
+type Stream struct {
+	ctx     context.Context
+	body    io.ReadCloser
+	stop    func() bool
+	scanner *bufio.Scanner
+	pending []Event // Unread 放回的事件，先于响应体返回
+	event   Event
+	err     error
+}
+
+// NewStream 从响应体创建事件流。ctx 被取消时立即关闭响应体，
+// 正在阻塞的 Next 随之返回 false，Err 返回 ctx 的错误。
+func NewStream(ctx context.Context, body io.ReadCloser) *Stream {
+	scanner := bufio.NewScanner(body)
+
+	// 设置 scanner 的缓冲区大小（可选，但对于大型响应很重要）
+	buf := make([]byte, 0, 64*1024)
+	scanner.Buffer(buf, 1024*1024)
+
+	stop := context.AfterFunc(ctx, func() { body.Close() })
+	return &Stream{ctx: ctx, body: body, stop: stop, scanner: scanner}
+}
+
+// Next 读取下一个事件，读取结束、出错或 ctx 被取消时返回 false。
+func (s *Stream) Next() bool {
+	if len(s.pending) > 0 && s.ctx.Err() == nil {
+		s.event, s.pending = s.pending[0], s.pending[1:]
+		return true
+	}
+	for s.err == nil {
+		if err := s.ctx.Err(); err != nil {
+			s.err = err
+			return false
+		}
+		if !s.scanner.Scan() {
+			s.err = s.scanner.Err()
+			if err := s.ctx.Err(); err != nil {
+				s.err = err // 响应体因 ctx 取消被关闭，读取错误没有意义
+			} else if s.err == nil {
+				s.err = io.EOF
+			}
+			break
+		}
+		line := s.scanner.Text()
+		if !strings.HasPrefix(line, "event: ") {
+			continue
+		}
+		name := strings.TrimSpace(strings.TrimPrefix(line, "event: "))
+		if !s.scanner.Scan() { // 读取下一行 (data 行)
+			continue
+		}
+		data := s.scanner.Text()
+		if !strings.HasPrefix(data, "data: ") {
+			continue // 如果不是 data 行，则跳过
+		}
+		s.event = Event{Name: name, Data: strings.TrimPrefix(data, "data: ")}
+		return true
+	}
+	return false
+}
+
+// Unread 将已读取的 events 放回流的开头，之后的 Next 先依次返回它们，
+// 用于预读响应的开头后再交给其他代码完整读取。
+func (s *Stream) Unread(events ...Event) {
+	s.pending = append(append([]Event(nil), events...), s.pending...)
+}
+
+// Event 返回 Next 读取到的当前事件。
+func (s *Stream) Event() Event {
+	return s.event
+}
+
+// Err 返回读取过程中的错误，正常读取到结尾时返回 nil。
+func (s *Stream) Err() error {
+	if s.err == io.EOF {
+		return nil
+	}
+	if s.err == nil {
+		if err := s.ctx.Err(); err != nil {
+			return err
+		}
+	}
+	return s.err
+}
+
+// Close 关闭响应体，可以在读取结束前调用以提前断开上游连接。
+func (s *Stream) Close() error {
+	s.stop()
+	return s.body.Close()
+}
@@ -0,0 +1,109 @@
+package youclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strings"
+)
+
+// NonceResponse 是 /api/get_nonce 的响应，上传文件前需要先获取。
+type NonceResponse struct {
+	Uuid string
+}
+
+// UploadRequest 定义了要上传的文件。
+type UploadRequest struct {
+	Filename    string
+	ContentType string // 默认为 application/octet-stream
+	Data        []byte
+}
+
+// UploadResponse 是 /api/upload 的响应。
+type UploadResponse struct {
+	Filename     string `json:"filename"`
+	UserFilename string `json:"user_filename"`
+}
+
+// Source 返回可在 StreamingSearchRequest.Sources 中引用的文件源，size 为原始内容的字节数。
+func (r *UploadResponse) Source(size int) Source {
+	return Source{
+		SourceType:   "user_file",
+		Filename:     r.Filename,
+		UserFilename: r.UserFilename,
+		SizeBytes:    size,
+	}
+}
+
+// GetNonce 获取上传文件所需的 nonce。
+func (c *Client) GetNonce(ctx context.Context) (*NonceResponse, error) {
+	req, err := c.newRequest(ctx, http.MethodGet, "/api/get_nonce", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	// 读取完整的响应内容
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("读取响应失败: %v", err)
+	}
+
+	// 直接使用响应内容作为 UUID
+	return &NonceResponse{
+		Uuid: strings.TrimSpace(string(body)),
+	}, nil
+}
+
+// Upload 以 multipart 表单上传文件。
+func (c *Client) Upload(ctx context.Context, r *UploadRequest) (*UploadResponse, error) {
+	contentType := r.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	header := make(textproto.MIMEHeader)
+	header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="file"; filename="%s"`, strings.ReplaceAll(r.Filename, `"`, "")))
+	header.Set("Content-Type", contentType)
+	part, err := writer.CreatePart(header)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := part.Write(r.Data); err != nil {
+		return nil, err
+	}
+	writer.Close()
+
+	req, err := c.newRequest(ctx, http.MethodPost, "/api/upload", body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	var uploadResp UploadResponse
+	if err := json.Unmarshal(respBody, &uploadResp); err != nil {
+		return nil, err
+	}
+	return &uploadResp, nil
+}